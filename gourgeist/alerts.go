@@ -0,0 +1,472 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Severities of the alerts raised outside of the rules, any label not
+// listed here is a warning.
+var alertSeverities = map[string]defs.Severity{
+	defs.RapidFallLabel:    defs.Urgent,
+	defs.DoubleDoseLabel:   defs.Urgent,
+	defs.DataRestoredLabel: defs.Info,
+}
+
+// severityOf returns the severity of alerts with the label, as prioritized
+// in the config if it is.
+func (an *Analyzer) severityOf(label string) defs.Severity {
+	if sev, ok := an.Priorities[label]; ok {
+		return sev
+	}
+	if sev, ok := alertSeverities[label]; ok {
+		return sev
+	}
+	return defs.Warning
+}
+
+// held reports whether alerts with the severity are held back for the
+// digest at t, as only urgent alerts are sent during the quiet hours.
+func (an *Analyzer) held(sev defs.Severity, t time.Time) bool {
+	return sev < defs.Urgent && an.Quiet != nil && an.Quiet.Contains(t.In(an.Location))
+}
+
+func (an *Analyzer) genAndSendAlert(ctx context.Context, label, reason string) error {
+	return an.sendAlert(ctx, label, reason, true)
+}
+
+func (an *Analyzer) sendAlert(ctx context.Context, label, reason string, mention bool) error {
+	return an.raise(ctx, &defs.Alert{
+		Time:     time.Now(),
+		Label:    label,
+		Reason:   reason,
+		Severity: an.severityOf(label),
+	}, mention, defs.AlertsChannel)
+}
+
+// raise records the alert and sends it to the channels.
+func (an *Analyzer) raise(ctx context.Context, alert *defs.Alert, mention bool, channels ...string) error {
+	return an.raiseWith(ctx, alert, mention, nil, channels...)
+}
+
+// raiseWith is raise, with buttons shown before the acknowledge and snooze
+// buttons of the alert.
+func (an *Analyzer) raiseWith(ctx context.Context, alert *defs.Alert, mention bool,
+	buttons []defs.ButtonData, channels ...string) error {
+	alert.Quiet = an.held(alert.Severity, alert.Time)
+	res, err := an.Store.WriteAlert(ctx, alert)
+	if err != nil {
+		return err
+	}
+	alert.ID = res.UpsertedID
+	an.publish(ctx, *alert, notify.AlertRaised)
+	if alert.Quiet {
+		return nil
+	}
+
+	return an.notify(alert, "⚠️ "+alert.Label, mention, buttons, channels...)
+}
+
+// notify sends the alert to the channels under the title, along with its
+// acknowledge and snooze buttons.
+func (an *Analyzer) notify(alert *defs.Alert, title string, mention bool, buttons []defs.ButtonData,
+	channels ...string) error {
+	buttons = append(buttons, alertButtons(*alert)...)
+
+	content := fmt.Sprintln(title) + alert.Reason
+	if mention {
+		content = fmt.Sprintln(content) + "@everyone"
+	}
+
+	for _, ch := range channels {
+		_, err := an.Messager.SendMessage(defs.MessageData{
+			Content:         content,
+			Buttons:         buttons,
+			MentionEveryone: mention,
+		}, ch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish sends the alert to the notifiers, if any, even when it is held
+// back for the digest.
+func (an *Analyzer) publish(ctx context.Context, alert defs.Alert, change string) {
+	if an.Notifier == nil {
+		return
+	}
+	if err := an.Notifier.Notify(ctx, notify.Alert(alert, change)); err != nil {
+		an.Logger.Warn("unable to notify of alert", zap.String("label", alert.Label), zap.Error(err))
+	}
+}
+
+// worsen notifies the open alert again with the worsened glucose. It needs
+// to be acknowledged again, and restarts its escalation.
+func (an *Analyzer) worsen(ctx context.Context, alert *defs.Alert, reason string, glucose float64,
+	mention bool, channels ...string) error {
+	now := time.Now()
+	alert.Reason, alert.Value = reason, glucose
+	alert.AckedAt, alert.AckedBy = time.Time{}, ""
+	alert.Escalation, alert.EscalatedAt = defs.EscalateChannel, now
+	alert.Transition(now, defs.AlertTriggered, glucose, "worsened")
+
+	// The acknowledgement is cleared explicitly, as zero fields are left out
+	// of a whole alert update.
+	u := mg.AlertUpdate{
+		Set: map[string]interface{}{
+			"reason":      alert.Reason,
+			"value":       alert.Value,
+			"state":       alert.State,
+			"escalation":  alert.Escalation,
+			"escalatedAt": alert.EscalatedAt,
+		},
+		Unset:      []string{"ackedAt", "ackedBy"},
+		Event:      &alert.Events[len(alert.Events)-1],
+		Unresolved: true,
+	}
+	held := an.held(alert.Severity, now)
+	if held {
+		alert.Quiet, alert.DigestedAt = true, time.Time{}
+		u.Set["quiet"] = true
+		u.Unset = append(u.Unset, "digestedAt")
+	}
+	// An alert resolved since it was read is left as it is.
+	updated, err := an.Store.UpdateAlertFields(ctx, string(alert.ID), u)
+	if err != nil {
+		return fmt.Errorf("unable to update alert: %w", err)
+	}
+	if !updated {
+		return nil
+	}
+	an.publish(ctx, *alert, notify.AlertWorsened)
+	if held {
+		return nil
+	}
+
+	return an.notify(alert, "⚠️ "+alert.Label+" (worsening)", mention, nil, channels...)
+}
+
+// resolve closes the open alert, and lets the channels know the glucose is
+// back in range. During the quiet hours, the resolution is left to the
+// digest instead.
+func (an *Analyzer) resolve(ctx context.Context, alert *defs.Alert, glucose float64,
+	channels ...string) error {
+	now := time.Now()
+	alert.Transition(now, defs.AlertResolved, glucose, "")
+	u := mg.AlertUpdate{
+		Set:        map[string]interface{}{"state": alert.State, "resolvedAt": alert.ResolvedAt},
+		Event:      &alert.Events[len(alert.Events)-1],
+		Unresolved: true,
+	}
+	held := an.held(alert.Severity, now)
+	if held {
+		alert.Quiet, alert.DigestedAt = true, time.Time{}
+		u.Set["quiet"] = true
+		u.Unset = []string{"digestedAt"}
+	}
+	updated, err := an.Store.UpdateAlertFields(ctx, string(alert.ID), u)
+	if err != nil {
+		return fmt.Errorf("unable to resolve alert: %w", err)
+	}
+	if !updated {
+		return nil
+	}
+	an.publish(ctx, *alert, notify.AlertResolved)
+	if held {
+		return nil
+	}
+
+	for _, ch := range channels {
+		_, err := an.Messager.SendMessage(defs.MessageData{
+			Content: fmt.Sprintf(
+				"✅ %s resolved, back in range after %s\ncurrent value: %.2f",
+				alert.Label, now.Sub(alert.Time).Round(time.Minute), glucose,
+			),
+		}, ch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AnalyzeDigest sends the alerts held back during the quiet hours, in a
+// single message once they are over.
+func (an *Analyzer) AnalyzeDigest(ctx context.Context) error {
+	now := time.Now()
+	if an.Quiet == nil || an.Quiet.Contains(now.In(an.Location)) {
+		return nil
+	}
+
+	alerts, err := an.Store.ReadAlerts(ctx, now.Add(-24*time.Hour), now)
+	if err != nil {
+		return err
+	}
+
+	content := ""
+	held := make([]defs.Alert, 0)
+	for _, alert := range alerts {
+		if !alert.Quiet || !alert.DigestedAt.IsZero() {
+			continue
+		}
+		held = append(held, alert)
+
+		reason := strings.SplitN(alert.Reason, "\n", 2)[0]
+		switch {
+		case alert.Resolved():
+			reason = fmt.Sprintf("%s (resolved at %s)",
+				reason, alert.ResolvedAt.In(an.Location).Format(discgo.TimeFormat))
+		case alert.State != "":
+			reason = fmt.Sprintf("%s (%s)", reason, alert.State)
+		}
+		content += fmt.Sprintf("\n%s %s: %s",
+			alert.Time.In(an.Location).Format(discgo.TimeFormat), alert.Label, reason)
+	}
+	if len(held) == 0 {
+		return nil
+	}
+
+	_, err = an.Messager.SendMessage(defs.MessageData{
+		Content: fmt.Sprintf("🌅 %d alerts during the quiet hours", len(held)) + content,
+	}, defs.AlertsChannel)
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range held {
+		u := mg.AlertUpdate{Set: map[string]interface{}{"digestedAt": now}, Undigested: true}
+		if _, err := an.Store.UpdateAlertFields(ctx, string(alert.ID), u); err != nil {
+			return fmt.Errorf("unable to update alert: %w", err)
+		}
+	}
+	return nil
+}
+
+// latestAlert returns the latest alert with the label, if any.
+func (an *Analyzer) latestAlert(ctx context.Context, label string, t time.Time) (*defs.Alert, error) {
+	alerts, err := an.Store.ReadAlerts(ctx, t.Add(defs.LookbackInterval), t)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(alerts) - 1; i >= 0; i-- {
+		if alerts[i].Label == label {
+			return &alerts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// silenced reports whether alerts with the label should be held back,
+// either because one was sent within the timeout (in minutes), or because
+// it was snoozed.
+func (an *Analyzer) silenced(ctx context.Context, label string, timeout int) bool {
+	now := time.Now()
+	cutoff := now.Add(time.Duration(-timeout) * time.Minute)
+	start := now.Add(defs.LookbackInterval)
+	if cutoff.Before(start) {
+		start = cutoff
+	}
+
+	// If we get an error, assume no previous alerts were sent.
+	alerts, _ := an.Store.ReadAlerts(ctx, start, now)
+	for _, alert := range alerts {
+		if alert.Label != label {
+			continue
+		}
+		if !alert.Time.Before(cutoff) || alert.Snoozed(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// EscalateAlerts moves urgent alerts that are left unacknowledged one step
+// along the escalation chain every escalation timeout.
+func (an *Analyzer) EscalateAlerts(ctx context.Context) error {
+	timeout := time.Duration(an.AlarmConfig.Escalation.Timeout) * time.Minute
+	if timeout <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	alerts, err := an.Store.ReadAlerts(ctx, now.Add(defs.LookbackInterval), now)
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent alert of each label is escalated.
+	latest := make(map[string]defs.Alert)
+	for _, alert := range alerts {
+		latest[alert.Label] = alert
+	}
+
+	for _, alert := range latest {
+		if alert.Severity < defs.Urgent || alert.Acked() || alert.Resolved() || alert.Snoozed(now) ||
+			alert.Escalation >= defs.EscalateEveryone {
+			continue
+		}
+
+		last := alert.Time
+		if !alert.EscalatedAt.IsZero() {
+			last = alert.EscalatedAt
+		}
+		if now.Sub(last) < timeout {
+			continue
+		}
+
+		alert := alert
+		if err := an.escalate(ctx, &alert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (an *Analyzer) escalate(ctx context.Context, alert *defs.Alert) error {
+	msg := defs.MessageData{
+		Content: fmt.Sprintln("🚨 Unacknowledged "+alert.Label) + alert.Reason,
+		Buttons: alertButtons(*alert),
+	}
+	esc := an.AlarmConfig.Escalation
+
+	// Steps without anyone to notify are skipped, as are those that reached
+	// no one.
+	for alert.Escalation < defs.EscalateEveryone {
+		claimed, err := an.claimEscalation(ctx, alert)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return nil
+		}
+
+		var recipients []uint64
+		switch alert.Escalation {
+		case defs.EscalatePatient:
+			if esc.Patient != 0 {
+				recipients = append(recipients, esc.Patient)
+			}
+		case defs.EscalateCaregivers:
+			recipients = esc.Caregivers
+		case defs.EscalateEveryone:
+			msg.Content = fmt.Sprintln(msg.Content) + "@everyone"
+			msg.MentionEveryone = true
+			if _, err := an.Messager.SendMessage(msg, defs.AlertsChannel); err != nil {
+				return err
+			}
+		}
+
+		if an.deliver(msg, recipients) {
+			break
+		}
+	}
+
+	an.Logger.Debug("escalated alert",
+		zap.String("label", alert.Label),
+		zap.Int("level", alert.Escalation),
+	)
+	an.publish(ctx, *alert, notify.AlertEscalated)
+	return nil
+}
+
+// deliver sends the message directly to each of the recipients, reporting
+// whether it reached any of them. If none of the direct messages went
+// through, as some displays cannot send them, the recipients are mentioned
+// in the alerts channel instead.
+func (an *Analyzer) deliver(msg defs.MessageData, recipients []uint64) bool {
+	if len(recipients) == 0 {
+		return false
+	}
+
+	var sent bool
+	for _, userID := range recipients {
+		if _, err := an.Messager.SendDirectMessage(msg, userID); err != nil {
+			an.Logger.Debug("unable to send direct message",
+				zap.Uint64("user", userID),
+				zap.Error(err),
+			)
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return true
+	}
+
+	msg.Mentions = recipients
+	if _, err := an.Messager.SendMessage(msg, defs.AlertsChannel); err != nil {
+		an.Logger.Warn("unable to mention in the alerts channel", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// claimEscalation moves the alert to the next step of the escalation before
+// anyone is notified, reporting whether it was still unacknowledged, open
+// and at its step. Otherwise it was dealt with since it was read, and must
+// not be escalated.
+func (an *Analyzer) claimEscalation(ctx context.Context, alert *defs.Alert) (bool, error) {
+	level, now := alert.Escalation, time.Now()
+	claimed, err := an.Store.UpdateAlertFields(ctx, string(alert.ID), mg.AlertUpdate{
+		Set:        map[string]interface{}{"escalation": level + 1, "escalatedAt": now},
+		Unresolved: true,
+		Unacked:    true,
+		Escalation: &level,
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to escalate alert: %w", err)
+	}
+	if claimed {
+		alert.Escalation, alert.EscalatedAt = level+1, now
+	}
+	return claimed, nil
+}
+
+// alertButtons returns the buttons to acknowledge or snooze the alert.
+func alertButtons(alert defs.Alert) []defs.ButtonData {
+	if alert.Severity == defs.Info || alert.ID == "" {
+		return nil
+	}
+
+	id := defs.CommandInteractionOption{Name: "id", Value: string(alert.ID)}
+	snooze := func(minutes int) defs.ButtonData {
+		return defs.ButtonData{
+			Label: fmt.Sprintf("Snooze %dm", minutes),
+			Style: defs.SecondaryButton,
+			Command: defs.CommandInteraction{
+				Name: defs.SnoozeCmd,
+				Options: []defs.CommandInteractionOption{
+					{Name: "minutes", Value: strconv.Itoa(minutes)},
+					id,
+				},
+			},
+		}
+	}
+
+	return []defs.ButtonData{
+		{
+			Label: "Acknowledge",
+			Style: defs.PrimaryButton,
+			Command: defs.CommandInteraction{
+				Name:    defs.AckCmd,
+				Options: []defs.CommandInteractionOption{id},
+			},
+		},
+		snooze(30),
+		snooze(120),
+	}
+}
@@ -4,8 +4,21 @@ import (
 	"context"
 	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/carbs"
+	"iv2/gourgeist/pkg/checks"
 	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/insulin"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/rules"
+	"iv2/gourgeist/pkg/schedule"
+	"iv2/gourgeist/pkg/stats"
+	"iv2/gourgeist/pkg/targets"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,130 +27,473 @@ import (
 type AnalyzerStore interface {
 	mg.GlucoseStore
 	mg.InsulinStore
+	mg.CarbStore
 	mg.AlertStore
+	mg.TempTargetStore
+	mg.TreatmentStore
+	mg.SensorStore
 }
 
 type Analyzer struct {
 	Messager discgo.Messager
 	Store    AnalyzerStore
+	Fetcher  FetchMonitor
+	Rules    []*rules.Rule
+	Targets  *targets.Resolver
+	Insulin  *insulin.Model
+	Carbs    *carbs.Model
+	Therapy  *bolus.Profiles
+
+	// Quiet are the quiet hours, if any, and Priorities override the
+	// severities of the alerts raised outside of the rules.
+	Quiet      *schedule.Window
+	Priorities map[string]defs.Severity
+
+	// Quality flags the readings that are likely sensor artifacts, which
+	// never trigger low alerts.
+	Quality *quality.Detector
+
+	// Notifier sends the alerts outside of Discord, if set.
+	Notifier notify.Notifier
+
+	// Checks are registered by RegisterChecks, and run by Run.
+	Checks *checks.Registry
 
 	Logger        *zap.Logger
 	Location      *time.Location
 	GlucoseConfig defs.GlucoseConfig
 	AlarmConfig   defs.AlarmConfig
+	SensorConfig  defs.SensorConfig
+}
+
+// RegisterChecks registers the checks of the analyzer, with the options
+// from the alarm config. Checks failing repeatedly raise an alert.
+func (an *Analyzer) RegisterChecks() error {
+	failures := an.AlarmConfig.CheckFailures
+	if failures <= 0 {
+		failures = defs.DefaultCheckFailures
+	}
+	an.Checks = checks.New(an.Logger, failures, an.alertCheckFailing)
+
+	fns := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{"rules", an.AnalyzeRules},
+		{"rate", an.AnalyzeRateOfChange},
+		{"stale", an.AnalyzeStaleData},
+		{"bolus", an.AnalyzeMissedBolus},
+		{"basal", an.AnalyzeBasal},
+		{"treatment", an.AnalyzeTreatments},
+		{"escalate", an.EscalateAlerts},
+		{"digest", an.AnalyzeDigest},
+		{"sensor", an.AnalyzeSensor},
+		{"quality", an.AnalyzeQuality},
+	}
+	for _, f := range fns {
+		cfg := an.AlarmConfig.Checks[f.name]
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = defs.DefaultCheckTimeout
+		}
+
+		err := an.Checks.Register(checks.Func(f.name, f.fn), checks.Options{
+			Interval: time.Duration(cfg.Interval) * time.Minute,
+			Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range an.AlarmConfig.Checks {
+		if _, ok := an.Checks.Metrics()[name]; !ok {
+			return fmt.Errorf("unknown check: %s", name)
+		}
+	}
+	return nil
 }
 
+// Run runs the checks that are due, concurrently, and logs how each of
+// them went.
 func (an *Analyzer) Run() error {
-	checks := map[string]func() error{
-		"glucose": an.AnalyzeGlucose,
-		"insulin": an.AnalyzeInsulin,
-	}
-	for name, check := range checks {
-		if err := check(); err != nil {
-			an.Logger.Debug(
-				"unable to complete check",
-				zap.String("check", name),
-				zap.Error(err),
-			)
+	if an.Checks == nil {
+		return fmt.Errorf("checks are not registered")
+	}
+	start := time.Now()
+	an.Checks.Run(context.Background())
+
+	metrics := an.Checks.Metrics()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := metrics[name]
+		if m.LastRun.Before(start) {
+			continue
 		}
+		an.Logger.Debug("ran check",
+			zap.String("check", name),
+			zap.Duration("duration", m.Duration),
+			zap.Int("runs", m.Runs),
+			zap.Int("failures", m.Failures),
+		)
 	}
 	return nil
 }
 
-func (an *Analyzer) AnalyzeGlucose() error {
-	ctx := context.Background()
-	now, start := time.Now(), time.Now().Add(defs.LookbackInterval)
+func (an *Analyzer) alertCheckFailing(name string, m checks.Metrics) {
+	err := an.sendAlert(context.Background(), defs.CheckFailingLabel, fmt.Sprintf(
+		"check %s failed %d times in a row\nlast error: %s", name, m.Failures, m.LastError,
+	), false)
+	if err != nil {
+		an.Logger.Error("unable to alert on failing check", zap.String("check", name), zap.Error(err))
+	}
+}
 
-	glucose, err := an.Store.ReadGlucose(ctx, start, now)
+// AnalyzeRules evaluates all the alert rules. A failing rule does not keep
+// the others from being evaluated, their errors are returned together.
+func (an *Analyzer) AnalyzeRules(ctx context.Context) error {
+	failed := make([]string, 0)
+	for _, r := range an.Rules {
+		if err := an.AnalyzeRule(ctx, r); err != nil {
+			failed = append(failed, fmt.Sprintf("rule %s: %s", r.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to analyze rules: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// AnalyzeRule raises an alert if the condition of the rule holds, and the
+// rule is not in its cooldown. Rules on the glucose are tracked instead,
+// see trackRule.
+func (an *Analyzer) AnalyzeRule(ctx context.Context, r *rules.Rule) error {
+	now := time.Now()
+	start := now.Add(-r.Lookback())
+
+	// Insulin and carbs are read over a longer window, as they are still
+	// active for a while after being recorded, along with the glucose to
+	// refine the carb absorption.
+	obStart := start.Add(-onBoardDuration(an.Insulin, an.Carbs))
+	glucose, err := an.Store.ReadGlucose(ctx, obStart, now)
 	if err != nil {
 		return err
-	} else if len(glucose) == 0 {
+	}
+
+	ins, err := an.Store.ReadInsulin(ctx, obStart, now)
+	if err != nil {
+		return err
+	}
+
+	cs, err := an.Store.ReadCarbs(ctx, obStart, now)
+	if err != nil {
+		return err
+	}
+
+	ob := onBoard{
+		Insulin:     an.Insulin,
+		Carbs:       an.Carbs,
+		Doses:       ins,
+		CarbEntries: cs,
+		Glucose:     glucose,
+	}
+
+	tr, err := an.Targets.Load(ctx, an.Store, start, now)
+	if err != nil {
+		return err
+	}
+
+	data := rules.Data{
+		Now:        now,
+		Location:   an.Location,
+		Glucose:    glucose,
+		Insulin:    ins,
+		Carbs:      cs,
+		IOB:        ob.IOB,
+		COB:        ob.COB,
+		Thresholds: tr.Thresholds,
+		MaxAge:     an.noDataTimeout(),
+	}
+
+	// Suspect readings are left out of the rules on lows, and lows following
+	// what may be a compression are downgraded until it is clear they are
+	// not, unless very low.
+	var dropping bool
+	if r.Direction() < 0 {
+		data.Glucose = an.reliable(glucose)
+		dropping = an.Quality != nil && an.Quality.Dropping(glucose)
+	}
+
+	if r.Tracked() {
+		return an.trackRule(ctx, r, data, dropping)
+	}
+
+	ok, vals := r.Evaluate(data)
+	if !ok || an.silenced(ctx, r.Name, r.Cooldown) {
 		return nil
 	}
+	return an.raiseRule(ctx, r, vals, dropping)
+}
 
-	// If we get an error, assume no previous alerts were sent.
-	alertStart := now.Add(time.Duration(-1 * an.AlarmConfig.GlucoseTimeout * int(time.Minute)))
-	alerts, _ := an.Store.ReadAlerts(ctx, alertStart, now)
+// trackRule moves the open alert of a rule on the glucose through its
+// lifecycle. The alert is resolved once the glucose is back past the
+// threshold by the hysteresis, and while it is open, only glucose that
+// worsened by the delta since the last notification alerts again. Shortly
+// after it resolved, the glucose has to be past the threshold by the
+// hysteresis for it to trigger again.
+func (an *Analyzer) trackRule(ctx context.Context, r *rules.Rule, data rules.Data, dropping bool) error {
+	latest, err := an.latestAlert(ctx, r.Name, data.Now)
+	if err != nil {
+		return err
+	}
 
-	lowAlert, highAlert := true, true
-	for _, alert := range alerts {
-		switch alert.Label {
-		case defs.LowGlucoseLabel:
-			lowAlert = false
-		case defs.HighGlucoseLabel:
-			highAlert = false
+	hysteresis := an.AlarmConfig.Hysteresis
+	if hysteresis <= 0 {
+		hysteresis = defs.DefaultHysteresis
+	}
+
+	if latest == nil || !latest.Open() {
+		ok, vals := r.Evaluate(data)
+		if ok && latest != nil && latest.Resolved() && data.Now.Sub(latest.ResolvedAt) < an.reentry() {
+			ok, vals = r.Entered(data, hysteresis)
 		}
+		if !ok || an.silenced(ctx, r.Name, 0) {
+			return nil
+		}
+		return an.raiseRule(ctx, r, vals, dropping)
 	}
 
-	recentVal := glucose[len(glucose)-1].Mmol
-	if recentVal >= an.GlucoseConfig.High && highAlert {
-		return an.genAndSendAlert(
-			defs.HighGlucoseLabel,
-			fmt.Sprintf("current value: %.2f ≥ %.2f", recentVal, an.GlucoseConfig.High),
-		)
-	} else if recentVal <= an.GlucoseConfig.Low && lowAlert {
-		return an.genAndSendAlert(
-			defs.LowGlucoseLabel,
-			fmt.Sprintf("current value: %.2f ≤ %.2f", recentVal, an.GlucoseConfig.Low),
-		)
+	open := latest
+	if ok, vals := r.Recovered(data, hysteresis); ok {
+		return an.resolve(ctx, open, vals.Glucose, r.Channels...)
 	}
 
+	delta := an.AlarmConfig.Delta
+	if delta <= 0 {
+		delta = defs.DefaultAlertDelta
+	}
+	ok, vals := r.Evaluate(data)
+	if !ok || r.Direction() == 0 || open.Snoozed(data.Now) ||
+		float64(r.Direction())*(vals.Glucose-open.Value) < delta {
+		return nil
+	}
+
+	reason, err := r.Message(vals)
+	if err != nil {
+		return err
+	}
+	return an.worsen(ctx, open, reason, vals.Glucose, r.Severity() > defs.Info, r.Channels...)
+}
+
+// raiseRule raises the alert of a rule, tracking it if it is on the
+// glucose, and downgrading it to a warning if it may be a compression. Very
+// low glucose is never downgraded, the alert only notes the possible
+// compression. Low alerts also start a treatment, which follows up until
+// back in range.
+func (an *Analyzer) raiseRule(ctx context.Context, r *rules.Rule, vals rules.Values, compression bool) error {
+	reason, err := r.Message(vals)
+	if err != nil {
+		return err
+	}
+
+	alert := defs.Alert{
+		Time:     time.Now(),
+		Label:    r.Name,
+		Reason:   reason,
+		Severity: r.Severity(),
+	}
+	if compression && alert.Severity > defs.Warning && vals.Glucose >= stats.VeryLowThreshold {
+		alert.Severity = defs.Warning
+	}
+	if compression {
+		alert.Reason = fmt.Sprintln(alert.Reason) + "possible compression low, confirm with a finger stick"
+	}
+	if r.Tracked() {
+		alert.Value = vals.Glucose
+		alert.Transition(alert.Time, defs.AlertTriggered, vals.Glucose, "")
+	}
+	if err := an.raise(ctx, &alert, alert.Severity > defs.Info, r.Channels...); err != nil {
+		return err
+	}
+
+	if r.Name == defs.LowGlucoseLabel || r.Condition.Range == rules.BelowRange {
+		return an.startTreatment(ctx, alert, vals.Glucose)
+	}
 	return nil
 }
 
-func (an *Analyzer) AnalyzeInsulin() error {
-	// TODO: Need to make this check configurable.
-	ctx := context.Background()
-	now, start := time.Now(), time.Now().Add(-24*time.Hour)
+// AnalyzeRateOfChange alerts on a rapid rise or fall in glucose, computed
+// from the readings themselves rather than Dexcom's trend. This catches
+// crashes that are still within range.
+func (an *Analyzer) AnalyzeRateOfChange(ctx context.Context) error {
+	threshold := an.AlarmConfig.RateOfChange
+	if threshold <= 0 {
+		threshold = defs.DefaultRateOfChange
+	}
+	window := an.AlarmConfig.RateWindow
+	if window <= 0 {
+		window = defs.DefaultRateWindow
+	}
 
-	ins, err := an.Store.ReadInsulin(ctx, start, now)
+	now := time.Now()
+	start := now.Add(time.Duration(-window) * time.Minute)
+
+	glucose, err := an.Store.ReadGlucose(ctx, start, now)
 	if err != nil {
 		return err
+	} else if len(glucose) < 3 {
+		return nil
 	}
 
-	missingAlert := true
-	for _, in := range ins {
-		if in.Type == defs.SlowActing.String() {
-			missingAlert = false
-		}
+	// The readings need to cover the whole window for the change to be
+	// considered sustained, allowing for one missed reading at the start.
+	if glucose[0].Time.Sub(start) > 5*time.Minute {
+		return nil
+	}
+
+	roc, err := stats.RateOfChange(glucose)
+	if err != nil {
+		return err
+	} else if math.Abs(roc) < threshold {
+		return nil
+	}
+
+	label := defs.RapidRiseLabel
+	if roc < 0 {
+		label = defs.RapidFallLabel
+	}
+
+	if an.silenced(ctx, label, an.AlarmConfig.GlucoseTimeout) {
+		return nil
 	}
 
-	alertStart := now.Add(time.Duration(-1 * an.AlarmConfig.NoInsulinTimeout * int(time.Minute)))
-	alerts, _ := an.Store.ReadAlerts(ctx, alertStart, now)
+	return an.genAndSendAlert(
+		ctx,
+		label,
+		fmt.Sprintf(
+			"rate of change: %+.2f mmol/L/min over %d minutes, current value: %.2f",
+			roc, window, glucose[len(glucose)-1].Mmol,
+		),
+	)
+}
+
+// AnalyzeStaleData alerts when no new readings have arrived for a while,
+// with reminders for as long as the outage lasts and a notice once the
+// readings resume.
+func (an *Analyzer) AnalyzeStaleData(ctx context.Context) error {
+	now, start := time.Now(), time.Now().Add(defs.LookbackInterval)
+
+	glucose, err := an.Store.ReadGlucose(ctx, start, now)
+	if err != nil {
+		return err
+	}
+
+	var lastReading time.Time
+	if len(glucose) > 0 {
+		lastReading = glucose[len(glucose)-1].Time
+	}
+
+	// Collect the alerts sent for the current outage, if any.
+	alerts, _ := an.Store.ReadAlerts(ctx, start, now)
+	outage := make([]defs.Alert, 0)
 	for _, alert := range alerts {
 		switch alert.Label {
-		case defs.MissingSlowInsulinLabel:
-			missingAlert = false
+		case defs.NoDataLabel:
+			outage = append(outage, alert)
+		case defs.DataRestoredLabel:
+			outage = outage[:0]
+		}
+	}
+
+	if !lastReading.IsZero() && now.Sub(lastReading) < an.noDataTimeout() {
+		if len(outage) == 0 {
+			return nil
 		}
+		return an.sendAlert(
+			ctx,
+			defs.DataRestoredLabel,
+			fmt.Sprintf(
+				"latest reading: %s, first no data alert: %s",
+				lastReading.In(an.Location).Format(discgo.TimeFormat),
+				outage[0].Time.In(an.Location).Format(discgo.TimeFormat),
+			),
+			false,
+		)
+	}
+
+	since := fmt.Sprintf("no readings in the last %s", -defs.LookbackInterval)
+	if !lastReading.IsZero() {
+		since = fmt.Sprintf(
+			"last reading: %s (%s ago)",
+			lastReading.In(an.Location).Format(discgo.TimeFormat),
+			now.Sub(lastReading).Round(time.Minute),
+		)
 	}
+	reason := fmt.Sprintln(since) + "cause: " + an.diagnoseStaleData(now)
 
-	if missingAlert {
-		return an.genAndSendAlert(
-			defs.MissingSlowInsulinLabel,
-			fmt.Sprintf("last administered: ≥ %d hours ago", 24),
+	// The first notice is sent quietly, and any reminders after that
+	// notify everyone.
+	switch {
+	case len(outage) == 0:
+		return an.sendAlert(ctx, defs.NoDataLabel, reason, false)
+	case now.Sub(outage[len(outage)-1].Time) >= an.noDataReminder():
+		return an.sendAlert(
+			ctx,
+			defs.NoDataLabel,
+			fmt.Sprintf("reminder #%d\n%s", len(outage), reason),
+			true,
 		)
 	}
 
 	return nil
 }
 
-func (an *Analyzer) genAndSendAlert(label, reason string) error {
-	_, err := an.Store.WriteAlert(context.Background(), &defs.Alert{
-		Time:   time.Now(),
-		Label:  label,
-		Reason: reason,
-	})
-	if err != nil {
-		return err
+// diagnoseStaleData describes where the readings stopped flowing, based on
+// the state of the fetcher.
+func (an *Analyzer) diagnoseStaleData(now time.Time) string {
+	if an.Fetcher == nil {
+		return "unknown"
 	}
 
-	_, err = an.Messager.SendMessage(defs.MessageData{
-		Content:         fmt.Sprintln("⚠️ "+label) + fmt.Sprintln(reason) + "@everyone",
-		MentionEveryone: true,
-	}, defs.AlertsChannel)
-	if err != nil {
-		return err
+	status := an.Fetcher.Status()
+	switch {
+	case now.Sub(status.LastAttempt) >= an.noDataTimeout():
+		if status.LastAttempt.IsZero() {
+			return "fetcher down, no fetches attempted"
+		}
+		return fmt.Sprintf(
+			"fetcher down, last attempt: %s",
+			status.LastAttempt.In(an.Location).Format(discgo.TimeFormat),
+		)
+	case status.StoreErr != nil:
+		return fmt.Sprintf("fetcher down, unable to store readings: %s", status.StoreErr)
+	case status.ShareErr != nil:
+		return fmt.Sprintf("share errors: %s", status.ShareErr)
+	default:
+		return "sensor gap, share is responding without new readings"
 	}
+}
 
-	return nil
+func (an *Analyzer) reentry() time.Duration {
+	if an.AlarmConfig.Reentry <= 0 {
+		return defs.DefaultReentry * time.Minute
+	}
+	return time.Duration(an.AlarmConfig.Reentry) * time.Minute
+}
+
+func (an *Analyzer) noDataTimeout() time.Duration {
+	if an.AlarmConfig.NoDataTimeout <= 0 {
+		return defs.DefaultNoDataTimeout * time.Minute
+	}
+	return time.Duration(an.AlarmConfig.NoDataTimeout) * time.Minute
+}
+
+func (an *Analyzer) noDataReminder() time.Duration {
+	if an.AlarmConfig.NoDataReminder <= 0 {
+		return defs.DefaultNoDataReminder * time.Minute
+	}
+	return time.Duration(an.AlarmConfig.NoDataReminder) * time.Minute
 }
@@ -2,10 +2,18 @@ package gourgeist
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"iv2/gourgeist/defs"
 	"iv2/gourgeist/mocks"
+	"iv2/gourgeist/pkg/carbs"
+	"iv2/gourgeist/pkg/insulin"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/rules"
+	"iv2/gourgeist/pkg/schedule"
+	"iv2/gourgeist/pkg/targets"
 	"strings"
 	"testing"
 	"time"
@@ -51,10 +59,40 @@ func (suite *AnalyzerSuite) SetupSuite() {
 		panic(err)
 	}
 
+	rs, err := rules.FromConfig(nil, config.Alarm)
+	if err != nil {
+		panic(err)
+	}
+
+	tr, err := targets.New(config.Glucose, time.Local)
+	if err != nil {
+		panic(err)
+	}
+
+	im, err := insulin.New(config.Insulin)
+	if err != nil {
+		panic(err)
+	}
+
+	cm, err := carbs.New(config.Carbs)
+	if err != nil {
+		panic(err)
+	}
+
+	qd, err := quality.New(config.Quality, time.Local)
+	if err != nil {
+		panic(err)
+	}
+
 	msger := &mocks.Messager{Channels: make(map[string][]defs.MessageData)}
 	an := Analyzer{
 		Messager:      msger,
 		Store:         ms,
+		Rules:         rs,
+		Targets:       tr,
+		Insulin:       im,
+		Carbs:         cm,
+		Quality:       qd,
 		Logger:        zap.NewExample(),
 		Location:      time.Local,
 		GlucoseConfig: config.Glucose,
@@ -74,6 +112,28 @@ func (suite *AnalyzerSuite) AfterTest(_, _ string) {
 	suite.msger.Channels = make(map[string][]defs.MessageData)
 }
 
+func (suite *AnalyzerSuite) analyzeRule(name string) error {
+	for _, r := range suite.analyzer.Rules {
+		if r.Name == name {
+			return suite.analyzer.AnalyzeRule(context.Background(), r)
+		}
+	}
+	return fmt.Errorf("rule %s not found", name)
+}
+
+func (suite *AnalyzerSuite) TestRegisterChecks() {
+	an := *suite.analyzer
+	assert.NoError(suite.T(), an.RegisterChecks())
+	assert.Len(suite.T(), an.Checks.Metrics(), 10)
+	assert.NoError(suite.T(), an.Run())
+	for name, m := range an.Checks.Metrics() {
+		assert.Equal(suite.T(), 1, m.Runs, name)
+	}
+
+	an.AlarmConfig.Checks = map[string]defs.CheckConfig{"unknown": {Interval: 5}}
+	assert.Error(suite.T(), an.RegisterChecks())
+}
+
 func (suite *AnalyzerSuite) TestGlucoseAlerts() {
 	ctx := context.Background()
 	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
@@ -82,14 +142,40 @@ func (suite *AnalyzerSuite) TestGlucoseAlerts() {
 	})
 	assert.NoError(suite.T(), err)
 
-	assert.NoError(suite.T(), suite.analyzer.AnalyzeGlucose())
-	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	assert.NoError(suite.T(), suite.analyzeRule(defs.LowGlucoseLabel))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 2, "low alert and treatment prompt")
 
 	alert := suite.msger.Channels[defs.AlertsChannel][0]
 	label := "⚠️ " + defs.LowGlucoseLabel
 	assert.True(suite.T(), strings.Contains(alert.Content, label))
 }
 
+func (suite *AnalyzerSuite) TestFailingRule() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-15 * time.Minute),
+		Mmol: suite.analyzer.GlucoseConfig.Low - 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	bad, err := rules.New(defs.AlertRule{
+		Name:      "Bad Message",
+		Condition: defs.RuleCondition{Range: rules.BelowRange},
+		Message:   "{{.Missing}}",
+	})
+	assert.NoError(suite.T(), err)
+
+	an := *suite.analyzer
+	an.Rules = append([]*rules.Rule{bad}, suite.analyzer.Rules...)
+	assert.Error(suite.T(), an.AnalyzeRules(context.Background()))
+
+	var raised bool
+	for _, msg := range suite.msger.Channels[defs.AlertsChannel] {
+		raised = raised || strings.Contains(msg.Content, defs.LowGlucoseLabel)
+	}
+	assert.True(suite.T(), raised, "the rules after the failing one are still evaluated")
+}
+
 func (suite *AnalyzerSuite) TestHighGlucoseAlert() {
 	ctx := context.Background()
 	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
@@ -98,7 +184,7 @@ func (suite *AnalyzerSuite) TestHighGlucoseAlert() {
 	})
 	assert.NoError(suite.T(), err)
 
-	assert.NoError(suite.T(), suite.analyzer.AnalyzeGlucose())
+	assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
 	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
 
 	alert := suite.msger.Channels[defs.AlertsChannel][0]
@@ -106,6 +192,291 @@ func (suite *AnalyzerSuite) TestHighGlucoseAlert() {
 	assert.True(suite.T(), strings.Contains(alert.Content, label))
 }
 
+func (suite *AnalyzerSuite) TestHighGlucoseLifecycle() {
+	ctx := context.Background()
+	high := suite.analyzer.GlucoseConfig.High
+
+	for i, tc := range []struct {
+		mmol     float64
+		messages int
+	}{
+		{high + 1, 1},
+		{high - 0.2, 1}, // Within the hysteresis.
+		{high + 1.5, 1}, // Not worse by the delta.
+		{high + 2.5, 2},
+		{high - 1, 3},
+	} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-15+i*3) * time.Minute),
+			Mmol: tc.mmol,
+		})
+		assert.NoError(suite.T(), err)
+
+		assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+		assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], tc.messages, "glucose %.1f", tc.mmol)
+	}
+
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.True(suite.T(), strings.Contains(msgs[1].Content, "worsening"))
+	assert.True(suite.T(), strings.Contains(msgs[2].Content, "back in range"))
+
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), alerts, 1)
+	assert.True(suite.T(), alerts[0].Resolved())
+	assert.Len(suite.T(), alerts[0].Events, 3, "triggered, worsened and resolved")
+}
+
+func (suite *AnalyzerSuite) TestHighGlucoseReentry() {
+	ctx := context.Background()
+	high := suite.analyzer.GlucoseConfig.High
+
+	for i, tc := range []struct {
+		mmol     float64
+		messages int
+	}{
+		{high + 1, 1},
+		{high - 1, 2},
+		{high + 0.2, 2}, // Hovering at the threshold right after resolving.
+		{high - 0.2, 2},
+		{high + 0.3, 2},
+		{high + 1, 3},
+	} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-18+i*3) * time.Minute),
+			Mmol: tc.mmol,
+		})
+		assert.NoError(suite.T(), err)
+
+		assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+		assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], tc.messages, "glucose %.1f", tc.mmol)
+	}
+
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), alerts, 2, "only re-entered once clear of the hysteresis")
+}
+
+func (suite *AnalyzerSuite) TestWorsenedAlertUnacked() {
+	ctx := context.Background()
+	high := suite.analyzer.GlucoseConfig.High
+
+	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-10 * time.Minute),
+		Mmol: high + 1,
+	})
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), alerts, 1)
+	alerts[0].AckedAt, alerts[0].AckedBy = time.Now(), "tester"
+	_, err = suite.ms.UpdateAlert(ctx, &alerts[0])
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-5 * time.Minute),
+		Mmol: high + 2.5,
+	})
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+
+	var worsened defs.Alert
+	assert.NoError(suite.T(), suite.ms.DocByID(ctx, mg.AlertsCollection, string(alerts[0].ID), &worsened))
+	assert.False(suite.T(), worsened.Acked(), "the acknowledgement is cleared in the store")
+	assert.Empty(suite.T(), worsened.AckedBy)
+	assert.Equal(suite.T(), defs.AlertTriggered, worsened.State)
+	assert.Len(suite.T(), worsened.Events, 2)
+}
+
+func (suite *AnalyzerSuite) TestAlertNotified() {
+	nf := &mocks.Notifier{}
+	suite.analyzer.Notifier = nf
+	defer func() { suite.analyzer.Notifier = nil }()
+
+	ctx := context.Background()
+	high := suite.analyzer.GlucoseConfig.High
+	for i, mmol := range []float64{high + 1, high - 1} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-15+i*3) * time.Minute),
+			Mmol: mmol,
+		})
+		assert.NoError(suite.T(), err)
+		assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+	}
+
+	assert.Len(suite.T(), nf.Events, 2, "triggered and resolved")
+	for i, state := range []defs.AlertState{defs.AlertTriggered, defs.AlertResolved} {
+		assert.Equal(suite.T(), notify.AlertEvent, nf.Events[i].Type)
+		data := nf.Events[i].Data.(notify.AlertData)
+		assert.Equal(suite.T(), defs.HighGlucoseLabel, data.Label)
+		assert.Equal(suite.T(), string(state), data.State)
+	}
+}
+
+func (suite *AnalyzerSuite) TestQuietHoursDigest() {
+	quietHours := func(from, to time.Duration) *schedule.Window {
+		now := time.Now()
+		window, err := schedule.ParseWindow(now.Add(from).Format("15:04"), now.Add(to).Format("15:04"))
+		assert.NoError(suite.T(), err)
+		return &window
+	}
+	defer func() { suite.analyzer.Quiet = nil }()
+
+	ctx := context.Background()
+	suite.analyzer.Quiet = quietHours(-time.Hour, time.Hour)
+	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-15 * time.Minute),
+		Mmol: suite.analyzer.GlucoseConfig.High + 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeDigest(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 0, "warnings are held during quiet hours")
+
+	suite.analyzer.Quiet = quietHours(-2*time.Hour, -time.Hour)
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeDigest(context.Background()))
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeDigest(context.Background()))
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 1, "digest is only sent once")
+	assert.True(suite.T(), strings.Contains(msgs[0].Content, defs.HighGlucoseLabel))
+
+	// Resolving during the quiet hours adds the resolution to the digest.
+	suite.analyzer.Quiet = quietHours(-time.Hour, time.Hour)
+	_, err = suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-5 * time.Minute),
+		Mmol: suite.analyzer.GlucoseConfig.High - 1,
+	})
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), suite.analyzeRule(defs.HighGlucoseLabel))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1, "resolutions are held too")
+
+	suite.analyzer.Quiet = quietHours(-2*time.Hour, -time.Hour)
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeDigest(context.Background()))
+	msgs = suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 2)
+	assert.True(suite.T(), strings.Contains(msgs[1].Content, "resolved at"))
+}
+
+func (suite *AnalyzerSuite) TestRapidFallAlert() {
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-15+i*5) * time.Minute),
+			Mmol: 9 - float64(i),
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeRateOfChange(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	label := "⚠️ " + defs.RapidFallLabel
+	assert.True(suite.T(), strings.Contains(alert.Content, label))
+}
+
+func (suite *AnalyzerSuite) TestStaleDataAlerts() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-time.Hour),
+		Mmol: suite.analyzer.GlucoseConfig.Low + 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeStaleData(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.NoDataLabel))
+
+	_, err = suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now(),
+		Mmol: suite.analyzer.GlucoseConfig.Low + 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeStaleData(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 2)
+	alert = suite.msger.Channels[defs.AlertsChannel][1]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.DataRestoredLabel))
+}
+
+func (suite *AnalyzerSuite) TestEscalateUrgentAlert() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteAlert(ctx, &defs.Alert{
+		Time:     time.Now().Add(-30 * time.Minute),
+		Label:    defs.LowGlucoseLabel,
+		Severity: defs.Urgent,
+	})
+	assert.NoError(suite.T(), err)
+
+	suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{Timeout: 15, Patient: 1}
+	defer func() { suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{} }()
+
+	assert.NoError(suite.T(), suite.analyzer.EscalateAlerts(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels["dm-1"], 1)
+
+	// Already escalated within the timeout.
+	assert.NoError(suite.T(), suite.analyzer.EscalateAlerts(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels["dm-1"], 1)
+}
+
+func (suite *AnalyzerSuite) TestEscalateWithoutDirectMessages() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteAlert(ctx, &defs.Alert{
+		Time:     time.Now().Add(-30 * time.Minute),
+		Label:    defs.LowGlucoseLabel,
+		Severity: defs.Urgent,
+	})
+	assert.NoError(suite.T(), err)
+
+	suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{Timeout: 15, Patient: 1}
+	suite.msger.DirectMessageErr = fmt.Errorf("direct messages are not supported")
+	defer func() {
+		suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{}
+		suite.msger.DirectMessageErr = nil
+	}()
+
+	assert.NoError(suite.T(), suite.analyzer.EscalateAlerts(ctx))
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 1, "the patient is mentioned in the alerts channel instead")
+	assert.Equal(suite.T(), []uint64{1}, msgs[0].Mentions)
+	assert.False(suite.T(), msgs[0].MentionEveryone)
+
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), defs.EscalatePatient, alerts[0].Escalation)
+}
+
+func (suite *AnalyzerSuite) TestEscalateResolvedAlert() {
+	ctx := context.Background()
+	alert := defs.Alert{
+		Time:     time.Now().Add(-30 * time.Minute),
+		Label:    defs.LowGlucoseLabel,
+		Severity: defs.Urgent,
+	}
+	alert.Transition(alert.Time, defs.AlertTriggered, 3.5, "")
+	res, err := suite.ms.WriteAlert(ctx, &alert)
+	assert.NoError(suite.T(), err)
+	alert.ID = res.UpsertedID
+
+	suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{Timeout: 15}
+	defer func() { suite.analyzer.AlarmConfig.Escalation = defs.EscalationConfig{} }()
+
+	// The rules resolve the alert after the escalation read it.
+	stale := alert
+	assert.NoError(suite.T(), suite.analyzer.resolve(ctx, &alert, 5))
+	assert.NoError(suite.T(), suite.analyzer.escalate(ctx, &stale))
+	assert.Empty(suite.T(), suite.msger.Channels[defs.AlertsChannel], "no one is pinged")
+
+	var stored defs.Alert
+	assert.NoError(suite.T(), suite.ms.DocByID(ctx, mg.AlertsCollection, string(alert.ID), &stored))
+	assert.True(suite.T(), stored.Resolved(), "the alert is not reopened")
+	assert.Equal(suite.T(), defs.EscalateChannel, stored.Escalation)
+}
+
 func (suite *AnalyzerSuite) TestSlowInsulinNoAlert() {
 	ctx := context.Background()
 	_, err := suite.ms.WriteInsulin(ctx, &defs.Insulin{
@@ -115,15 +486,271 @@ func (suite *AnalyzerSuite) TestSlowInsulinNoAlert() {
 	})
 	assert.NoError(suite.T(), err)
 
-	assert.NoError(suite.T(), suite.analyzer.AnalyzeInsulin())
+	assert.NoError(suite.T(), suite.analyzeRule(defs.MissingSlowInsulinLabel))
 	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 0)
 }
 
 func (suite *AnalyzerSuite) TestSlowInsulinAlert() {
-	assert.NoError(suite.T(), suite.analyzer.AnalyzeInsulin())
+	assert.NoError(suite.T(), suite.analyzeRule(defs.MissingSlowInsulinLabel))
 	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
 
 	alert := suite.msger.Channels[defs.AlertsChannel][0]
 	label := "⚠️ " + defs.MissingSlowInsulinLabel
 	assert.True(suite.T(), strings.Contains(alert.Content, label))
 }
+
+func (suite *AnalyzerSuite) TestMissedBolusAlert() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteCarbs(ctx, &defs.Carb{
+		Time:   time.Now().Add(-45 * time.Minute),
+		Amount: 60,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeMissedBolus(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.MissedBolusLabel))
+	assert.True(suite.T(), strings.Contains(alert.Content, "Did you forget to bolus?"))
+	assert.Equal(suite.T(), defs.AddInsulinCmd, alert.Buttons[0].Command.Name)
+
+	// The same carbs are only asked about once.
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeMissedBolus(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+}
+
+func (suite *AnalyzerSuite) TestUnannouncedMealAlert() {
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-30+i*5) * time.Minute),
+			Mmol: 6 + 0.5*float64(i),
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	_, err := suite.ms.WriteInsulin(ctx, &defs.Insulin{
+		Time:   time.Now().Add(-3 * time.Hour),
+		Type:   defs.RapidActing.String(),
+		Amount: 4,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeMissedBolus(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.UnannouncedMealLabel))
+}
+
+func (suite *AnalyzerSuite) TestBasalReminder() {
+	now := time.Now().In(suite.analyzer.Location)
+	suite.analyzer.AlarmConfig.Basal = defs.BasalConfig{Doses: []defs.BasalDose{
+		{Time: now.Add(-10 * time.Minute).Format("15:04"), Tolerance: 30},
+	}}
+	defer func() { suite.analyzer.AlarmConfig.Basal = defs.BasalConfig{} }()
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeBasal(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.MissingSlowInsulinLabel))
+	assert.True(suite.T(), strings.Contains(alert.Content, "reminder"))
+
+	// Only reminded once within the window.
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeBasal(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+}
+
+func (suite *AnalyzerSuite) TestBasalDoubleDose() {
+	ctx := context.Background()
+	now := time.Now().In(suite.analyzer.Location)
+	suite.analyzer.AlarmConfig.Basal = defs.BasalConfig{Doses: []defs.BasalDose{
+		{Time: now.Add(-10 * time.Minute).Format("15:04"), Tolerance: 30},
+	}}
+	defer func() { suite.analyzer.AlarmConfig.Basal = defs.BasalConfig{} }()
+
+	for _, offset := range []time.Duration{-15 * time.Minute, -time.Minute} {
+		_, err := suite.ms.WriteInsulin(ctx, &defs.Insulin{
+			Time:   now.Add(offset),
+			Type:   defs.SlowActing.String(),
+			Amount: 20,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeBasal(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 1)
+	alert := suite.msger.Channels[defs.AlertsChannel][0]
+	assert.True(suite.T(), strings.Contains(alert.Content, "⚠️ "+defs.DoubleDoseLabel))
+}
+
+func (suite *AnalyzerSuite) TestLowTreatmentWorkflow() {
+	ctx := context.Background()
+	low := suite.analyzer.GlucoseConfig.Low
+	_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-15 * time.Minute),
+		Mmol: low - 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzeRule(defs.LowGlucoseLabel))
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 2, "low alert and treatment prompt")
+	assert.True(suite.T(), strings.Contains(msgs[1].Content, "fast carbs"))
+	assert.Equal(suite.T(), defs.AddCarbsCmd, msgs[1].Buttons[0].Command.Name)
+
+	ts, err := suite.ms.ReadTreatments(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), ts, 1)
+
+	// Nothing happens until the re-check is due.
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeTreatments(context.Background()))
+	assert.Len(suite.T(), suite.msger.Channels[defs.AlertsChannel], 2)
+
+	ts[0].LastPrompt = time.Now().Add(-20 * time.Minute)
+	_, err = suite.ms.UpdateTreatment(ctx, &ts[0])
+	assert.NoError(suite.T(), err)
+	_, err = suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+		Time: time.Now().Add(-time.Minute),
+		Mmol: low + 1,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeTreatments(context.Background()))
+	msgs = suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 3)
+	assert.True(suite.T(), strings.Contains(msgs[2].Content, "low treated"))
+
+	ts, err = suite.ms.ReadTreatments(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), ts[0].Closed())
+}
+
+func (suite *AnalyzerSuite) TestSensorExpiryReminder() {
+	ctx := context.Background()
+	now := time.Now()
+	_, err := suite.ms.WriteSensor(ctx, &defs.SensorSession{
+		Time:   now.Add(-239 * time.Hour),
+		Expiry: now.Add(time.Hour),
+		Source: defs.SensorCommandSource,
+	})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeSensor(context.Background()))
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeSensor(context.Background()))
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 1, "only the latest reminder is sent, once")
+	assert.True(suite.T(), strings.Contains(msgs[0].Content, defs.SensorExpiryLabel))
+}
+
+func (suite *AnalyzerSuite) TestSensorDetected() {
+	ctx := context.Background()
+	for _, minutes := range []int{-200, -195, -60, -55} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(minutes) * time.Minute),
+			Mmol: 6,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeSensor(context.Background()))
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeSensor(context.Background()))
+	msgs := suite.msger.Channels[defs.AlertsChannel]
+	assert.Len(suite.T(), msgs, 1)
+	assert.True(suite.T(), strings.Contains(msgs[0].Content, "new sensor detected"))
+
+	ss, err := suite.ms.ReadSensors(ctx, time.Now().Add(-24*time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), ss, 1)
+	assert.Equal(suite.T(), defs.SensorWarmupSource, ss[0].Source)
+}
+
+func (suite *AnalyzerSuite) TestSensorGapMidSession() {
+	ctx := context.Background()
+	_, err := suite.ms.WriteSensor(ctx, &defs.SensorSession{
+		Time:   time.Now().Add(-5 * 24 * time.Hour),
+		Expiry: time.Now().Add(5 * 24 * time.Hour),
+		Source: defs.SensorCommandSource,
+	})
+	assert.NoError(suite.T(), err)
+	for _, minutes := range []int{-200, -195, -60, -55} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(minutes) * time.Minute),
+			Mmol: 6,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeSensor(ctx))
+	assert.Empty(suite.T(), suite.msger.Channels[defs.AlertsChannel], "the gap is an outage")
+
+	ss, err := suite.ms.ReadSensors(ctx, time.Now().Add(-10*24*time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), ss, 1)
+}
+
+func (suite *AnalyzerSuite) TestCompressionLowDowngraded() {
+	ctx := context.Background()
+	low := suite.analyzer.GlucoseConfig.Low
+	for i, mmol := range []float64{low + 3, low + 3, low - 0.5} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-15+i*5) * time.Minute),
+			Mmol: mmol,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzeRule(defs.LowGlucoseLabel))
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), alerts, 1)
+	assert.Equal(suite.T(), defs.Warning, alerts[0].Severity)
+	assert.True(suite.T(), strings.Contains(alerts[0].Reason, "compression"))
+}
+
+func (suite *AnalyzerSuite) TestFastDropVeryLow() {
+	ctx := context.Background()
+	qd, err := quality.New(defs.QualityConfig{Enabled: true}, time.Local)
+	assert.NoError(suite.T(), err)
+	defer func(qd *quality.Detector) { suite.analyzer.Quality = qd }(suite.analyzer.Quality)
+	suite.analyzer.Quality = qd
+
+	for i, mmol := range []float64{6.5, 6.4, 4.6, 2.8} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-15+i*5) * time.Minute),
+			Mmol: mmol,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzeRule(defs.LowGlucoseLabel))
+	alerts, err := suite.ms.ReadAlerts(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), alerts, 1)
+	assert.Equal(suite.T(), defs.Urgent, alerts[0].Severity, "very low glucose is never downgraded")
+	assert.True(suite.T(), strings.Contains(alerts[0].Reason, "compression"))
+	assert.True(suite.T(), suite.msger.Channels[defs.AlertsChannel][0].MentionEveryone)
+}
+
+func (suite *AnalyzerSuite) TestCompressionFlagged() {
+	ctx := context.Background()
+	low := suite.analyzer.GlucoseConfig.Low
+	for i, mmol := range []float64{low + 3, low - 0.5, low - 0.6, low + 2.8} {
+		_, err := suite.ms.WriteGlucose(ctx, &defs.TransformedReading{
+			Time: time.Now().Add(time.Duration(-20+i*5) * time.Minute),
+			Mmol: mmol,
+		})
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.analyzer.AnalyzeQuality(context.Background()))
+	glucose, err := suite.ms.ReadGlucose(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), glucose, 4)
+	for i, tr := range glucose {
+		if i == 1 || i == 2 {
+			assert.Equal(suite.T(), defs.CompressionSuspect, tr.Suspect)
+		} else {
+			assert.Empty(suite.T(), tr.Suspect)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/schedule"
+	"time"
+)
+
+// basalOverdue is how long after a scheduled basal dose it is still
+// considered missed.
+const basalOverdue = 12 * time.Hour
+
+// AnalyzeBasal reminds about scheduled basal doses that have not been
+// logged, once at the scheduled time and again once they are overdue, and
+// warns when more than one dose is logged for the same scheduled time.
+func (an *Analyzer) AnalyzeBasal(ctx context.Context) error {
+	doses := an.AlarmConfig.Basal.Doses
+	if len(doses) == 0 {
+		return nil
+	}
+
+	now := time.Now().In(an.Location)
+	start := now.Add(-basalOverdue - 24*time.Hour)
+
+	ins, err := an.Store.ReadInsulin(ctx, start, now)
+	if err != nil {
+		return err
+	}
+
+	alerts, _ := an.Store.ReadAlerts(ctx, start, now)
+
+	for _, dose := range doses {
+		tolerance := minutesOr(dose.Tolerance, defs.DefaultBasalTolerance)
+
+		// The dose scheduled yesterday may still be overdue.
+		for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+			scheduled, err := schedule.OnDay(day, dose.Time)
+			if err != nil {
+				return fmt.Errorf("unable to parse basal dose time: %w", err)
+			}
+			if now.Before(scheduled.Add(-tolerance)) || !now.Before(scheduled.Add(basalOverdue)) {
+				continue
+			}
+
+			logged := slowDoses(ins, scheduled.Add(-tolerance), scheduled.Add(tolerance))
+			if len(logged) > 1 {
+				if alertedSince(alerts, defs.DoubleDoseLabel, logged[1].Time) {
+					continue
+				}
+				return an.sendAlert(ctx, defs.DoubleDoseLabel, fmt.Sprintf(
+					"%d slow acting doses logged for %s: %s",
+					len(logged), dose.Time, describeDoses(logged, an.Location),
+				), true)
+			}
+
+			if len(logged) > 0 || now.Before(scheduled) {
+				continue
+			}
+
+			expected := fmt.Sprintf("scheduled at %s ± %s", dose.Time, tolerance)
+			if dose.Units > 0 {
+				expected = fmt.Sprintf("%.0fu %s", dose.Units, expected)
+			}
+
+			switch {
+			case now.Before(scheduled.Add(tolerance)):
+				if alertedSince(alerts, defs.MissingSlowInsulinLabel, scheduled.Add(-tolerance)) {
+					continue
+				}
+				return an.sendAlert(ctx, defs.MissingSlowInsulinLabel,
+					fmt.Sprintf("reminder: basal dose due, %s", expected), true)
+			default:
+				if alertedSince(alerts, defs.MissingSlowInsulinLabel, scheduled.Add(tolerance)) {
+					continue
+				}
+				return an.sendAlert(ctx, defs.MissingSlowInsulinLabel,
+					fmt.Sprintf("basal dose overdue, %s", expected), true)
+			}
+		}
+	}
+
+	return nil
+}
+
+// slowDoses returns the slow acting doses logged between start and end.
+func slowDoses(ins []defs.Insulin, start, end time.Time) []defs.Insulin {
+	doses := make([]defs.Insulin, 0)
+	for _, in := range ins {
+		if in.Type == defs.SlowActing.String() && !in.Time.Before(start) && !in.Time.After(end) {
+			doses = append(doses, in)
+		}
+	}
+	return doses
+}
+
+func describeDoses(ins []defs.Insulin, loc *time.Location) string {
+	var desc string
+	for i, in := range ins {
+		if i > 0 {
+			desc += ", "
+		}
+		desc += fmt.Sprintf("%.0fu at %s", in.Amount, in.Time.In(loc).Format(discgo.TimeFormat))
+	}
+	return desc
+}
@@ -0,0 +1,215 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/alerts"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAlertsHistory is how far back the alerts are listed by default.
+	defaultAlertsHistory = 24
+	// maxListedAlerts keeps the list within the message limits.
+	maxListedAlerts = 15
+)
+
+func handleAck(cs CommanderStore, e defs.EventInfo, data defs.CommandInteraction,
+	publish publisher) (*defs.MessageData, error) {
+	var id string
+	for _, opt := range data.Options {
+		if opt.Name == "id" {
+			id = opt.Value
+		}
+	}
+
+	alert, err := findAlert(cs, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	alert.AckedAt, alert.AckedBy = time.Now(), e.User
+	u := mg.AlertUpdate{Set: map[string]interface{}{"ackedAt": alert.AckedAt, "ackedBy": alert.AckedBy}}
+	if _, err = cs.UpdateAlertFields(ctx, string(alert.ID), u); err != nil {
+		return nil, fmt.Errorf("unable to acknowledge alert: %w", err)
+	}
+	if err = transitionAcked(ctx, cs, alert, alert.AckedAt, "by "+e.User); err != nil {
+		return nil, fmt.Errorf("unable to acknowledge alert: %w", err)
+	}
+	publish(notify.Alert(*alert, notify.AlertAcked))
+
+	return &defs.MessageData{
+		Content: fmt.Sprintf("✅ %s acknowledged by %s", alert.Label, e.User),
+	}, nil
+}
+
+func handleSnooze(cs CommanderStore, e defs.EventInfo, data defs.CommandInteraction,
+	publish publisher) (*defs.MessageData, error) {
+	var id string
+	var minutes int
+	var err error
+
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "minutes":
+			minutes, err = strconv.Atoi(opt.Value)
+		case "id":
+			id = opt.Value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	alert, err := findAlert(cs, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snoozing also acknowledges the alert, otherwise it would keep
+	// escalating in the meantime.
+	ctx := context.Background()
+	now := time.Now()
+	alert.SnoozedUntil = now.Add(time.Duration(minutes) * time.Minute)
+	u := mg.AlertUpdate{Set: map[string]interface{}{"snoozedUntil": alert.SnoozedUntil}}
+	if _, err = cs.UpdateAlertFields(ctx, string(alert.ID), u); err != nil {
+		return nil, fmt.Errorf("unable to snooze alert: %w", err)
+	}
+	if !alert.Acked() {
+		alert.AckedAt, alert.AckedBy = now, e.User
+		u := mg.AlertUpdate{
+			Set:     map[string]interface{}{"ackedAt": alert.AckedAt, "ackedBy": alert.AckedBy},
+			Unacked: true,
+		}
+		if _, err = cs.UpdateAlertFields(ctx, string(alert.ID), u); err != nil {
+			return nil, fmt.Errorf("unable to snooze alert: %w", err)
+		}
+	}
+	reason := fmt.Sprintf("snoozed for %d minutes by %s", minutes, e.User)
+	if err = transitionAcked(ctx, cs, alert, now, reason); err != nil {
+		return nil, fmt.Errorf("unable to snooze alert: %w", err)
+	}
+	publish(notify.Alert(*alert, notify.AlertSnoozed))
+
+	return &defs.MessageData{
+		Content: fmt.Sprintf("💤 %s snoozed for %d minutes by %s", alert.Label, minutes, e.User),
+	}, nil
+}
+
+// transitionAcked moves the open alert to the acknowledged state, unless it
+// was resolved since it was read. Only the state is written, as the rules
+// and the escalation update the alert concurrently.
+func transitionAcked(ctx context.Context, cs CommanderStore, alert *defs.Alert, t time.Time,
+	note string) error {
+	if !alert.Open() {
+		return nil
+	}
+	alert.Transition(t, defs.AlertAcknowledged, alert.Value, note)
+	_, err := cs.UpdateAlertFields(ctx, string(alert.ID), mg.AlertUpdate{
+		Set:        map[string]interface{}{"state": alert.State},
+		Event:      &alert.Events[len(alert.Events)-1],
+		Unresolved: true,
+	})
+	return err
+}
+
+// findAlert returns the alert with the given id, or the most recent alert
+// that has not been acknowledged if no id is given.
+func findAlert(cs CommanderStore, id string) (*defs.Alert, error) {
+	ctx := context.Background()
+
+	var alert defs.Alert
+	if id != "" {
+		if err := cs.DocByID(ctx, mg.AlertsCollection, id, &alert); err != nil {
+			return nil, fmt.Errorf("unable to find alert %s: %w", id, err)
+		}
+		return &alert, nil
+	}
+
+	end := time.Now()
+	alerts, err := cs.ReadAlerts(ctx, end.Add(defs.LookbackInterval), end)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(alerts) - 1; i >= 0; i-- {
+		if !alerts[i].Acked() && alerts[i].Severity > defs.Info {
+			return &alerts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no unacknowledged alerts found")
+}
+
+func handleAlerts(cs CommanderStore, loc *time.Location,
+	data defs.CommandInteraction) (*defs.MessageData, error) {
+	hours := defaultAlertsHistory
+	var f alerts.Filter
+	var err error
+
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "hours":
+			hours, err = strconv.Atoi(opt.Value)
+		case "label":
+			f.Label = opt.Value
+		case "severity":
+			var sev defs.Severity
+			sev, err = defs.ParseSeverity(opt.Value)
+			f.Severity = &sev
+		case "state":
+			if opt.Value != defs.AlertsOpen && opt.Value != defs.AlertsResolved {
+				err = fmt.Errorf("unknown alert state %q", opt.Value)
+			}
+			f.State = opt.Value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	end := time.Now()
+	as, err := cs.ReadAlerts(context.Background(), end.Add(-time.Duration(hours)*time.Hour), end)
+	if err != nil {
+		return nil, err
+	}
+	as = alerts.Select(as, f)
+	if len(as) == 0 {
+		return &defs.MessageData{Content: fmt.Sprintf("🔕 no alerts in the last %dh", hours)}, nil
+	}
+
+	s := alerts.Summarize(as, loc)
+	content := fmt.Sprintf("🔔 %d alerts in the last %dh", s.Count, hours)
+	if s.Resolved > 0 {
+		content += fmt.Sprintf(", resolved in %s on average", s.MeanResolution.Round(time.Minute))
+	}
+
+	lines := make([]string, 0)
+	for i := len(as) - 1; i >= 0 && len(lines) < maxListedAlerts; i-- {
+		lines = append(lines, alertLine(as[i], loc))
+	}
+	if more := len(as) - len(lines); more > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more", more))
+	}
+	content += "\n```" + strings.Join(lines, "\n") + "```"
+
+	return &defs.MessageData{Content: content}, nil
+}
+
+// alertLine describes the alert on a line, with its id to acknowledge it by.
+func alertLine(a defs.Alert, loc *time.Location) string {
+	line := fmt.Sprintf("%s %-7s %s", a.Time.In(loc).Format(discgo.TimeFormat), a.Severity, a.Label)
+	if a.State != "" {
+		line += " (" + string(a.State) + ")"
+	}
+	if a.Acked() {
+		line += ", acked by " + a.AckedBy
+	}
+	return line + " " + string(a.ID)
+}
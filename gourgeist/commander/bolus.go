@@ -0,0 +1,101 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/insulin"
+	"iv2/gourgeist/pkg/targets"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleGlucose is how old the latest reading can be, for it to be corrected.
+const staleGlucose = 15 * time.Minute
+
+func handleBolus(cs CommanderStore, bp *bolus.Profiles, im *insulin.Model, tr *targets.Resolver,
+	data defs.CommandInteraction) (*defs.MessageData, error) {
+	var carbs float64
+	var err error
+	for _, opt := range data.Options {
+		if opt.Name == "carbs" {
+			carbs, err = strconv.ParseFloat(opt.Value, 64)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	rapid := defs.RapidActing.String()
+
+	glucose, err := cs.ReadGlucose(ctx, now.Add(-staleGlucose), now)
+	if err != nil {
+		return nil, err
+	}
+
+	ins, err := cs.ReadInsulin(ctx, now.Add(-im.Duration(rapid)), now)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err = tr.Load(ctx, cs, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	in := bolus.Input{
+		Carbs:  carbs,
+		IOB:    im.IOB(ins, rapid, now),
+		Target: tr.Thresholds(now).Target,
+	}
+	if len(glucose) > 0 {
+		in.Glucose = glucose[len(glucose)-1].Mmol
+	}
+
+	b, err := bp.Calculate(now, in)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &defs.MessageData{Content: describeBolus(b)}
+	if b.Dose > 0 {
+		// The suggestion is only logged once, however many times the button
+		// is clicked.
+		units := b.Units()
+		suggestion := strconv.FormatInt(now.UnixNano(), 36)
+		reply.Buttons = []defs.ButtonData{{
+			Label: fmt.Sprintf("Log %su %s", units, rapid),
+			Style: defs.PrimaryButton,
+			Command: defs.CommandInteraction{
+				Name: defs.AddInsulinCmd,
+				Options: []defs.CommandInteractionOption{
+					{Name: "type", Value: rapid},
+					{Name: "units", Value: units},
+					{Name: "suggestion", Value: suggestion},
+				},
+			},
+		}}
+	}
+	return reply, nil
+}
+
+func describeBolus(b bolus.Breakdown) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "💉 suggested dose: %su (profile: %s)\n", b.Units(), b.Profile)
+	fmt.Fprintf(&sb, "carbs: %.0fg / %.1f g/u = %.2fu\n", b.Carbs, b.Settings.ICR, b.CarbDose)
+	switch {
+	case b.Corrected():
+		fmt.Fprintf(&sb, "correction: (%.1f - %.1f) / %.1f = %+.2fu\n",
+			b.Glucose, b.Settings.Target, b.Settings.ISF, b.Correction)
+	case b.Glucose > 0:
+		fmt.Fprintf(&sb, "correction: none, no target set\n")
+	default:
+		fmt.Fprintf(&sb, "correction: none, no reading in the last %s\n", staleGlucose)
+	}
+	fmt.Fprintf(&sb, "iob: -%.2fu (%.2fu on board)", b.IOBDeduction, b.IOB)
+	return sb.String()
+}
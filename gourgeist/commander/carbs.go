@@ -5,20 +5,31 @@ import (
 	"fmt"
 	"iv2/gourgeist/defs"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
 	"strconv"
 	"time"
 )
 
-func handleCarbs(cs CommanderStore, data defs.CommandInteraction, f cleanUp) error {
-	amount, _ := strconv.Atoi(data.Options[0].Value)
+func handleCarbs(cs CommanderStore, data defs.CommandInteraction, publish publisher, f cleanUp) error {
+	var amount, absorption int
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "amount":
+			amount, _ = strconv.Atoi(opt.Value)
+		case "absorption":
+			absorption, _ = strconv.Atoi(opt.Value)
+		}
+	}
 
-	_, err := cs.WriteCarbs(context.Background(), &defs.Carb{
-		Time:   time.Now(),
-		Amount: float64(amount),
-	})
-	if err != nil {
+	c := defs.Carb{
+		Time:       time.Now(),
+		Amount:     float64(amount),
+		Absorption: absorption,
+	}
+	if _, err := cs.WriteCarbs(context.Background(), &c); err != nil {
 		return fmt.Errorf("unable to save carbs: %w", err)
 	}
+	publish(notify.Carbs(c))
 
 	return f()
 }
@@ -72,9 +83,10 @@ func handleEditCarbs(cs CommanderStore, data defs.CommandInteraction, f cleanUp)
 		}
 
 		_, err = cs.UpdateCarbs(ctx, &defs.Carb{
-			ID:     carb.ID,
-			Time:   newTime,
-			Amount: float64(amount),
+			ID:         carb.ID,
+			Time:       newTime,
+			Amount:     float64(amount),
+			Absorption: carb.Absorption,
 		})
 		if err != nil {
 			return fmt.Errorf("unable to edit carbs: %w", err)
@@ -0,0 +1,46 @@
+package commander
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/checks"
+	"iv2/gourgeist/pkg/discgo"
+	"sort"
+	"strings"
+	"time"
+)
+
+// handleChecks lists how the checks of the analyzer went, so that failing
+// or slow checks can be told apart.
+func handleChecks(reg *checks.Registry, loc *time.Location) (*defs.MessageData, error) {
+	if reg == nil {
+		return nil, fmt.Errorf("checks are not registered")
+	}
+
+	metrics := reg.Metrics()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failing int
+	lines := []string{fmt.Sprintf("%-10s %5s %8s %9s  %s", "check", "runs", "failures", "duration", "last run")}
+	for _, name := range names {
+		m := metrics[name]
+		last := "never"
+		if !m.LastRun.IsZero() {
+			last = m.LastRun.In(loc).Format(discgo.TimeFormat)
+		}
+		lines = append(lines, fmt.Sprintf("%-10s %5d %8d %9s  %s",
+			name, m.Runs, m.Failures, m.Duration.Round(time.Millisecond), last))
+		if m.Failures > 0 {
+			failing++
+			lines = append(lines, "  "+m.LastError.Error())
+		}
+	}
+
+	content := fmt.Sprintf("🩺 %d checks, %d failing", len(names), failing)
+	content += "\n```" + strings.Join(lines, "\n") + "```"
+	return &defs.MessageData{Content: content}, nil
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/checks"
 	dcr "iv2/gourgeist/pkg/desc"
 	"iv2/gourgeist/pkg/discgo"
 	"iv2/gourgeist/pkg/ghastly"
+	"iv2/gourgeist/pkg/insulin"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"iv2/gourgeist/pkg/targets"
 	"time"
 
 	"go.uber.org/zap"
@@ -20,7 +25,11 @@ type CommanderStore interface {
 	mg.GlucoseStore
 	mg.InsulinStore
 	mg.CarbStore
+	mg.AlertStore
+	mg.TempTargetStore
+	mg.SensorStore
 	mg.FileStore
+	mg.DeadLetterStore
 }
 
 type CommanderDisplay interface {
@@ -33,17 +42,36 @@ type CommandHandler struct {
 	Plotter ghastly.Plotter
 	Store   CommanderStore
 
-	Logger        *zap.Logger
-	Descriptor    *dcr.Descriptor
-	Location      *time.Location
-	GlucoseConfig defs.GlucoseConfig
+	Logger     *zap.Logger
+	Descriptor *dcr.Descriptor
+	Location   *time.Location
+	Targets    *targets.Resolver
+	Insulin    *insulin.Model
+	Therapy    *bolus.Profiles
+	Stacking   defs.StackingConfig
+	Sensor     defs.SensorConfig
+
+	// Notifier is sent the logged insulin and carbs, and the alerts that are
+	// acknowledged or snoozed, if set.
+	Notifier notify.Notifier
+	// Webhooks are where the dead letters are replayed to.
+	Webhooks []defs.WebhookConfig
+	// Checks are the checks of the analyzer, whose metrics are listed.
+	Checks *checks.Registry
+
+	// ExcludeSuspect leaves the suspect readings out of the statistics.
+	ExcludeSuspect bool
 }
 
 type cleanUp func() error
 
+// publisher sends an event to the notifiers.
+type publisher func(notify.Event)
+
 func (ch *CommandHandler) CreateHandler() func(defs.EventInfo, defs.CommandInteraction) {
 	return func(e defs.EventInfo, data defs.CommandInteraction) {
-		if err := ch.handleCommand(data); err != nil {
+		reply, err := ch.handleCommand(e, data)
+		if err != nil {
 			ch.Logger.Debug("unable to handle command",
 				zap.String("command", data.Name),
 				zap.Error(err),
@@ -54,18 +82,26 @@ func (ch *CommandHandler) CreateHandler() func(defs.EventInfo, defs.CommandInter
 			Type: defs.MessageInteraction,
 			Data: defs.MessageData{Content: "received"},
 		}
+		if reply != nil {
+			resp.Data = *reply
+		}
 
 		if err := ch.Display.RespondInteraction(e.ID, e.Token, resp); err != nil {
 			ch.Logger.Debug("unable to send interaction callback", zap.Error(err))
 			return
 		}
+
+		// Replies are kept, otherwise the response is only an acknowledgement.
+		if reply != nil {
+			return
+		}
 		if err := ch.Display.DeleteInteractionResponse(e.AppID, e.Token); err != nil {
 			ch.Logger.Debug("unable to delete interaction response", zap.String("token", e.Token), zap.Error(err))
 		}
 	}
 }
 
-func (ch *CommandHandler) handleCommand(data defs.CommandInteraction) error {
+func (ch *CommandHandler) handleCommand(e defs.EventInfo, data defs.CommandInteraction) (*defs.MessageData, error) {
 	ch.Logger.Debug("received command",
 		zap.String("cmd", data.Name),
 		zap.Any("options", data.Options),
@@ -73,28 +109,55 @@ func (ch *CommandHandler) handleCommand(data defs.CommandInteraction) error {
 
 	switch data.Name {
 	case defs.AddCarbsCmd:
-		return handleCarbs(ch.Store, data, ch.updateWithEvent)
+		return nil, handleCarbs(ch.Store, data, ch.publish, ch.updateWithEvent)
 	case defs.EditCarbsCmd:
-		return handleEditCarbs(ch.Store, data, ch.updateWithEvent)
+		return nil, handleEditCarbs(ch.Store, data, ch.updateWithEvent)
 	case defs.AddInsulinCmd:
-		return handleInsulin(ch.Store, data, ch.updateWithEvent)
+		sc := stackingCheck{Insulin: ch.Insulin, Therapy: ch.Therapy, Config: ch.Stacking}
+		return handleInsulin(ch.Store, sc, data, ch.publish, ch.updateWithEvent)
 	case defs.EditInsulinCmd:
-		return handleEditInsulin(ch.Store, data, ch.updateWithEvent)
+		return nil, handleEditInsulin(ch.Store, data, ch.updateWithEvent)
 	case defs.GenReportCmd:
 		// TODO: Handle this better, for now just separate.
-		return handleGenReport(
+		return nil, handleGenReport(
 			ch.Store,
 			ch.Display,
 			ch.Plotter,
-			ch.GlucoseConfig,
+			ch.Targets,
+			ch.ExcludeSuspect,
 			ch.Logger,
 			ch.Location,
 			data,
 		)
 	case defs.EditVisCmd:
-		return handleEditVis(ch.Descriptor, data, ch.updateWithEvent)
+		return nil, handleEditVis(ch.Descriptor, data, ch.updateWithEvent)
+	case defs.AckCmd:
+		return handleAck(ch.Store, e, data, ch.publish)
+	case defs.SnoozeCmd:
+		return handleSnooze(ch.Store, e, data, ch.publish)
+	case defs.BolusCmd:
+		return handleBolus(ch.Store, ch.Therapy, ch.Insulin, ch.Targets, data)
+	case defs.TempTargetCmd:
+		return handleTempTarget(ch.Store, ch.Targets, e, data, ch.updateRange)
+	case defs.SensorCmd:
+		return handleSensor(ch.Store, ch.Sensor, ch.Location, e, data)
+	case defs.AlertsCmd:
+		return handleAlerts(ch.Store, ch.Location, data)
+	case defs.DeadLettersCmd:
+		return handleDeadLetters(ch.Store, ch.Webhooks, ch.Location, ch.Logger, data)
+	case defs.ChecksCmd:
+		return handleChecks(ch.Checks, ch.Location)
 	default:
-		return fmt.Errorf("unknown command: %s", data.Name)
+		return nil, fmt.Errorf("unknown command: %s", data.Name)
+	}
+}
+
+func (ch *CommandHandler) publish(e notify.Event) {
+	if ch.Notifier == nil {
+		return
+	}
+	if err := ch.Notifier.Notify(context.Background(), e); err != nil {
+		ch.Logger.Warn("unable to notify", zap.String("event", e.Type), zap.Error(err))
 	}
 }
 
@@ -128,3 +191,31 @@ func (ch *CommandHandler) updateWithEvent() error {
 		Embeds: oldMessage.Embeds,
 	})
 }
+
+// updateRange refreshes the range shown in the main message, so that changes
+// to the thresholds are reflected before the next reading arrives.
+func (ch *CommandHandler) updateRange() error {
+	now := time.Now()
+	tr, err := ch.Targets.Load(context.Background(), ch.Store, now, now)
+	if err != nil {
+		return err
+	}
+
+	oldMessage, err := ch.Display.GetMainMessage()
+	if err != nil {
+		return err
+	}
+	if len(oldMessage.Embeds) == 0 {
+		return fmt.Errorf("main message has no embeds")
+	}
+
+	for i, field := range oldMessage.Embeds[0].Fields {
+		if field.Name == defs.RangeField {
+			oldMessage.Embeds[0].Fields[i].Value = tr.Describe(now)
+		}
+	}
+
+	return ch.Display.UpdateMainMessage(defs.MessageData{
+		Embeds: oldMessage.Embeds,
+	})
+}
@@ -0,0 +1,88 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxListedDeadLetters keeps the list within the message limits.
+const maxListedDeadLetters = 15
+
+// handleDeadLetters lists the events that could not be delivered to the
+// webhooks, or replays them, removing those that are delivered this time.
+func handleDeadLetters(cs CommanderStore, webhooks []defs.WebhookConfig, loc *time.Location,
+	logger *zap.Logger, data defs.CommandInteraction) (*defs.MessageData, error) {
+	hours := defaultAlertsHistory
+	var replay bool
+	var err error
+
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "hours":
+			hours, err = strconv.Atoi(opt.Value)
+		case "replay":
+			replay, err = strconv.ParseBool(opt.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	end := time.Now()
+	dls, err := cs.ReadDeadLetters(ctx, end.Add(-time.Duration(hours)*time.Hour), end)
+	if err != nil {
+		return nil, err
+	}
+	if len(dls) == 0 {
+		return &defs.MessageData{Content: fmt.Sprintf("📭 no dead letters in the last %dh", hours)}, nil
+	}
+
+	content := fmt.Sprintf("📬 %d dead letters in the last %dh", len(dls), hours)
+	failed := dls
+	if replay {
+		failed = make([]defs.DeadLetter, 0)
+		for _, dl := range dls {
+			if err := notify.Replay(ctx, dl, webhooks, logger); err != nil {
+				dl.Error = err.Error()
+				failed = append(failed, dl)
+				continue
+			}
+			if err := cs.DeleteByID(ctx, mg.DeadLettersCollection, string(dl.ID)); err != nil {
+				return nil, fmt.Errorf("unable to remove dead letter: %w", err)
+			}
+		}
+		content = fmt.Sprintf("📬 replayed %d of %d dead letters in the last %dh",
+			len(dls)-len(failed), len(dls), hours)
+	}
+	if len(failed) == 0 {
+		return &defs.MessageData{Content: content}, nil
+	}
+
+	lines := make([]string, 0)
+	for i := len(failed) - 1; i >= 0 && len(lines) < maxListedDeadLetters; i-- {
+		lines = append(lines, deadLetterLine(failed[i], loc))
+	}
+	if more := len(failed) - len(lines); more > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more", more))
+	}
+	content += "\n```" + strings.Join(lines, "\n") + "```"
+
+	return &defs.MessageData{Content: content}, nil
+}
+
+// deadLetterLine describes the dead letter on a line, with why it was not
+// delivered.
+func deadLetterLine(dl defs.DeadLetter, loc *time.Location) string {
+	return fmt.Sprintf("%s %-9s %s: %s",
+		dl.Time.In(loc).Format(discgo.TimeFormat), dl.Event, dl.URL, dl.Error)
+}
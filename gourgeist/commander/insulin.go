@@ -5,24 +5,68 @@ import (
 	"fmt"
 	"iv2/gourgeist/defs"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
 	"strconv"
 	"time"
 )
 
-func handleInsulin(cs CommanderStore, data defs.CommandInteraction, f cleanUp) error {
-	insulinType := data.Options[0].Value
-	units, _ := strconv.ParseFloat(data.Options[1].Value, 64)
+func handleInsulin(cs CommanderStore, sc stackingCheck, data defs.CommandInteraction,
+	publish publisher, f cleanUp) (*defs.MessageData, error) {
+	var insulinType, suggestion string
+	var units float64
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "type":
+			insulinType = opt.Value
+		case "units":
+			units, _ = strconv.ParseFloat(opt.Value, 64)
+		case "suggestion":
+			suggestion = opt.Value
+		}
+	}
 
-	_, err := cs.WriteInsulin(context.Background(), &defs.Insulin{
-		Time:   time.Now(),
-		Amount: units,
-		Type:   insulinType,
-	})
-	if err != nil {
-		return fmt.Errorf("unable to save insulin: %w", err)
+	in := defs.Insulin{
+		Time:       time.Now(),
+		Amount:     units,
+		Type:       insulinType,
+		Suggestion: suggestion,
 	}
 
-	return f()
+	if suggestion != "" {
+		logged, err := loggedSuggestion(cs, suggestion, in.Time)
+		if err != nil {
+			return nil, err
+		}
+		if logged != nil {
+			return &defs.MessageData{Content: fmt.Sprintf(
+				"💉 already logged %gu %s from this suggestion", logged.Amount, logged.Type,
+			)}, nil
+		}
+	}
+
+	// The warning is only a reply, the dose is logged either way.
+	warning, _ := sc.warn(cs, in)
+
+	if _, err := cs.WriteInsulin(context.Background(), &in); err != nil {
+		return nil, fmt.Errorf("unable to save insulin: %w", err)
+	}
+	publish(notify.Insulin(in))
+
+	return warning, f()
+}
+
+// loggedSuggestion returns the dose logged from the suggested bolus, if any.
+func loggedSuggestion(cs CommanderStore, suggestion string, t time.Time) (*defs.Insulin, error) {
+	ins, err := cs.ReadInsulin(context.Background(), t.Add(defs.LookbackInterval), t)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ins {
+		if ins[i].Suggestion == suggestion {
+			return &ins[i], nil
+		}
+	}
+	return nil, nil
 }
 
 func handleEditInsulin(cs CommanderStore, data defs.CommandInteraction, f cleanUp) error {
@@ -77,10 +121,11 @@ func handleEditInsulin(cs CommanderStore, data defs.CommandInteraction, f cleanU
 		}
 
 		_, err = cs.UpdateInsulin(ctx, &defs.Insulin{
-			ID:     ins.ID,
-			Time:   newTime,
-			Amount: units,
-			Type:   insType,
+			ID:         ins.ID,
+			Time:       newTime,
+			Amount:     units,
+			Type:       insType,
+			Suggestion: ins.Suggestion,
 		})
 		if err != nil {
 			return fmt.Errorf("unable to edit insulin: %w", err)
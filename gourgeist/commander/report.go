@@ -4,17 +4,22 @@ import (
 	"context"
 	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/alerts"
 	"iv2/gourgeist/pkg/ghastly"
 	"iv2/gourgeist/pkg/ghastly/proto"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/sensors"
 	"iv2/gourgeist/pkg/stats"
+	"iv2/gourgeist/pkg/targets"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 func handleGenReport(cs CommanderStore, cd CommanderDisplay, p ghastly.Plotter,
-	gcfg defs.GlucoseConfig, logger *zap.Logger, loc *time.Location, data defs.CommandInteraction) error {
+	tr *targets.Resolver, excludeSuspect bool, logger *zap.Logger, loc *time.Location, data defs.CommandInteraction) error {
 	timeframe := data.Options[0].Value
 	offset, _ := strconv.Atoi(data.Options[1].Value)
 
@@ -64,7 +69,27 @@ func handleGenReport(cs CommanderStore, cd CommanderDisplay, p ghastly.Plotter,
 		return err
 	}
 
-	ra := stats.TimeSpentInRange(glucose, gcfg.Low, gcfg.High)
+	tr, err = tr.Load(context.Background(), cs, start, end)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := cs.ReadSensors(context.Background(), start.Add(-sensorHistory), end)
+	if err != nil {
+		return err
+	}
+
+	as, err := cs.ReadAlerts(context.Background(), start, end)
+	if err != nil {
+		return err
+	}
+
+	cm := stats.Consensus(glucose, start, minTime(end, time.Now()), excludeSuspect)
+	if excludeSuspect {
+		glucose = quality.Reliable(glucose)
+	}
+
+	ra := stats.TimeSpentInRangeFunc(glucose, tr.Range)
 	ss := stats.GlucoseSummary(glucose)
 	dd := stats.DailyAggregate(stats.IntakeData{Ins: insulin, Carbs: carbs}, loc)
 
@@ -107,11 +132,27 @@ func handleGenReport(cs CommanderStore, cd CommanderDisplay, p ghastly.Plotter,
 					{Name: "In Range", Value: strconv.FormatFloat(ra.InRange, 'f', 2, 64), Inline: true},
 					{Name: "Above Range", Value: strconv.FormatFloat(ra.AboveRange, 'f', 2, 64), Inline: true},
 					defs.EmptyEmbed(),
+					{Name: "GMI", Value: fmt.Sprintf("%.1f%%", cm.GMI), Inline: true},
+					{Name: "CV", Value: fmt.Sprintf("%.1f%%", cm.CV), Inline: true},
+					{Name: "CGM Active", Value: percent(cm.Active), Inline: true},
+					{Name: "Below 3.9", Value: percent(cm.Low), Inline: true},
+					{Name: "Below 3.0", Value: percent(cm.VeryLow), Inline: true},
+					defs.EmptyEmbed(),
+					{Name: "Above 10", Value: percent(cm.High), Inline: true},
+					{Name: "Above 13.9", Value: percent(cm.VeryHigh), Inline: true},
+					defs.EmptyEmbed(),
 				},
 			},
 		},
 	}
 
+	// The sensors get the fields left after the alerts.
+	fields := msgData.Embeds[0].Fields
+	af := alertFields(as, loc)
+	limit := defs.MaxEmbedFields - len(fields) - len(af)
+	fields = append(fields, sensorFields(glucose, sessions, tr, start, end, limit)...)
+	msgData.Embeds[0].Fields = append(fields, af...)
+
 	if fileReader != nil {
 		logger.Debug("adding image to embed", zap.String("name", fr.GetName()))
 		msgData.Embeds[0].Image = &defs.ImageData{Filename: fr.GetName()}
@@ -122,6 +163,90 @@ func handleGenReport(cs CommanderStore, cd CommanderDisplay, p ghastly.Plotter,
 	return err
 }
 
+// sensorFields splits the metrics by the sensors used between start and end,
+// keeping the latest of them up to the limit.
+func sensorFields(glucose []defs.TransformedReading, ss []defs.SensorSession, tr *targets.Resolver,
+	start, end time.Time, limit int) []defs.EmbedField {
+	used := make([]defs.SensorSession, 0)
+	for _, s := range ss {
+		if s.End().After(start) && s.Time.Before(end) {
+			used = append(used, s)
+		}
+	}
+
+	fields := make([]defs.EmbedField, 0)
+	for i, trs := range sensors.Split(glucose, used) {
+		if len(trs) == 0 {
+			continue
+		}
+
+		name := "Sensor " + used[i].Time.In(start.Location()).Format(monthDayFormat)
+		if used[i].Lot != "" {
+			name += " (" + used[i].Lot + ")"
+		}
+		ra := stats.TimeSpentInRangeFunc(trs, tr.Range)
+		fields = append(fields, defs.EmbedField{
+			Name: name,
+			Value: fmt.Sprintf(
+				"In Range: %.2f\nAverage: %.2f", ra.InRange, stats.GlucoseSummary(trs).Average,
+			),
+			Inline: true,
+		})
+	}
+	if limit <= 0 {
+		return nil
+	}
+	if len(fields) > limit {
+		fields = fields[len(fields)-limit:]
+	}
+	return fields
+}
+
+// alertFields counts the alerts by label and time of day, with the time they
+// took to be resolved, to tell whether the thresholds are tuned sensibly.
+func alertFields(as []defs.Alert, loc *time.Location) []defs.EmbedField {
+	s := alerts.Summarize(as, loc)
+	if s.Count == 0 {
+		return []defs.EmbedField{{Name: "Alerts", Value: "None", Inline: true}}
+	}
+
+	labels := make([]string, 0)
+	for _, lc := range s.ByLabel {
+		labels = append(labels, fmt.Sprintf("%s: %d", lc.Label, lc.Count))
+	}
+
+	periods := make([]string, 0)
+	for p, count := range s.ByPeriod {
+		periods = append(periods, fmt.Sprintf("%s: %d", alerts.PeriodName(p), count))
+	}
+
+	resolution := fmt.Sprintf("Resolved: %d", s.Resolved)
+	if s.Resolved > 0 {
+		resolution += fmt.Sprintf(" in %s", s.MeanResolution.Round(time.Minute))
+	}
+	resolution += fmt.Sprintf("\nAcknowledged: %d", s.Acked)
+	if s.Acked > 0 {
+		resolution += fmt.Sprintf(" in %s", s.MeanAck.Round(time.Minute))
+	}
+
+	return []defs.EmbedField{
+		{Name: fmt.Sprintf("Alerts (%d)", s.Count), Value: strings.Join(labels, "\n"), Inline: true},
+		{Name: "Time of Day", Value: strings.Join(periods, "\n"), Inline: true},
+		{Name: "Mean Time", Value: resolution, Inline: true},
+	}
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
 func startOfWeek(t time.Time) time.Time {
 	if wd := t.Weekday(); wd == time.Sunday {
 		t = t.AddDate(0, 0, -6)
@@ -0,0 +1,109 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/sensors"
+	"strconv"
+	"time"
+)
+
+// sensorHistory is how far back the sensors are summarized.
+const sensorHistory = 90 * 24 * time.Hour
+
+func handleSensor(cs CommanderStore, cfg defs.SensorConfig, loc *time.Location, e defs.EventInfo,
+	data defs.CommandInteraction) (*defs.MessageData, error) {
+	var sub, lot, notes string
+	var offset int
+	var err error
+
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case defs.SubcommandOption:
+			sub = opt.Value
+		case "offset":
+			offset, err = strconv.Atoi(opt.Value)
+		case "lot":
+			lot = opt.Value
+		case "notes":
+			notes = opt.Value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lifetime := cfg.LifetimeDuration()
+
+	ctx := context.Background()
+	now := time.Now()
+	ss, err := cs.ReadSensors(ctx, now.Add(-sensorHistory), now)
+	if err != nil {
+		return nil, err
+	}
+	cur := sensors.Current(ss, now)
+
+	switch sub {
+	case defs.SensorStart:
+		inserted := now.Add(time.Duration(-offset) * time.Minute)
+		if cur != nil {
+			cur.EndedAt = inserted
+			if _, err := cs.UpdateSensor(ctx, cur); err != nil {
+				return nil, fmt.Errorf("unable to end sensor: %w", err)
+			}
+		}
+
+		s := defs.SensorSession{
+			Time:   inserted,
+			Expiry: inserted.Add(lifetime),
+			Source: defs.SensorCommandSource,
+			Lot:    lot,
+			Notes:  notes,
+		}
+		if _, err := cs.WriteSensor(ctx, &s); err != nil {
+			return nil, fmt.Errorf("unable to write sensor: %w", err)
+		}
+		return &defs.MessageData{Content: fmt.Sprintf(
+			"🩸 sensor started by %s at %s, expires at %s",
+			e.User, inserted.In(loc).Format(discgo.TimeFormat), s.Expiry.In(loc).Format(discgo.TimeFormat),
+		)}, nil
+	case defs.SensorStop:
+		if cur == nil {
+			return nil, fmt.Errorf("no active sensor found")
+		}
+		cur.EndedAt = now
+		if _, err := cs.UpdateSensor(ctx, cur); err != nil {
+			return nil, fmt.Errorf("unable to end sensor: %w", err)
+		}
+		return &defs.MessageData{Content: fmt.Sprintf(
+			"🩸 sensor ended by %s after %s", e.User, now.Sub(cur.Time).Round(time.Hour),
+		)}, nil
+	case defs.SensorStatus:
+		content := "🩸 no active sensor"
+		if cur != nil {
+			content = fmt.Sprintf(
+				"🩸 sensor inserted at %s, expires in %s",
+				cur.Time.In(loc).Format(discgo.TimeFormat), cur.Expiry.Sub(now).Round(time.Minute),
+			)
+			if cur.Lot != "" {
+				content += ", lot " + cur.Lot
+			}
+		}
+
+		if l := sensors.Lifetimes(ss, now); l.Count > 0 {
+			content += fmt.Sprintf(
+				"\nlast %d sensors: average %s, shortest %s, longest %s, %d ended early",
+				l.Count, days(l.Average), days(l.Shortest), days(l.Longest), l.Early,
+			)
+		}
+		return &defs.MessageData{Content: content}, nil
+	default:
+		return nil, fmt.Errorf("unknown subcommand: %s", sub)
+	}
+}
+
+func days(d time.Duration) string {
+	return fmt.Sprintf("%.1fd", d.Hours()/24)
+}
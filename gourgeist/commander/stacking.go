@@ -0,0 +1,76 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/insulin"
+	"strings"
+	"time"
+)
+
+// stackingCheck warns when rapid acting insulin is logged on top of insulin
+// from prior doses that is still on board.
+type stackingCheck struct {
+	Insulin *insulin.Model
+	Therapy *bolus.Profiles
+	Config  defs.StackingConfig
+}
+
+// warn returns a warning if the dose would be stacked, along with how far it
+// is projected to drop glucose, or nil if it would not.
+func (sc stackingCheck) warn(cs CommanderStore, in defs.Insulin) (*defs.MessageData, error) {
+	rapid := defs.RapidActing.String()
+	if sc.Insulin == nil || in.Type != rapid {
+		return nil, nil
+	}
+
+	threshold := sc.Config.IOB
+	if threshold <= 0 {
+		threshold = defs.DefaultStackingIOB
+	}
+
+	ctx := context.Background()
+	ins, err := cs.ReadInsulin(ctx, in.Time.Add(-sc.Insulin.Duration(rapid)), in.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	iob := sc.Insulin.IOB(ins, rapid, in.Time)
+	if iob < threshold {
+		return nil, nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(
+		&sb, "⚠️ insulin stacking: %.2fu still on board from prior doses, the last %s ago",
+		iob, lastRapid(ins, in.Time).Round(time.Minute),
+	)
+	fmt.Fprintf(&sb, "\ntotal with this dose: %.2fu", iob+in.Amount)
+
+	if sc.Therapy != nil {
+		if _, s := sc.Therapy.At(in.Time); s.ISF > 0 {
+			drop := (iob + in.Amount) * s.ISF
+			fmt.Fprintf(&sb, "\nprojected drop: %.1f mmol/L (isf %.1f)", drop, s.ISF)
+
+			glucose, err := cs.ReadGlucose(ctx, in.Time.Add(-staleGlucose), in.Time)
+			if err == nil && len(glucose) > 0 {
+				current := glucose[len(glucose)-1].Mmol
+				fmt.Fprintf(&sb, ", from %.1f to %.1f", current, current-drop)
+			}
+		}
+	}
+
+	return &defs.MessageData{Content: sb.String()}, nil
+}
+
+// lastRapid returns how long before t rapid acting insulin was last logged.
+func lastRapid(ins []defs.Insulin, t time.Time) time.Duration {
+	for i := len(ins) - 1; i >= 0; i-- {
+		if ins[i].Type == defs.RapidActing.String() {
+			return t.Sub(ins[i].Time)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/targets"
+	"strconv"
+	"time"
+)
+
+const defaultTempTarget = 60 // In minutes.
+
+func handleTempTarget(cs CommanderStore, tr *targets.Resolver, e defs.EventInfo,
+	data defs.CommandInteraction, cu cleanUp) (*defs.MessageData, error) {
+	var preset string
+	var low, high float64
+	minutes := defaultTempTarget
+	var err error
+
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "preset":
+			preset = opt.Value
+		case "minutes":
+			minutes, err = strconv.Atoi(opt.Value)
+		case "low":
+			low, err = strconv.ParseFloat(opt.Value, 64)
+		case "high":
+			high, err = strconv.ParseFloat(opt.Value, 64)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	th, ok := tr.Preset(preset)
+	if !ok && preset != defs.CancelTempTarget {
+		return nil, fmt.Errorf("unknown preset: %s", preset)
+	}
+	if low > 0 {
+		th.Low = low
+	}
+	if high > 0 {
+		th.High = high
+	}
+	if ok && th.Low >= th.High {
+		return nil, fmt.Errorf("invalid range: %.1f - %.1f", th.Low, th.High)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Only one temporary target is active at a time, so any active ones are
+	// cut short, whether it is being cancelled or replaced.
+	active, err := cs.ReadTempTargets(ctx, now.Add(-defs.MaxTempTarget*time.Minute), now)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled := 0
+	for _, tt := range active {
+		if !tt.Active(now) {
+			continue
+		}
+		tt.End, tt.CancelledBy = now, e.User
+		if _, err := cs.UpdateTempTarget(ctx, &tt); err != nil {
+			return nil, fmt.Errorf("unable to cancel temporary target: %w", err)
+		}
+		cancelled++
+	}
+
+	if preset == defs.CancelTempTarget {
+		if cancelled == 0 {
+			return nil, fmt.Errorf("no active temporary target found")
+		}
+		return &defs.MessageData{Content: "🎯 temporary target cancelled by " + e.User}, cu()
+	}
+
+	tt := defs.TempTarget{
+		Time:      now,
+		End:       now.Add(time.Duration(minutes) * time.Minute),
+		Preset:    preset,
+		Low:       th.Low,
+		High:      th.High,
+		Target:    th.Target,
+		CreatedBy: e.User,
+	}
+	if _, err := cs.WriteTempTarget(ctx, &tt); err != nil {
+		return nil, fmt.Errorf("unable to write temporary target: %w", err)
+	}
+
+	return &defs.MessageData{
+		Content: fmt.Sprintf(
+			"🎯 %s set by %s",
+			tr.WithOverrides([]defs.TempTarget{tt}).Describe(now),
+			e.User,
+		),
+	}, cu()
+}
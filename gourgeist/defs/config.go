@@ -22,16 +22,35 @@ const (
 	ReportsChannel = "reports"
 )
 
+// Display backends.
+const (
+	DiscordDisplay  = "discord"
+	SlackDisplay    = "slack"
+	TelegramDisplay = "telegram"
+	MatrixDisplay   = "matrix"
+)
+
 type Config struct {
-	Dexcom        DexcomConfig  `yaml:"dexcom"`
-	Discord       DiscordConfig `yaml:"discord"`
-	Mongo         MongoConfig   `yaml:"mongo"`
-	Glucose       GlucoseConfig `yaml:"glucose"`
-	Alarm         AlarmConfig   `yaml:"alarm"`
-	TrevenantAddr string        `yaml:"trevenantAddress"`
-	Timezone      string        `yaml:"timezone"`
-	Skeleton      bool          `yaml:"skeleton"`
-	Logger        *zap.Logger   `yaml:"_,omitempty"`
+	Dexcom        DexcomConfig   `yaml:"dexcom"`
+	Display       string         `yaml:"display"` // Defaults to discord.
+	Discord       DiscordConfig  `yaml:"discord"`
+	Slack         SlackConfig    `yaml:"slack"`
+	Telegram      TelegramConfig `yaml:"telegram"`
+	Matrix        MatrixConfig   `yaml:"matrix"`
+	Mongo         MongoConfig    `yaml:"mongo"`
+	Glucose       GlucoseConfig  `yaml:"glucose"`
+	Alarm         AlarmConfig    `yaml:"alarm"`
+	Insulin       InsulinConfig  `yaml:"insulin"`
+	Carbs         CarbConfig     `yaml:"carbs"`
+	Therapy       TherapyConfig  `yaml:"therapy"`
+	Sensor        SensorConfig   `yaml:"sensor"`
+	Quality       QualityConfig  `yaml:"quality"`
+	Notify        NotifyConfig   `yaml:"notify"`
+	Rules         []AlertRule    `yaml:"rules"`
+	TrevenantAddr string         `yaml:"trevenantAddress"`
+	Timezone      string         `yaml:"timezone"`
+	Skeleton      bool           `yaml:"skeleton"`
+	Logger        *zap.Logger    `yaml:"_,omitempty"`
 }
 
 type DexcomConfig struct {
@@ -44,15 +63,457 @@ type DiscordConfig struct {
 	Guild int    `yaml:"guild"`
 }
 
+// SlackConfig describes the Slack app used as the display. The slash
+// commands and interactivity of the app are sent to the address, and
+// verified with the signing secret.
+type SlackConfig struct {
+	Token         string `yaml:"token"` // Bot token.
+	SigningSecret string `yaml:"signingSecret"`
+	Address       string `yaml:"address"`
+}
+
+// DefaultSlackAddress is where the Slack requests are served, when not
+// configured.
+const DefaultSlackAddress = ":4343"
+
+// TelegramConfig describes the Telegram bot used as the display. Messages are
+// sent to the chat of their channel, or to the main chat when it has none,
+// and commands are only taken from these chats.
+type TelegramConfig struct {
+	Token string           `yaml:"token"`
+	Chat  int64            `yaml:"chat"`
+	Chats map[string]int64 `yaml:"chats"` // Chat IDs by channel.
+}
+
+// MatrixConfig describes the Matrix user used as the display. Rooms are
+// given by ID or alias, and the rooms of channels without one are created on
+// the homeserver, inviting the users.
+type MatrixConfig struct {
+	Homeserver  string            `yaml:"homeserver"`
+	UserID      string            `yaml:"userID"`
+	AccessToken string            `yaml:"accessToken"`
+	Rooms       map[string]string `yaml:"rooms"` // Rooms by channel.
+	Invite      []string          `yaml:"invite"`
+}
+
 type GlucoseConfig struct {
 	Low    float64 `yaml:"low"`
 	High   float64 `yaml:"high"`
 	Target float64 `yaml:"target"`
+
+	// Named threshold profiles, which replace the thresholds above while
+	// they are scheduled.
+	Profiles map[string]Thresholds `yaml:"profiles"`
+	Schedule []ScheduleEntry       `yaml:"schedule"`
+
+	// Presets for temporary targets, which override the defaults below.
+	Presets map[string]Thresholds `yaml:"presets"`
+}
+
+type Thresholds struct {
+	Low    float64 `yaml:"low"`
+	High   float64 `yaml:"high"`
+	Target float64 `yaml:"target"`
+}
+
+// ScheduleEntry activates a profile between the start and end times of
+// day, on the given days of the week (e.g. mon, tue), or every day if none
+// are given.
+type ScheduleEntry struct {
+	Days    []string `yaml:"days"`
+	Start   string   `yaml:"start"`
+	End     string   `yaml:"end"`
+	Profile string   `yaml:"profile"`
 }
 
 type AlarmConfig struct {
-	GlucoseTimeout   int `yaml:"glucoseTimeout"`
-	NoInsulinTimeout int `yaml:"noInsulinTimeout"`
+	GlucoseTimeout   int     `yaml:"glucoseTimeout"`
+	NoInsulinTimeout int     `yaml:"noInsulinTimeout"`
+	RateOfChange     float64 `yaml:"rateOfChange"` // In mmol/L/min.
+	RateWindow       int     `yaml:"rateWindow"`   // In minutes.
+	NoDataTimeout    int     `yaml:"noDataTimeout"`
+	NoDataReminder   int     `yaml:"noDataReminder"`
+
+	// Glucose alerts resolve once the glucose is back past the threshold by
+	// the hysteresis, and re-alert while open only when the glucose worsens
+	// by the delta since the last notification. Both in mmol/L. Within the
+	// reentry (in minutes) of resolving, they need the glucose past the
+	// threshold by the hysteresis to trigger again.
+	Hysteresis float64 `yaml:"hysteresis"`
+	Delta      float64 `yaml:"delta"`
+	Reentry    int     `yaml:"reentry"`
+
+	// Priorities override the severity of the alerts with the label, either
+	// info, warning or urgent.
+	Priorities map[string]string `yaml:"priorities"`
+	Quiet      QuietConfig       `yaml:"quiet"`
+
+	// Checks override how each check of the analyzer runs, by name. A check
+	// failing checkFailures times in a row raises an alert.
+	Checks        map[string]CheckConfig `yaml:"checks"`
+	CheckFailures int                    `yaml:"checkFailures"`
+
+	Escalation EscalationConfig `yaml:"escalation"`
+	Bolus      BolusAlarmConfig `yaml:"bolus"`
+	Basal      BasalConfig      `yaml:"basal"`
+	Stacking   StackingConfig   `yaml:"stacking"`
+	Treatment  TreatmentConfig  `yaml:"treatment"`
+}
+
+// CheckConfig describes how often a check runs, where zero runs it every
+// minute, and how long it can run for.
+type CheckConfig struct {
+	Interval int `yaml:"interval"` // In minutes.
+	Timeout  int `yaml:"timeout"`  // In seconds.
+}
+
+// QuietConfig describes the time of day (e.g. 22:00 to 07:00) during which
+// only urgent alerts are sent, with the others held back for a digest once
+// it is over. Quiet hours are disabled when the start is not set.
+type QuietConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// TreatmentConfig describes the prompts to treat a low, with carbs grams of
+// fast carbs, then re-checking every recheck minutes until back in range.
+type TreatmentConfig struct {
+	Carbs   int `yaml:"carbs"`
+	Recheck int `yaml:"recheck"` // In minutes.
+}
+
+// StackingConfig describes when rapid acting doses are considered stacked,
+// either when logged with at least IOB units still on board, or when
+// corrections are repeated.
+type StackingConfig struct {
+	IOB         float64 `yaml:"iob"`
+	Corrections int     `yaml:"corrections"`
+	Window      int     `yaml:"window"` // In minutes, for the corrections.
+}
+
+// BasalConfig schedules the expected slow acting insulin doses, replacing
+// the check for a dose in the last 24 hours.
+type BasalConfig struct {
+	Doses []BasalDose `yaml:"doses"`
+}
+
+// BasalDose is expected daily at the time of day (e.g. 22:00), give or take
+// the tolerance.
+type BasalDose struct {
+	Time      string  `yaml:"time"`
+	Tolerance int     `yaml:"tolerance"` // In minutes.
+	Units     float64 `yaml:"units"`     // Optional, shown in the reminder.
+}
+
+// BolusAlarmConfig describes when to ask about a forgotten bolus, either for
+// carbs logged without rapid insulin within the window, or for a sustained
+// rise in glucose without carbs or rapid insulin logged.
+type BolusAlarmConfig struct {
+	Window     int     `yaml:"window"`     // In minutes, either side of the carbs.
+	RiseRate   float64 `yaml:"riseRate"`   // In mmol/L/min.
+	RiseWindow int     `yaml:"riseWindow"` // In minutes.
+	Cooldown   int     `yaml:"cooldown"`   // In minutes, between unannounced meals.
+}
+
+// EscalationConfig describes who to notify when urgent alerts are left
+// unacknowledged, in order of patient, caregivers, then everyone.
+type EscalationConfig struct {
+	Timeout    int      `yaml:"timeout"` // In minutes, between each step.
+	Patient    uint64   `yaml:"patient"` // Discord user IDs.
+	Caregivers []uint64 `yaml:"caregivers"`
+}
+
+// SensorConfig describes the lifetime of a sensor, and the reminders sent
+// before it expires. A gap in readings of at least the warmup is taken as a
+// new sensor, inserted the warmup before the first reading after the gap.
+type SensorConfig struct {
+	Lifetime  int   `yaml:"lifetime"`  // In hours.
+	Warmup    int   `yaml:"warmup"`    // In minutes.
+	Reminders []int `yaml:"reminders"` // In hours before the expiry.
+}
+
+// Defaults for the sensor sessions, used when not configured.
+const (
+	DefaultSensorLifetime = 240
+	DefaultSensorWarmup   = 120
+)
+
+// DefaultSensorReminders are the hours before the expiry to remind at.
+var DefaultSensorReminders = []int{24, 2}
+
+// LifetimeDuration returns how long a sensor lasts, the default if not
+// configured.
+func (c SensorConfig) LifetimeDuration() time.Duration {
+	if c.Lifetime <= 0 {
+		return DefaultSensorLifetime * time.Hour
+	}
+	return time.Duration(c.Lifetime) * time.Hour
+}
+
+// QualityConfig describes the sensor artifacts to flag. A compression low is
+// a drop of at least drop at no less than rate, which rebounds to within
+// half the drop within the rebound, optionally only during the night. Noise
+// is the glucose reversing by at least noise twice within half an hour.
+// Suspect readings never trigger low alerts unless very low, and are left
+// out of the statistics when excluded. The readings are only checked when
+// enabled.
+type QualityConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	Rate    float64     `yaml:"rate"`    // In mmol/L/min.
+	Drop    float64     `yaml:"drop"`    // In mmol/L.
+	Rebound int         `yaml:"rebound"` // In minutes.
+	Noise   float64     `yaml:"noise"`   // In mmol/L.
+	Night   *TimeWindow `yaml:"night"`
+	Exclude bool        `yaml:"exclude"`
+}
+
+// Defaults for the sensor artifacts, used when not configured.
+const (
+	DefaultCompressionRate    = 0.2
+	DefaultCompressionDrop    = 2.0
+	DefaultCompressionRebound = 60
+	DefaultNoise              = 1.0
+)
+
+// NotifyConfig describes where the events are sent, besides Discord.
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Email    *EmailConfig    `yaml:"email"`
+}
+
+// WebhookConfig describes a URL the events are POSTed to as JSON, signed
+// with the secret. Only the listed events are sent, or all of them if none
+// are. Failed deliveries are retried, backing off exponentially, before
+// being logged as dead letters.
+type WebhookConfig struct {
+	URL     string   `yaml:"url"`
+	Secret  string   `yaml:"secret"`
+	Events  []string `yaml:"events"`  // alert, reading or treatment.
+	Retries int      `yaml:"retries"` // After the first attempt.
+	Backoff int      `yaml:"backoff"` // In seconds, doubled on each retry.
+	Timeout int      `yaml:"timeout"` // In seconds, per attempt.
+}
+
+// Defaults for the webhooks, used when not configured.
+const (
+	DefaultWebhookRetries = 3
+	DefaultWebhookBackoff = 2
+	DefaultWebhookTimeout = 10
+)
+
+// EmailConfig describes the SMTP server the events are emailed through,
+// and to whom. Only alerts are emailed unless other events are listed.
+// Authentication is skipped without a username.
+type EmailConfig struct {
+	Host     string         `yaml:"host"`
+	Port     int            `yaml:"port"`
+	Timeout  int            `yaml:"timeout"` // In seconds, per email.
+	Username string         `yaml:"username"`
+	Password string         `yaml:"password"`
+	From     string         `yaml:"from"`
+	To       []string       `yaml:"to"`
+	Events   []string       `yaml:"events"`
+	Summary  *SummaryConfig `yaml:"summary"`
+}
+
+// SummaryConfig describes the summary email sent every day at the time of
+// day, covering the last days, with the plot attached if enabled.
+type SummaryConfig struct {
+	At   string `yaml:"at"`
+	Days int    `yaml:"days"`
+	Plot bool   `yaml:"plot"`
+}
+
+// Defaults for the emails, used when not configured.
+const (
+	DefaultSMTPPort    = 587
+	DefaultSMTPTimeout = 30
+	DefaultSummaryDays = 1
+)
+
+// InsulinConfig describes how each type of insulin acts over time.
+type InsulinConfig struct {
+	Rapid ActionCurve `yaml:"rapid"`
+	Slow  ActionCurve `yaml:"slow"`
+}
+
+// ActionCurve is an insulin activity curve, either exponential or bilinear,
+// which peaks at Peak and ends at DIA (duration of insulin action).
+type ActionCurve struct {
+	Model string `yaml:"model"`
+	DIA   int    `yaml:"dia"`  // In minutes.
+	Peak  int    `yaml:"peak"` // In minutes.
+}
+
+const (
+	ExponentialCurve = "exponential"
+	BilinearCurve    = "bilinear"
+)
+
+// Defaults for the insulin action curves, used when not configured.
+var (
+	DefaultRapidCurve = ActionCurve{Model: ExponentialCurve, DIA: 360, Peak: 75}
+	DefaultSlowCurve  = ActionCurve{Model: BilinearCurve, DIA: 1440, Peak: 720}
+)
+
+// CarbConfig describes how carbs are absorbed over time. When both the CSF
+// and ISF are given, the absorption is refined by the observed rise in
+// glucose that is not explained by insulin.
+type CarbConfig struct {
+	Model      string  `yaml:"model"`
+	Absorption int     `yaml:"absorption"` // In minutes.
+	CSF        float64 `yaml:"csf"`        // Rise in mmol/L per gram.
+	ISF        float64 `yaml:"isf"`        // Drop in mmol/L per unit.
+}
+
+// TherapyConfig holds the settings used by the bolus calculator, with named
+// profiles that replace them while they are scheduled.
+type TherapyConfig struct {
+	TherapySettings `yaml:",inline"`
+
+	Increment float64                    `yaml:"increment"` // Smallest dose, in units.
+	Profiles  map[string]TherapySettings `yaml:"profiles"`
+	Schedule  []ScheduleEntry            `yaml:"schedule"`
+}
+
+type TherapySettings struct {
+	ICR    float64 `yaml:"icr"`    // Grams of carbs per unit.
+	ISF    float64 `yaml:"isf"`    // Drop in mmol/L per unit.
+	Target float64 `yaml:"target"` // Defaults to the glucose target.
+}
+
+// DefaultIncrement is the smallest dose suggested, used when not configured.
+const DefaultIncrement = 0.5
+
+const (
+	LinearAbsorption    = "linear"
+	PiecewiseAbsorption = "piecewise"
+)
+
+// Defaults for the carb absorption, used when not configured.
+const (
+	DefaultAbsorptionModel = PiecewiseAbsorption
+	DefaultAbsorption      = 180
+)
+
+// MaxAbsorption is the longest absorption carbs can be recorded with, in
+// minutes, unless the configured absorption is longer.
+const MaxAbsorption = 360
+
+// Defaults for the rate of change alerts, used when not configured.
+const (
+	DefaultRateOfChange = 0.17
+	DefaultRateWindow   = 15
+)
+
+// Defaults for the missed bolus alerts, used when not configured.
+const (
+	DefaultBolusWindow    = 30
+	DefaultMealRiseRate   = 0.05
+	DefaultMealRiseWindow = 30
+	DefaultMealCooldown   = 180
+)
+
+// Defaults for the stacking warnings, used when not configured.
+const (
+	DefaultStackingIOB         = 1.0
+	DefaultStackingCorrections = 2
+	DefaultStackingWindow      = 180
+)
+
+// Defaults for the low treatment prompts, used when not configured.
+const (
+	DefaultTreatmentCarbs   = 15
+	DefaultTreatmentRecheck = 15
+)
+
+// DefaultBasalTolerance is how far off the scheduled time a basal dose can
+// be, in minutes, used when not configured.
+const DefaultBasalTolerance = 45
+
+// Defaults for the missing data alerts, used when not configured.
+const (
+	DefaultNoDataTimeout  = 20
+	DefaultNoDataReminder = 30
+)
+
+// Defaults for the analyzer checks, used when not configured.
+const (
+	DefaultCheckTimeout  = 30 // In seconds.
+	DefaultCheckFailures = 5
+)
+
+// Defaults for the glucose alert lifecycle, in mmol/L, used when not
+// configured.
+const (
+	DefaultHysteresis = 0.5
+	DefaultAlertDelta = 1.0
+	DefaultReentry    = 30 // In minutes.
+)
+
+// Presets for temporary targets, used when not configured.
+var DefaultPresets = map[string]Thresholds{
+	ExercisePreset: {Low: 5.5, High: 11, Target: 8},
+	SickDayPreset:  {Low: 4.5, High: 12, Target: 7},
+	PreMealPreset:  {Low: 4, High: 7, Target: 5},
+}
+
+// MaxTempTarget is the longest a temporary target can last, in minutes.
+const MaxTempTarget = 1440
+
+// AlertRule raises an alert when its condition holds for the whole
+// duration. The name of the rule is used as the label of the alert.
+type AlertRule struct {
+	Name      string        `yaml:"name"`
+	Condition RuleCondition `yaml:"condition"`
+	Duration  int           `yaml:"duration"` // In minutes.
+	Severity  string        `yaml:"severity"`
+	Cooldown  int           `yaml:"cooldown"` // In minutes, for rules not on the glucose.
+	Message   string        `yaml:"message"`  // A text/template.
+	Channels  []string      `yaml:"channels"`
+}
+
+// RuleCondition holds when all of its set fields hold. The range holds
+// when glucose is above or below the active thresholds.
+type RuleCondition struct {
+	Range          string          `yaml:"range"` // Either above or below.
+	Glucose        *Bounds         `yaml:"glucose"`
+	IOB            *Bounds         `yaml:"iob"`
+	COB            *Bounds         `yaml:"cob"`
+	Trends         []string        `yaml:"trends"`
+	Time           *TimeWindow     `yaml:"time"`
+	MissingInsulin *MissingInsulin `yaml:"missingInsulin"`
+
+	RepeatedCorrections *RepeatedCorrections `yaml:"repeatedCorrections"`
+}
+
+// Bounds are inclusive, and either side can be left unset.
+type Bounds struct {
+	Above *float64 `yaml:"above"`
+	Below *float64 `yaml:"below"`
+}
+
+// TimeWindow is a time of day range such as 22:00 to 06:00, which wraps
+// around midnight when the end is before the start.
+type TimeWindow struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// MissingInsulin holds when no insulin of the type was logged within the
+// last given minutes.
+type MissingInsulin struct {
+	Type   string `yaml:"type"`
+	Within int    `yaml:"within"`
+}
+
+// RepeatedCorrections holds when at least count correction boluses were
+// logged in the last within minutes, where a correction is a rapid acting
+// dose without carbs logged within mealWindow minutes of it.
+type RepeatedCorrections struct {
+	Count      int `yaml:"count"`
+	Within     int `yaml:"within"`
+	MealWindow int `yaml:"mealWindow"`
 }
 
 type MongoConfig struct {
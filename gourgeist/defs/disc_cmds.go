@@ -15,8 +15,20 @@ const (
 	EditInsulinCmd = "editinsulin"
 	EditVisCmd     = "editvis"
 	GenReportCmd   = "genreport"
+	AckCmd         = "ack"
+	SnoozeCmd      = "snooze"
+	TempTargetCmd  = "temptarget"
+	BolusCmd       = "bolus"
+	SensorCmd      = "sensor"
+	AlertsCmd      = "alerts"
+	DeadLettersCmd = "deadletters"
+	ChecksCmd      = "checks"
 )
 
+// SubcommandOption is the option that subcommands are passed as, followed by
+// their own options.
+const SubcommandOption = "subcommand"
+
 // Register commands under here to get deployed.
 var Commands []api.CreateCommandData = []api.CreateCommandData{
 	addCarbsCmdData,
@@ -25,6 +37,14 @@ var Commands []api.CreateCommandData = []api.CreateCommandData{
 	editInsulinCmdData,
 	editVisCmdData,
 	generateReportCmdData,
+	ackCmdData,
+	snoozeCmdData,
+	tempTargetCmdData,
+	bolusCmdData,
+	sensorCmdData,
+	alertsCmdData,
+	deadLettersCmdData,
+	checksCmdData,
 }
 
 var addCarbsCmdData api.CreateCommandData = api.CreateCommandData{
@@ -37,6 +57,17 @@ var addCarbsCmdData api.CreateCommandData = api.CreateCommandData{
 			Min:         option.ZeroInt,
 			Required:    true,
 		},
+		&discord.IntegerOption{
+			OptionName:  "absorption",
+			Description: "Absorption time, defaults to the configured time.",
+			Choices: []discord.IntegerChoice{
+				{Name: "fast (2h)", Value: 120},
+				{Name: "medium (3h)", Value: 180},
+				{Name: "slow (4h)", Value: 240},
+				{Name: "very slow (6h)", Value: MaxAbsorption},
+			},
+			Required: false,
+		},
 	},
 }
 
@@ -81,12 +112,17 @@ var addInsulinCmdData api.CreateCommandData = api.CreateCommandData{
 				},
 			},
 		},
-		&discord.IntegerOption{
+		&discord.NumberOption{
 			OptionName:  "units",
 			Description: "Units of insulin.",
-			Min:         option.ZeroInt,
+			Min:         option.NewFloat(0),
 			Required:    true,
 		},
+		&discord.StringOption{
+			OptionName:  "suggestion",
+			Description: "Id of the suggested bolus, which is only logged once.",
+			Required:    false,
+		},
 	},
 }
 
@@ -113,7 +149,7 @@ var editInsulinCmdData api.CreateCommandData = api.CreateCommandData{
 				},
 			},
 		},
-		&discord.IntegerOption{
+		&discord.NumberOption{
 			OptionName:  "units",
 			Description: "New units of insulin. Negative values indicate deletion.",
 		},
@@ -163,3 +199,249 @@ var generateReportCmdData api.CreateCommandData = api.CreateCommandData{
 		},
 	},
 }
+
+var ackCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        AckCmd,
+	Description: "Acknowledge an alert, stopping its escalation.",
+	Options: discord.CommandOptions{
+		&discord.StringOption{
+			OptionName:  "id",
+			Description: "Id of the alert, defaults to the most recent one.",
+			Required:    false,
+		},
+	},
+}
+
+var snoozeCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        SnoozeCmd,
+	Description: "Snooze an alert for a given duration.",
+	Options: discord.CommandOptions{
+		&discord.IntegerOption{
+			OptionName:  "minutes",
+			Description: "Duration of the snooze.",
+			Choices: []discord.IntegerChoice{
+				{Name: "15m", Value: 15},
+				{Name: "30m", Value: 30},
+				{Name: "1h", Value: 60},
+				{Name: "2h", Value: 120},
+				{Name: "4h", Value: 240},
+				{Name: "8h", Value: 480},
+			},
+			Required: true,
+		},
+		&discord.StringOption{
+			OptionName:  "id",
+			Description: "Id of the alert, defaults to the most recent one.",
+			Required:    false,
+		},
+	},
+}
+
+// CancelTempTarget is the preset used to cancel the active temporary target.
+const CancelTempTarget = "cancel"
+
+// maxChoices is the most choices an option can have.
+const maxChoices = 25
+
+// presetNames are the names the default presets are shown with.
+var presetNames = map[string]string{
+	ExercisePreset: "exercise",
+	SickDayPreset:  "sick day",
+	PreMealPreset:  "pre-meal",
+}
+
+// SetPresets offers the given presets as the choices of the temptarget
+// command, along with cancelling. It has to be called before the commands
+// are registered.
+func SetPresets(presets []string) {
+	choices := make([]discord.StringChoice, 0)
+	for _, preset := range presets {
+		if len(choices) == maxChoices-1 {
+			break
+		}
+		name := preset
+		if n, ok := presetNames[preset]; ok {
+			name = n
+		}
+		choices = append(choices, discord.StringChoice{Name: name, Value: preset})
+	}
+	choices = append(choices, discord.StringChoice{Name: "cancel", Value: CancelTempTarget})
+
+	for _, opt := range tempTargetCmdData.Options {
+		if so, ok := opt.(*discord.StringOption); ok && so.OptionName == "preset" {
+			so.Choices = choices
+		}
+	}
+}
+
+var tempTargetCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        TempTargetCmd,
+	Description: "Temporarily override the glucose thresholds.",
+	Options: discord.CommandOptions{
+		&discord.StringOption{
+			OptionName:  "preset",
+			Description: "Thresholds to use, or cancel the active override.",
+			Choices: []discord.StringChoice{
+				{Name: "exercise", Value: ExercisePreset},
+				{Name: "sick day", Value: SickDayPreset},
+				{Name: "pre-meal", Value: PreMealPreset},
+				{Name: "cancel", Value: CancelTempTarget},
+			},
+			Required: true,
+		},
+		&discord.IntegerOption{
+			OptionName:  "minutes",
+			Description: "Duration of the override, defaults to an hour.",
+			Min:         option.NewInt(1),
+			Max:         option.NewInt(MaxTempTarget),
+			Required:    false,
+		},
+		&discord.NumberOption{
+			OptionName:  "low",
+			Description: "Low threshold, overriding the preset (mmol/L).",
+			Min:         option.NewFloat(0),
+			Required:    false,
+		},
+		&discord.NumberOption{
+			OptionName:  "high",
+			Description: "High threshold, overriding the preset (mmol/L).",
+			Min:         option.NewFloat(0),
+			Required:    false,
+		},
+	},
+}
+
+var bolusCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        BolusCmd,
+	Description: "Suggest a dose of rapid acting insulin for a meal.",
+	Options: discord.CommandOptions{
+		&discord.IntegerOption{
+			OptionName:  "carbs",
+			Description: "Planned carbohydrates (grams).",
+			Min:         option.ZeroInt,
+			Required:    true,
+		},
+	},
+}
+
+// Subcommands of the sensor command.
+const (
+	SensorStart  = "start"
+	SensorStop   = "stop"
+	SensorStatus = "status"
+)
+
+var sensorCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        SensorCmd,
+	Description: "Track the sensor sessions.",
+	Options: discord.CommandOptions{
+		&discord.SubcommandOption{
+			OptionName:  SensorStart,
+			Description: "Start a new sensor, ending the current one.",
+			Options: []discord.CommandOptionValue{
+				&discord.IntegerOption{
+					OptionName:  "offset",
+					Description: "Minutes since the sensor was inserted.",
+					Min:         option.ZeroInt,
+					Required:    false,
+				},
+				&discord.StringOption{
+					OptionName:  "lot",
+					Description: "Lot number of the sensor.",
+					Required:    false,
+				},
+				&discord.StringOption{
+					OptionName:  "notes",
+					Description: "Notes, such as the insertion site.",
+					Required:    false,
+				},
+			},
+		},
+		&discord.SubcommandOption{
+			OptionName:  SensorStop,
+			Description: "End the current sensor early.",
+		},
+		&discord.SubcommandOption{
+			OptionName:  SensorStatus,
+			Description: "Show the current sensor, and how long sensors last.",
+		},
+	},
+}
+
+// States the alerts command filters by.
+const (
+	AlertsOpen     = "open"
+	AlertsResolved = "resolved"
+)
+
+var alertsCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        AlertsCmd,
+	Description: "List the recent alerts.",
+	Options: discord.CommandOptions{
+		&discord.IntegerOption{
+			OptionName:  "hours",
+			Description: "How far back to list, defaults to a day.",
+			Choices: []discord.IntegerChoice{
+				{Name: "6h", Value: 6},
+				{Name: "1d", Value: 24},
+				{Name: "3d", Value: 72},
+				{Name: "1w", Value: 168},
+				{Name: "30d", Value: 720},
+			},
+			Required: false,
+		},
+		&discord.StringOption{
+			OptionName:  "label",
+			Description: "Only the alerts with the label, or part of it.",
+			Required:    false,
+		},
+		&discord.StringOption{
+			OptionName:  "severity",
+			Description: "Only the alerts of the severity.",
+			Choices: []discord.StringChoice{
+				{Name: "info", Value: Info.String()},
+				{Name: "warning", Value: Warning.String()},
+				{Name: "urgent", Value: Urgent.String()},
+			},
+			Required: false,
+		},
+		&discord.StringOption{
+			OptionName:  "state",
+			Description: "Only the open or the resolved alerts.",
+			Choices: []discord.StringChoice{
+				{Name: "open", Value: AlertsOpen},
+				{Name: "resolved", Value: AlertsResolved},
+			},
+			Required: false,
+		},
+	},
+}
+
+var deadLettersCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        DeadLettersCmd,
+	Description: "List the events that could not be delivered to the webhooks.",
+	Options: discord.CommandOptions{
+		&discord.IntegerOption{
+			OptionName:  "hours",
+			Description: "How far back to list, defaults to a day.",
+			Choices: []discord.IntegerChoice{
+				{Name: "6h", Value: 6},
+				{Name: "1d", Value: 24},
+				{Name: "3d", Value: 72},
+				{Name: "1w", Value: 168},
+				{Name: "30d", Value: 720},
+			},
+			Required: false,
+		},
+		&discord.BooleanOption{
+			OptionName:  "replay",
+			Description: "Deliver the events again, removing those that are.",
+			Required:    false,
+		},
+	},
+}
+
+var checksCmdData api.CreateCommandData = api.CreateCommandData{
+	Name:        ChecksCmd,
+	Description: "List the runs, failures and durations of the alert checks.",
+}
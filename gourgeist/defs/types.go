@@ -1,6 +1,7 @@
 package defs
 
 import (
+	"fmt"
 	"io"
 	"time"
 
@@ -24,8 +25,17 @@ type TransformedReading struct {
 	Time  time.Time  `bson:"time"`
 	Mmol  float64    `bson:"mmol"`
 	Trend string     `bson:"trend"`
+
+	// Suspect is why the reading is likely a sensor artifact, if it is.
+	Suspect string `bson:"suspect,omitempty"`
 }
 
+// Reasons a reading is suspect.
+const (
+	CompressionSuspect = "compression"
+	NoiseSuspect       = "noise"
+)
+
 type InsulinType int
 
 const (
@@ -42,26 +52,217 @@ type Insulin struct {
 	Time   time.Time  `bson:"time"`
 	Type   string     `bson:"type"`
 	Amount float64    `bson:"amount"`
+
+	// Suggestion is the id of the suggested bolus the dose was logged from.
+	Suggestion string `bson:"suggestion,omitempty"`
 }
 
 type Carb struct {
-	ID     MyObjectID `bson:"_id,omitempty"`
-	Time   time.Time  `bson:"time"`
-	Amount float64    `bson:"amount"`
+	ID         MyObjectID `bson:"_id,omitempty"`
+	Time       time.Time  `bson:"time"`
+	Amount     float64    `bson:"amount"`
+	Absorption int        `bson:"absorption,omitempty"` // In minutes, defaults to the config.
 }
 
 // Labels.
 const (
 	HighGlucoseLabel        = "High Glucose"
 	LowGlucoseLabel         = "Low Glucose"
+	RapidRiseLabel          = "Rapid Rise"
+	RapidFallLabel          = "Rapid Fall"
+	NoDataLabel             = "No Data"
+	DataRestoredLabel       = "Data Restored"
 	MissingSlowInsulinLabel = "Missing Slow Acting Insulin"
+	DoubleDoseLabel         = "Possible Double Dose"
+	RepeatedCorrectionLabel = "Repeated Corrections"
+	MissedBolusLabel        = "Missed Bolus"
+	UnannouncedMealLabel    = "Unannounced Meal"
+	CheckFailingLabel       = "Check Failing"
+	SensorExpiryLabel       = "Sensor Expiry"
+)
+
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Urgent
+)
+
+func (s Severity) String() string {
+	return [...]string{"info", "warning", "urgent"}[s]
+}
+
+func ParseSeverity(s string) (Severity, error) {
+	for _, sev := range []Severity{Info, Warning, Urgent} {
+		if sev.String() == s {
+			return sev, nil
+		}
+	}
+	return Info, fmt.Errorf("unknown severity: %s", s)
+}
+
+// Escalation levels for urgent alerts that have not been acknowledged.
+const (
+	EscalateChannel = iota
+	EscalatePatient
+	EscalateCaregivers
+	EscalateEveryone
 )
 
 type Alert struct {
+	ID       MyObjectID `bson:"_id,omitempty"`
+	Time     time.Time  `bson:"time"`
+	Label    string     `bson:"label"`
+	Reason   string     `bson:"reason"`
+	Severity Severity   `bson:"severity"`
+
+	AckedAt      time.Time `bson:"ackedAt,omitempty"`
+	AckedBy      string    `bson:"ackedBy,omitempty"`
+	SnoozedUntil time.Time `bson:"snoozedUntil,omitempty"`
+	Escalation   int       `bson:"escalation"`
+	EscalatedAt  time.Time `bson:"escalatedAt,omitempty"`
+
+	// Alerts on the glucose are tracked through their lifecycle, with the
+	// value they were last notified with.
+	State      AlertState   `bson:"state,omitempty"`
+	Value      float64      `bson:"value,omitempty"`
+	ResolvedAt time.Time    `bson:"resolvedAt,omitempty"`
+	Events     []AlertEvent `bson:"events,omitempty"`
+
+	// Alerts raised during the quiet hours are held back for the digest.
+	Quiet      bool      `bson:"quiet,omitempty"`
+	DigestedAt time.Time `bson:"digestedAt,omitempty"`
+}
+
+func (a Alert) Acked() bool {
+	return !a.AckedAt.IsZero()
+}
+
+func (a Alert) Snoozed(t time.Time) bool {
+	return a.SnoozedUntil.After(t)
+}
+
+func (a Alert) Resolved() bool {
+	return a.State == AlertResolved
+}
+
+// Open reports whether the alert is tracked and has yet to be resolved.
+func (a Alert) Open() bool {
+	return a.State != "" && !a.Resolved()
+}
+
+// Transition moves the alert to the state, recording it in the events.
+func (a *Alert) Transition(t time.Time, state AlertState, value float64, note string) {
+	a.State = state
+	if state == AlertResolved {
+		a.ResolvedAt = t
+	}
+	a.Events = append(a.Events, AlertEvent{Time: t, State: state, Value: value, Note: note})
+}
+
+// AlertState is the state of a tracked alert. An alert that is not open is
+// considered ok.
+type AlertState string
+
+const (
+	AlertTriggered    AlertState = "triggered"
+	AlertAcknowledged AlertState = "acknowledged"
+	AlertResolved     AlertState = "resolved"
+)
+
+// AlertEvent is a step in the lifecycle of an alert.
+type AlertEvent struct {
+	Time  time.Time  `bson:"time"`
+	State AlertState `bson:"state"`
+	Value float64    `bson:"value,omitempty"`
+	Note  string     `bson:"note,omitempty"`
+}
+
+// Sources of a sensor session.
+const (
+	SensorCommandSource = "command"
+	SensorWarmupSource  = "warmup" // Detected from the gap in readings.
+)
+
+// SensorSession is a CGM sensor, from its insertion until it expires or is
+// ended early.
+type SensorSession struct {
+	ID      MyObjectID `bson:"_id,omitempty"`
+	Time    time.Time  `bson:"time"` // Insertion.
+	Expiry  time.Time  `bson:"expiry"`
+	EndedAt time.Time  `bson:"endedAt,omitempty"`
+	Source  string     `bson:"source"`
+	Lot     string     `bson:"lot,omitempty"`
+	Notes   string     `bson:"notes,omitempty"`
+}
+
+// End returns when the session ended, or when it is expected to.
+func (s SensorSession) End() time.Time {
+	if !s.EndedAt.IsZero() && s.EndedAt.Before(s.Expiry) {
+		return s.EndedAt
+	}
+	return s.Expiry
+}
+
+// Active reports whether the sensor is in use at t.
+func (s SensorSession) Active(t time.Time) bool {
+	return !t.Before(s.Time) && t.Before(s.End())
+}
+
+// DeadLetter is an event that could not be delivered to a webhook, kept so
+// that it can be inspected or replayed.
+type DeadLetter struct {
+	ID       MyObjectID `bson:"_id,omitempty"`
+	Time     time.Time  `bson:"time"` // Of the last attempt.
+	URL      string     `bson:"url"`
+	Event    string     `bson:"event"`
+	Payload  string     `bson:"payload"`
+	Attempts int        `bson:"attempts"`
+	Error    string     `bson:"error"`
+}
+
+const (
+	ExercisePreset = "exercise"
+	SickDayPreset  = "sickday"
+	PreMealPreset  = "premeal"
+)
+
+// TempTarget overrides the scheduled glucose thresholds between its start
+// and end, until it expires or is cancelled.
+type TempTarget struct {
 	ID     MyObjectID `bson:"_id,omitempty"`
 	Time   time.Time  `bson:"time"`
-	Label  string     `bson:"label"`
-	Reason string     `bson:"reason"`
+	End    time.Time  `bson:"end"`
+	Preset string     `bson:"preset"`
+	Low    float64    `bson:"low"`
+	High   float64    `bson:"high"`
+	Target float64    `bson:"target"`
+
+	CreatedBy   string `bson:"createdBy,omitempty"`
+	CancelledBy string `bson:"cancelledBy,omitempty"`
+}
+
+func (tt TempTarget) Active(t time.Time) bool {
+	return !t.Before(tt.Time) && t.Before(tt.End)
+}
+
+// Treatment tracks the treatment of a low, from the low alert until glucose
+// is back in range.
+type Treatment struct {
+	ID      MyObjectID `bson:"_id,omitempty"`
+	Time    time.Time  `bson:"time"`
+	AlertID MyObjectID `bson:"alertId"`
+	Glucose float64    `bson:"glucose"` // When the low alert fired.
+	Nadir   float64    `bson:"nadir"`
+
+	Prompts    int       `bson:"prompts"`
+	LastPrompt time.Time `bson:"lastPrompt"`
+	ClosedAt   time.Time `bson:"closedAt,omitempty"`
+}
+
+func (t Treatment) Closed() bool {
+	return !t.ClosedAt.IsZero()
 }
 
 type Visibility uint64
@@ -87,7 +288,9 @@ type MessageData struct {
 	Content         string
 	Embeds          []EmbedData
 	Files           []FileData
+	Buttons         []ButtonData
 	MentionEveryone bool
+	Mentions        []uint64 // Discord users, only mentioned on Discord.
 }
 
 type EmbedData struct {
@@ -97,6 +300,9 @@ type EmbedData struct {
 	Image       *ImageData
 }
 
+// MaxEmbedFields is the most fields Discord shows in an embed.
+const MaxEmbedFields = 25
+
 type EmbedField struct {
 	Name   string
 	Value  string
@@ -112,6 +318,25 @@ type FileData struct {
 	Reader io.Reader
 }
 
+type ButtonStyle int
+
+const (
+	PrimaryButton ButtonStyle = iota
+	SecondaryButton
+	DangerButton
+)
+
+// ButtonData is a button attached to a message. Pressing it is handled
+// the same way as the command it carries.
+type ButtonData struct {
+	Label   string
+	Style   ButtonStyle
+	Command CommandInteraction
+}
+
+// RangeField is the name of the main embed field showing the active range.
+const RangeField = "Range"
+
 func EmptyEmbed() EmbedField {
 	return EmbedField{
 		Name:   "\u200b",
@@ -139,6 +364,7 @@ type EventInfo struct {
 	ID    uint64
 	AppID uint64
 	Token string
+	User  string
 }
 
 type CommandInteraction struct {
@@ -3,8 +3,12 @@ package gourgeist
 import (
 	"context"
 	"fmt"
+	"iv2/gourgeist/defs"
 	"iv2/gourgeist/pkg/dexcom"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -13,23 +17,79 @@ type FetcherStore interface {
 	mg.GlucoseStore
 }
 
+// FetchStatus describes the outcome of the most recent fetches, and is
+// used to pinpoint where the data stopped flowing.
+type FetchStatus struct {
+	LastAttempt time.Time // Last time the fetcher ran.
+	LastSuccess time.Time // Last time Share responded without error.
+	ShareErr    error     // Most recent Share error, cleared on success.
+	StoreErr    error     // Most recent store error, cleared on success.
+}
+
+type FetchMonitor interface {
+	Status() FetchStatus
+}
+
 type Fetcher struct {
 	Source dexcom.Source
 	Store  FetcherStore
 
+	// Notifier is sent the new readings, if set.
+	Notifier notify.Notifier
+
 	Logger *zap.Logger
+
+	mu     sync.Mutex
+	status FetchStatus
 }
 
 func (f *Fetcher) FetchAndLoad() error {
-	trs, _ := f.Source.Readings(context.Background(), dexcom.MinuteLimit, dexcom.CountLimit)
+	f.update(func(fs *FetchStatus) { fs.LastAttempt = time.Now() })
+
+	trs, err := f.Source.Readings(context.Background(), dexcom.MinuteLimit, dexcom.CountLimit)
+	f.update(func(fs *FetchStatus) {
+		fs.ShareErr = err
+		if err == nil {
+			fs.LastSuccess = time.Now()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("unable to fetch readings: %w", err)
+	}
+
+	var fresh []*defs.TransformedReading
 	for _, tr := range trs {
 		res, err := f.Store.WriteGlucose(context.Background(), tr)
 		if err != nil {
+			f.update(func(fs *FetchStatus) { fs.StoreErr = err })
 			return fmt.Errorf("unable to write glucose to store: %w", err)
 		}
 		if res.MatchedCount > 0 { // Matched.
 			break
 		}
+		fresh = append(fresh, tr)
+	}
+	f.update(func(fs *FetchStatus) { fs.StoreErr = nil })
+
+	// The readings are newest first, and are notified oldest first.
+	if f.Notifier != nil {
+		for i := len(fresh) - 1; i >= 0; i-- {
+			if err := f.Notifier.Notify(context.Background(), notify.Reading(*fresh[i])); err != nil {
+				f.Logger.Warn("unable to notify of reading", zap.Error(err))
+			}
+		}
 	}
 	return nil
 }
+
+func (f *Fetcher) update(fn func(*FetchStatus)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.status)
+}
+
+func (f *Fetcher) Status() FetchStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
@@ -0,0 +1,219 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/stats"
+	"strconv"
+	"time"
+)
+
+// quickDoses and quickCarbs are offered as quick-log buttons when there is
+// no better suggestion.
+var (
+	quickDoses = []float64{2, 4}
+	quickCarbs = []int{30, 60}
+)
+
+// fastAbsorption is the absorption time of fast carbs, in minutes.
+const fastAbsorption = 120
+
+// AnalyzeMissedBolus asks whether a bolus was forgotten, either for carbs
+// logged without rapid insulin nearby, or for a sustained rise in glucose
+// with neither carbs nor rapid insulin logged.
+func (an *Analyzer) AnalyzeMissedBolus(ctx context.Context) error {
+	if err := an.analyzeUnbolusedCarbs(ctx); err != nil {
+		return err
+	}
+	return an.analyzeUnannouncedMeal(ctx)
+}
+
+func (an *Analyzer) analyzeUnbolusedCarbs(ctx context.Context) error {
+	window := minutesOr(an.AlarmConfig.Bolus.Window, defs.DefaultBolusWindow)
+
+	now := time.Now()
+	start := now.Add(-2 * window)
+
+	cs, err := an.Store.ReadCarbs(ctx, start, now.Add(-window))
+	if err != nil {
+		return err
+	}
+
+	ins, err := an.Store.ReadInsulin(ctx, start.Add(-window), now)
+	if err != nil {
+		return err
+	}
+
+	alerts, _ := an.Store.ReadAlerts(ctx, start, now)
+
+	for _, c := range cs {
+		if hasRapidDose(ins, c.Time.Add(-window), c.Time.Add(window)) ||
+			alertedSince(alerts, defs.MissedBolusLabel, c.Time) {
+			continue
+		}
+
+		buttons := an.suggestedDose(c)
+		return an.raiseWith(ctx, &defs.Alert{
+			Time:  now,
+			Label: defs.MissedBolusLabel,
+			Reason: fmt.Sprintf(
+				"Did you forget to bolus?\n%.0fg of carbs logged at %s, without rapid insulin within %s",
+				c.Amount, c.Time.In(an.Location).Format(discgo.TimeFormat), window,
+			),
+			Severity: an.severityOf(defs.MissedBolusLabel),
+		}, true, buttons, defs.AlertsChannel)
+	}
+
+	return nil
+}
+
+func (an *Analyzer) analyzeUnannouncedMeal(ctx context.Context) error {
+	cfg := an.AlarmConfig.Bolus
+	threshold := cfg.RiseRate
+	if threshold <= 0 {
+		threshold = defs.DefaultMealRiseRate
+	}
+	window := minutesOr(cfg.RiseWindow, defs.DefaultMealRiseWindow)
+
+	now := time.Now()
+	start := now.Add(-window)
+
+	glucose, err := an.Store.ReadGlucose(ctx, start, now)
+	if err != nil {
+		return err
+	} else if len(glucose) < 3 || glucose[0].Time.Sub(start) > 5*time.Minute {
+		return nil
+	}
+
+	// The rise has to be sustained throughout the window, not just overall.
+	roc, err := stats.RateOfChange(glucose)
+	if err != nil {
+		return err
+	} else if roc < threshold {
+		return nil
+	}
+	half := len(glucose) / 2
+	if glucose[half].Mmol <= glucose[0].Mmol || glucose[len(glucose)-1].Mmol <= glucose[half].Mmol {
+		return nil
+	}
+
+	// Rapid insulin or carbs logged recently explain the rise, where the
+	// carbs without insulin are left to the other check.
+	obStart := now.Add(-onBoardDuration(an.Insulin, an.Carbs))
+	ins, err := an.Store.ReadInsulin(ctx, obStart, now)
+	if err != nil {
+		return err
+	}
+	cs, err := an.Store.ReadCarbs(ctx, obStart, now)
+	if err != nil {
+		return err
+	}
+
+	ob := onBoard{Insulin: an.Insulin, Carbs: an.Carbs, Doses: ins, CarbEntries: cs}
+	if hasRapidDose(ins, start.Add(-window), now) || ob.COB(now) >= 1 {
+		return nil
+	}
+
+	cooldown := cfg.Cooldown
+	if cooldown <= 0 {
+		cooldown = defs.DefaultMealCooldown
+	}
+	if an.silenced(ctx, defs.UnannouncedMealLabel, cooldown) {
+		return nil
+	}
+
+	return an.raiseWith(ctx, &defs.Alert{
+		Time:  now,
+		Label: defs.UnannouncedMealLabel,
+		Reason: fmt.Sprintf(
+			"Did you forget to bolus?\nrising at %+.2f mmol/L/min over %s, current value: %.2f, no carbs or rapid insulin logged",
+			roc, window, glucose[len(glucose)-1].Mmol,
+		),
+		Severity: an.severityOf(defs.UnannouncedMealLabel),
+	}, true, quickLogButtons(), defs.AlertsChannel)
+}
+
+// suggestedDose returns a button logging the dose covering the carbs, or
+// the quick doses if no therapy settings are configured.
+func (an *Analyzer) suggestedDose(c defs.Carb) []defs.ButtonData {
+	if an.Therapy != nil {
+		b, err := an.Therapy.Calculate(c.Time, bolus.Input{Carbs: c.Amount})
+		if err == nil && b.Dose > 0 {
+			return []defs.ButtonData{doseButton(b.Dose)}
+		}
+	}
+
+	buttons := make([]defs.ButtonData, 0)
+	for _, units := range quickDoses {
+		buttons = append(buttons, doseButton(units))
+	}
+	return buttons
+}
+
+func quickLogButtons() []defs.ButtonData {
+	buttons := make([]defs.ButtonData, 0)
+	for _, units := range quickDoses {
+		buttons = append(buttons, doseButton(units))
+	}
+	for _, grams := range quickCarbs {
+		buttons = append(buttons, carbsButton(grams, 0))
+	}
+	return buttons
+}
+
+// carbsButton logs the carbs, absorbed over the default time if no
+// absorption time (in minutes) is given.
+func carbsButton(grams, absorption int) defs.ButtonData {
+	opts := []defs.CommandInteractionOption{{Name: "amount", Value: strconv.Itoa(grams)}}
+	if absorption > 0 {
+		opts = append(opts, defs.CommandInteractionOption{Name: "absorption", Value: strconv.Itoa(absorption)})
+	}
+	return defs.ButtonData{
+		Label:   fmt.Sprintf("Log %dg", grams),
+		Style:   defs.SecondaryButton,
+		Command: defs.CommandInteraction{Name: defs.AddCarbsCmd, Options: opts},
+	}
+}
+
+func doseButton(units float64) defs.ButtonData {
+	value := strconv.FormatFloat(units, 'f', -1, 64)
+	return defs.ButtonData{
+		Label: fmt.Sprintf("Log %su %s", value, defs.RapidActing),
+		Style: defs.PrimaryButton,
+		Command: defs.CommandInteraction{
+			Name: defs.AddInsulinCmd,
+			Options: []defs.CommandInteractionOption{
+				{Name: "type", Value: defs.RapidActing.String()},
+				{Name: "units", Value: value},
+			},
+		},
+	}
+}
+
+func hasRapidDose(ins []defs.Insulin, start, end time.Time) bool {
+	for _, in := range ins {
+		if in.Type == defs.RapidActing.String() && !in.Time.Before(start) && !in.Time.After(end) {
+			return true
+		}
+	}
+	return false
+}
+
+func alertedSince(alerts []defs.Alert, label string, since time.Time) bool {
+	for _, alert := range alerts {
+		if alert.Label == label && !alert.Time.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+func minutesOr(minutes, def int) time.Duration {
+	if minutes <= 0 {
+		minutes = def
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -3,13 +3,23 @@ package mocks
 import (
 	"fmt"
 	"iv2/gourgeist/defs"
+	"sync"
 )
 
+// Messager records the messages sent to each channel. Sending is safe for
+// concurrent use, as the analyzer checks run concurrently.
 type Messager struct {
 	Channels map[string][]defs.MessageData
+	// DirectMessageErr fails the direct messages when set, as on displays
+	// that cannot send them.
+	DirectMessageErr error
+
+	mu sync.Mutex
 }
 
 func (m *Messager) SendMessage(msgData defs.MessageData, chName string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, ok := m.Channels[chName]; !ok {
 		m.Channels[chName] = make([]defs.MessageData, 0)
 	}
@@ -17,7 +27,16 @@ func (m *Messager) SendMessage(msgData defs.MessageData, chName string) (uint64,
 	return 0, nil
 }
 
+func (m *Messager) SendDirectMessage(msgData defs.MessageData, userID uint64) (uint64, error) {
+	if m.DirectMessageErr != nil {
+		return 0, m.DirectMessageErr
+	}
+	return m.SendMessage(msgData, fmt.Sprintf("dm-%d", userID))
+}
+
 func (m *Messager) GetMainMessage() (*defs.MessageData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if msgs, ok := m.Channels["main"]; !ok || len(msgs) == 0 {
 		return nil, fmt.Errorf("no message found")
 	} else {
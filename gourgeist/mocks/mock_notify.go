@@ -0,0 +1,21 @@
+package mocks
+
+import (
+	"context"
+	"iv2/gourgeist/pkg/notify"
+	"sync"
+)
+
+// Notifier records the events it is sent.
+type Notifier struct {
+	Events []notify.Event
+
+	mu sync.Mutex
+}
+
+func (n *Notifier) Notify(_ context.Context, e notify.Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.Events = append(n.Events, e)
+	return nil
+}
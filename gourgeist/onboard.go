@@ -0,0 +1,41 @@
+package gourgeist
+
+import (
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/carbs"
+	"iv2/gourgeist/pkg/insulin"
+	"time"
+)
+
+// onBoard computes the insulin and carbs on board from the recorded data,
+// where the glucose readings refine the carb absorption.
+type onBoard struct {
+	Insulin     *insulin.Model
+	Carbs       *carbs.Model
+	Doses       []defs.Insulin
+	CarbEntries []defs.Carb
+	Glucose     []defs.TransformedReading
+}
+
+// onBoardDuration is how far back the data needs to be read for the
+// amounts on board to be complete.
+func onBoardDuration(im *insulin.Model, cm *carbs.Model) time.Duration {
+	d := im.Duration(defs.RapidActing.String())
+	if cd := cm.MaxDuration(); cd > d {
+		d = cd
+	}
+	return d
+}
+
+// IOB returns the rapid acting insulin on board at t.
+func (ob onBoard) IOB(t time.Time) float64 {
+	return ob.Insulin.IOB(ob.Doses, defs.RapidActing.String(), t)
+}
+
+// COB returns the carbs on board at t.
+func (ob onBoard) COB(t time.Time) float64 {
+	return ob.Carbs.COB(ob.CarbEntries, t, &carbs.Observed{
+		Glucose: ob.Glucose,
+		IOB:     ob.IOB,
+	})
+}
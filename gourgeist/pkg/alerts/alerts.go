@@ -0,0 +1,122 @@
+package alerts
+
+import (
+	"iv2/gourgeist/defs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Periods of the day the alerts are counted by, starting at the given hour.
+const (
+	Night = iota
+	Morning
+	Afternoon
+	Evening
+)
+
+var periodNames = [...]string{"night", "morning", "afternoon", "evening"}
+
+// PeriodName returns the name of the period of the day.
+func PeriodName(p int) string {
+	return periodNames[p]
+}
+
+// Period returns the period of the day of t, every six hours from midnight.
+func Period(t time.Time) int {
+	return t.Hour() / 6
+}
+
+// Filter selects alerts, zero fields match any alert.
+type Filter struct {
+	Label    string // Case-insensitive, matching any part of the label.
+	Severity *defs.Severity
+	State    string // Either defs.AlertsOpen or defs.AlertsResolved.
+}
+
+// Match reports whether the alert is selected by the filter.
+func (f Filter) Match(a defs.Alert) bool {
+	if f.Label != "" && !strings.Contains(strings.ToLower(a.Label), strings.ToLower(f.Label)) {
+		return false
+	}
+	if f.Severity != nil && a.Severity != *f.Severity {
+		return false
+	}
+	switch f.State {
+	case defs.AlertsOpen:
+		return a.Open()
+	case defs.AlertsResolved:
+		return a.Resolved()
+	}
+	return true
+}
+
+// Select returns the alerts matching the filter, keeping their order.
+func Select(as []defs.Alert, f Filter) []defs.Alert {
+	selected := make([]defs.Alert, 0)
+	for _, a := range as {
+		if f.Match(a) {
+			selected = append(selected, a)
+		}
+	}
+	return selected
+}
+
+// LabelCount is the number of alerts raised with a label.
+type LabelCount struct {
+	Label string
+	Count int
+}
+
+// Summary tells how often the alerts fire and how long they take to be dealt
+// with, to judge whether their thresholds are tuned sensibly.
+type Summary struct {
+	Count    int
+	ByLabel  []LabelCount // Most frequent first.
+	ByPeriod [4]int       // Indexed by the period of the day, in loc.
+
+	Resolved       int
+	MeanResolution time.Duration // Of the tracked alerts that resolved.
+	Acked          int
+	MeanAck        time.Duration
+}
+
+// Summarize counts the alerts by label and period of the day, and averages
+// the time it took for them to be resolved and acknowledged.
+func Summarize(as []defs.Alert, loc *time.Location) Summary {
+	var s Summary
+	var resolution, ack time.Duration
+	counts := make(map[string]int)
+	for _, a := range as {
+		s.Count++
+		counts[a.Label]++
+		s.ByPeriod[Period(a.Time.In(loc))]++
+
+		if a.Resolved() && !a.ResolvedAt.Before(a.Time) {
+			resolution += a.ResolvedAt.Sub(a.Time)
+			s.Resolved++
+		}
+		if a.Acked() && !a.AckedAt.Before(a.Time) {
+			ack += a.AckedAt.Sub(a.Time)
+			s.Acked++
+		}
+	}
+
+	for label, count := range counts {
+		s.ByLabel = append(s.ByLabel, LabelCount{Label: label, Count: count})
+	}
+	sort.Slice(s.ByLabel, func(i, j int) bool {
+		if s.ByLabel[i].Count != s.ByLabel[j].Count {
+			return s.ByLabel[i].Count > s.ByLabel[j].Count
+		}
+		return s.ByLabel[i].Label < s.ByLabel[j].Label
+	})
+
+	if s.Resolved > 0 {
+		s.MeanResolution = resolution / time.Duration(s.Resolved)
+	}
+	if s.Acked > 0 {
+		s.MeanAck = ack / time.Duration(s.Acked)
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package alerts
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AlertsTestSuite struct {
+	suite.Suite
+	start time.Time
+}
+
+func TestAlertsTestSuite(t *testing.T) {
+	suite.Run(t, new(AlertsTestSuite))
+}
+
+func (suite *AlertsTestSuite) SetupTest() {
+	suite.start = time.Date(2022, time.May, 12, 0, 0, 0, 0, time.UTC)
+}
+
+func (suite *AlertsTestSuite) alert(hour int, label string, sev defs.Severity) defs.Alert {
+	return defs.Alert{Time: suite.start.Add(time.Duration(hour) * time.Hour), Label: label, Severity: sev}
+}
+
+func (suite *AlertsTestSuite) TestSelect() {
+	resolved := suite.alert(1, defs.HighGlucoseLabel, defs.Warning)
+	resolved.Transition(resolved.Time, defs.AlertTriggered, 14, "")
+	resolved.Transition(resolved.Time.Add(time.Hour), defs.AlertResolved, 9, "")
+	open := suite.alert(2, defs.LowGlucoseLabel, defs.Urgent)
+	open.Transition(open.Time, defs.AlertTriggered, 3.5, "")
+	as := []defs.Alert{resolved, open, suite.alert(3, defs.MissedBolusLabel, defs.Warning)}
+
+	assert.Len(suite.T(), Select(as, Filter{}), 3)
+	assert.Equal(suite.T(), []defs.Alert{resolved, open}, Select(as, Filter{Label: "glucose"}),
+		"labels match in part, regardless of case")
+
+	urgent := defs.Urgent
+	assert.Equal(suite.T(), []defs.Alert{open}, Select(as, Filter{Severity: &urgent}))
+
+	assert.Equal(suite.T(), []defs.Alert{open}, Select(as, Filter{State: defs.AlertsOpen}),
+		"untracked alerts are never open")
+	assert.Equal(suite.T(), []defs.Alert{resolved}, Select(as, Filter{State: defs.AlertsResolved}),
+		"untracked alerts are never resolved")
+	assert.Empty(suite.T(), Select(as, Filter{Label: "sensor"}))
+}
+
+func (suite *AlertsTestSuite) TestSummarize() {
+	high := suite.alert(2, defs.HighGlucoseLabel, defs.Warning)
+	high.Transition(high.Time.Add(90*time.Minute), defs.AlertResolved, 9, "")
+	low := suite.alert(14, defs.LowGlucoseLabel, defs.Urgent)
+	low.Transition(low.Time.Add(30*time.Minute), defs.AlertResolved, 5, "")
+	low.AckedAt = low.Time.Add(10 * time.Minute)
+	as := []defs.Alert{
+		high,
+		suite.alert(3, defs.HighGlucoseLabel, defs.Warning),
+		suite.alert(8, defs.MissedBolusLabel, defs.Warning),
+		low,
+		suite.alert(20, defs.LowGlucoseLabel, defs.Urgent),
+		suite.alert(23, defs.HighGlucoseLabel, defs.Warning),
+	}
+
+	s := Summarize(as, time.UTC)
+	assert.Equal(suite.T(), 6, s.Count)
+	assert.Equal(suite.T(), []LabelCount{
+		{Label: defs.HighGlucoseLabel, Count: 3},
+		{Label: defs.LowGlucoseLabel, Count: 2},
+		{Label: defs.MissedBolusLabel, Count: 1},
+	}, s.ByLabel)
+	assert.Equal(suite.T(), [4]int{2, 1, 1, 2}, s.ByPeriod)
+	assert.Equal(suite.T(), 2, s.Resolved)
+	assert.Equal(suite.T(), time.Hour, s.MeanResolution)
+	assert.Equal(suite.T(), 1, s.Acked)
+	assert.Equal(suite.T(), 10*time.Minute, s.MeanAck)
+
+	loc := time.FixedZone("UTC+6", 6*60*60)
+	assert.Equal(suite.T(), [4]int{2, 2, 1, 1}, Summarize(as, loc).ByPeriod,
+		"periods are in the location")
+
+	empty := Summarize(nil, time.UTC)
+	assert.Zero(suite.T(), empty.Count)
+	assert.Zero(suite.T(), empty.MeanResolution)
+}
@@ -0,0 +1,123 @@
+package bolus
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/schedule"
+	"math"
+	"strconv"
+	"time"
+)
+
+// maxDecimals is the most decimals a dose is formatted with.
+const maxDecimals = 3
+
+// DefaultProfile is the name of the settings used when no other profile is
+// scheduled.
+const DefaultProfile = "default"
+
+// Profiles resolves the therapy settings active at a given time.
+type Profiles struct {
+	base      defs.TherapySettings
+	profiles  map[string]defs.TherapySettings
+	schedule  *schedule.Weekly
+	increment float64
+}
+
+func New(cfg defs.TherapyConfig, loc *time.Location) (*Profiles, error) {
+	sched, err := schedule.New(cfg.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse therapy schedule: %w", err)
+	}
+
+	for _, name := range sched.Profiles() {
+		if _, ok := cfg.Profiles[name]; !ok {
+			return nil, fmt.Errorf("unknown therapy profile: %s", name)
+		}
+	}
+
+	increment := cfg.Increment
+	if increment <= 0 {
+		increment = defs.DefaultIncrement
+	}
+
+	return &Profiles{
+		base:      cfg.TherapySettings,
+		profiles:  cfg.Profiles,
+		schedule:  sched,
+		increment: increment,
+	}, nil
+}
+
+// At returns the name of the active profile, and its settings.
+func (p *Profiles) At(t time.Time) (string, defs.TherapySettings) {
+	name := p.schedule.Profile(t)
+	if s, ok := p.profiles[name]; ok {
+		return name, s
+	}
+	return DefaultProfile, p.base
+}
+
+// Input is what a dose is calculated from. A zero glucose means there is no
+// recent reading, and no correction is made. The target is used when the
+// profile does not have one.
+type Input struct {
+	Carbs   float64
+	Glucose float64
+	IOB     float64
+	Target  float64
+}
+
+// Breakdown is a suggested dose, along with how it was calculated.
+type Breakdown struct {
+	Profile  string
+	Settings defs.TherapySettings
+	Input
+
+	CarbDose     float64
+	Correction   float64
+	IOBDeduction float64
+	Dose         float64 // Rounded down to the increment.
+	Increment    float64
+}
+
+// Corrected reports whether the glucose was corrected, which needs both a
+// recent reading and a target.
+func (b Breakdown) Corrected() bool {
+	return b.Glucose > 0 && b.Settings.Target > 0
+}
+
+// Units formats the dose with as many decimals as the increment has.
+func (b Breakdown) Units() string {
+	decimals := 0
+	for inc := b.Increment; decimals < maxDecimals && math.Abs(inc-math.Round(inc)) > 1e-9; inc *= 10 {
+		decimals++
+	}
+	return strconv.FormatFloat(b.Dose, 'f', decimals, 64)
+}
+
+// Calculate suggests a dose covering the carbs and correcting the glucose
+// to the target, less the insulin already on board.
+func (p *Profiles) Calculate(t time.Time, in Input) (Breakdown, error) {
+	name, s := p.At(t)
+	if s.ICR <= 0 || s.ISF <= 0 {
+		return Breakdown{}, fmt.Errorf("profile %s is missing an icr or isf", name)
+	}
+
+	if s.Target <= 0 {
+		s.Target = in.Target
+	}
+
+	b := Breakdown{Profile: name, Settings: s, Input: in, Increment: p.increment}
+	b.CarbDose = in.Carbs / s.ICR
+	if b.Corrected() {
+		b.Correction = (in.Glucose - s.Target) / s.ISF
+	}
+
+	// Insulin on board can only offset the dose, not add to it.
+	b.IOBDeduction = math.Min(math.Max(in.IOB, 0), math.Max(b.CarbDose+b.Correction, 0))
+
+	total := b.CarbDose + b.Correction - b.IOBDeduction
+	b.Dose = math.Max(0, math.Floor(total/p.increment+1e-9)*p.increment)
+	return b, nil
+}
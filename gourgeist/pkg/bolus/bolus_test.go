@@ -0,0 +1,112 @@
+package bolus
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type BolusTestSuite struct {
+	suite.Suite
+	p   *Profiles
+	day time.Time
+}
+
+func TestBolusTestSuite(t *testing.T) {
+	suite.Run(t, new(BolusTestSuite))
+}
+
+func (suite *BolusTestSuite) SetupTest() {
+	p, err := New(defs.TherapyConfig{
+		TherapySettings: defs.TherapySettings{ICR: 10, ISF: 2},
+		Profiles: map[string]defs.TherapySettings{
+			"breakfast": {ICR: 6, ISF: 1.5, Target: 5},
+		},
+		Schedule: []defs.ScheduleEntry{
+			{Start: "06:00", End: "10:00", Profile: "breakfast"},
+		},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.p = p
+	suite.day = time.Date(2022, time.May, 12, 0, 0, 0, 0, time.UTC)
+}
+
+func (suite *BolusTestSuite) TestCalculate() {
+	b, err := suite.p.Calculate(suite.day.Add(12*time.Hour), Input{
+		Carbs:   60,
+		Glucose: 10,
+		IOB:     1.2,
+		Target:  6,
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), DefaultProfile, b.Profile)
+	assert.Equal(suite.T(), 6.0, b.Settings.Target, "falls back to the glucose target")
+	assert.InDelta(suite.T(), 6, b.CarbDose, 1e-9)
+	assert.InDelta(suite.T(), 2, b.Correction, 1e-9)
+	assert.InDelta(suite.T(), 1.2, b.IOBDeduction, 1e-9)
+	assert.Equal(suite.T(), 6.5, b.Dose, "rounded down to the increment")
+
+	b, err = suite.p.Calculate(suite.day.Add(7*time.Hour), Input{Carbs: 30, Glucose: 5})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "breakfast", b.Profile)
+	assert.Equal(suite.T(), 5.0, b.Dose)
+}
+
+func (suite *BolusTestSuite) TestIOBOnlyOffsets() {
+	b, err := suite.p.Calculate(suite.day, Input{Carbs: 10, Glucose: 5, IOB: 3, Target: 6})
+	assert.NoError(suite.T(), err)
+	assert.InDelta(suite.T(), -0.5, b.Correction, 1e-9)
+	assert.InDelta(suite.T(), 0.5, b.IOBDeduction, 1e-9)
+	assert.Equal(suite.T(), 0.0, b.Dose)
+}
+
+func (suite *BolusTestSuite) TestNoGlucose() {
+	b, err := suite.p.Calculate(suite.day, Input{Carbs: 45, Target: 6})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0.0, b.Correction)
+	assert.Equal(suite.T(), 4.5, b.Dose)
+}
+
+func (suite *BolusTestSuite) TestNoTarget() {
+	b, err := suite.p.Calculate(suite.day, Input{Carbs: 45, Glucose: 12})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), b.Corrected())
+	assert.Equal(suite.T(), 0.0, b.Correction)
+}
+
+func (suite *BolusTestSuite) TestUnits() {
+	for _, tc := range []struct {
+		increment float64
+		want      string
+	}{
+		{1, "4"},
+		{0.5, "4.5"},
+		{0.1, "4.5"},
+		{0.05, "4.55"},
+	} {
+		p, err := New(defs.TherapyConfig{
+			TherapySettings: defs.TherapySettings{ICR: 10, ISF: 2},
+			Increment:       tc.increment,
+		}, time.UTC)
+		assert.NoError(suite.T(), err)
+
+		b, err := p.Calculate(suite.day, Input{Carbs: 45.5})
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), tc.want, b.Units(), "increment %v", tc.increment)
+	}
+}
+
+func (suite *BolusTestSuite) TestInvalidProfiles() {
+	_, err := New(defs.TherapyConfig{
+		Schedule: []defs.ScheduleEntry{{Start: "06:00", End: "10:00", Profile: "breakfast"}},
+	}, time.UTC)
+	assert.Error(suite.T(), err, "unknown profile")
+
+	p, err := New(defs.TherapyConfig{}, time.UTC)
+	assert.NoError(suite.T(), err)
+	_, err = p.Calculate(suite.day, Input{Carbs: 45})
+	assert.Error(suite.T(), err, "missing icr and isf")
+}
@@ -0,0 +1,141 @@
+package carbs
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"math"
+	"time"
+)
+
+// piecewise absorption rises linearly until rampUp, stays constant until
+// rampDown, then falls linearly to zero, as fractions of the absorption
+// time. This follows the piecewise linear model used by Loop.
+const (
+	rampUp   = 0.15
+	rampDown = 0.5
+	peakRate = 1 / (rampUp/2 + (rampDown - rampUp) + (1-rampDown)/2)
+)
+
+// Observed is the data used to refine the absorption from glucose
+// deviations, where IOB returns the rapid acting insulin on board.
+type Observed struct {
+	Glucose []defs.TransformedReading
+	IOB     func(time.Time) float64
+}
+
+// Model computes the carbs on board from the recorded entries.
+type Model struct {
+	model      string
+	absorption time.Duration
+	csf, isf   float64
+}
+
+func New(cfg defs.CarbConfig) (*Model, error) {
+	model := cfg.Model
+	if model == "" {
+		model = defs.DefaultAbsorptionModel
+	}
+	if model != defs.LinearAbsorption && model != defs.PiecewiseAbsorption {
+		return nil, fmt.Errorf("unknown carb absorption model: %s", model)
+	}
+
+	absorption := cfg.Absorption
+	if absorption <= 0 {
+		absorption = defs.DefaultAbsorption
+	}
+
+	return &Model{
+		model:      model,
+		absorption: time.Duration(absorption) * time.Minute,
+		csf:        cfg.CSF,
+		isf:        cfg.ISF,
+	}, nil
+}
+
+// Absorbed returns the fraction of the carb entry absorbed after elapsed.
+func (m *Model) Absorbed(c defs.Carb, elapsed time.Duration) float64 {
+	x := float64(elapsed) / float64(m.absorptionOf(c))
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	case m.model == defs.LinearAbsorption:
+		return x
+	case x < rampUp:
+		return peakRate * x * x / (2 * rampUp)
+	case x < rampDown:
+		return peakRate * (rampUp/2 + x - rampUp)
+	default:
+		return 1 - peakRate*(1-x)*(1-x)/(2*(1-rampDown))
+	}
+}
+
+// COB returns the grams of carbs on board at t. If observations are given
+// and the model is configured for it, carbs are absorbed at least as fast as
+// the rise in glucose suggests.
+func (m *Model) COB(carbs []defs.Carb, t time.Time, obs *Observed) float64 {
+	var total, absorbed float64
+	var earliest time.Time
+	for _, c := range carbs {
+		elapsed := t.Sub(c.Time)
+		if elapsed < 0 || elapsed >= m.absorptionOf(c) {
+			continue
+		}
+		total += c.Amount
+		absorbed += c.Amount * m.Absorbed(c, elapsed)
+		if earliest.IsZero() || c.Time.Before(earliest) {
+			earliest = c.Time
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	if obs != nil && m.csf > 0 && m.isf > 0 {
+		absorbed = math.Max(absorbed, m.observedAbsorption(obs, earliest, t))
+	}
+	return math.Max(0, total-absorbed)
+}
+
+// observedAbsorption estimates the grams of carbs absorbed between start and
+// end, from the rise in glucose beyond what the insulin would have dropped.
+func (m *Model) observedAbsorption(obs *Observed, start, end time.Time) float64 {
+	var grams float64
+	for i := 1; i < len(obs.Glucose); i++ {
+		prev, cur := obs.Glucose[i-1], obs.Glucose[i]
+		if prev.Time.Before(start) || cur.Time.After(end) {
+			continue
+		}
+
+		delta := cur.Mmol - prev.Mmol
+		if obs.IOB != nil {
+			delta += m.isf * (obs.IOB(prev.Time) - obs.IOB(cur.Time))
+		}
+		if delta > 0 {
+			grams += delta / m.csf
+		}
+	}
+	return grams
+}
+
+// MaxDuration returns the longest time any entry can take to absorb, so
+// that all the entries still on board can be read without knowing them.
+func (m *Model) MaxDuration() time.Duration {
+	if longest := defs.MaxAbsorption * time.Minute; longest > m.absorption {
+		return longest
+	}
+	return m.absorption
+}
+
+// absorptionOf returns the absorption of the entry, capped at MaxDuration.
+func (m *Model) absorptionOf(c defs.Carb) time.Duration {
+	if c.Absorption > 0 {
+		d := time.Duration(c.Absorption) * time.Minute
+		if max := m.MaxDuration(); d > max {
+			return max
+		}
+		return d
+	}
+	return m.absorption
+}
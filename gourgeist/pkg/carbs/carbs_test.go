@@ -0,0 +1,99 @@
+package carbs
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type CarbsTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func TestCarbsTestSuite(t *testing.T) {
+	suite.Run(t, new(CarbsTestSuite))
+}
+
+func (suite *CarbsTestSuite) SetupTest() {
+	suite.now = time.Date(2022, time.May, 12, 12, 0, 0, 0, time.UTC)
+}
+
+func (suite *CarbsTestSuite) TestAbsorbed() {
+	for _, model := range []string{defs.LinearAbsorption, defs.PiecewiseAbsorption} {
+		m, err := New(defs.CarbConfig{Model: model, Absorption: 100})
+		assert.NoError(suite.T(), err)
+
+		c := defs.Carb{Amount: 50}
+		assert.Equal(suite.T(), 0.0, m.Absorbed(c, 0), model)
+		assert.Equal(suite.T(), 1.0, m.Absorbed(c, 100*time.Minute), model)
+
+		prev := 0.0
+		for i := 1; i <= 100; i++ {
+			absorbed := m.Absorbed(c, time.Duration(i)*time.Minute)
+			assert.GreaterOrEqual(suite.T(), absorbed, prev, "%s at %d minutes", model, i)
+			prev = absorbed
+		}
+		assert.InDelta(suite.T(), 1, prev, 1e-9, "%s is continuous at the end", model)
+	}
+
+	m, err := New(defs.CarbConfig{Model: defs.PiecewiseAbsorption, Absorption: 100})
+	assert.NoError(suite.T(), err)
+	assert.Less(suite.T(), m.Absorbed(defs.Carb{}, 10*time.Minute), 0.1, "slow start")
+
+	_, err = New(defs.CarbConfig{Model: "exponential"})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *CarbsTestSuite) TestCOB() {
+	m, err := New(defs.CarbConfig{Model: defs.LinearAbsorption, Absorption: 120})
+	assert.NoError(suite.T(), err)
+
+	carbs := []defs.Carb{
+		{Time: suite.now.Add(-3 * time.Hour), Amount: 30},
+		{Time: suite.now.Add(-time.Hour), Amount: 40},
+		{Time: suite.now.Add(-time.Hour), Amount: 20, Absorption: 240},
+		{Time: suite.now.Add(time.Hour), Amount: 10},
+	}
+
+	// Half of the default entry and a quarter of the slow entry are absorbed.
+	assert.InDelta(suite.T(), 20+15, m.COB(carbs, suite.now, nil), 1e-9)
+}
+
+func (suite *CarbsTestSuite) TestMaxDuration() {
+	m, err := New(defs.CarbConfig{Model: defs.LinearAbsorption, Absorption: 120})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), defs.MaxAbsorption*time.Minute, m.MaxDuration(),
+		"entries can be recorded with a longer absorption than configured")
+
+	carbs := []defs.Carb{{Time: suite.now.Add(-5 * time.Hour), Amount: 60, Absorption: 600}}
+	assert.InDelta(suite.T(), 10, m.COB(carbs, suite.now, nil), 1e-9, "absorption is capped")
+
+	m, err = New(defs.CarbConfig{Model: defs.LinearAbsorption, Absorption: 480})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 8*time.Hour, m.MaxDuration())
+}
+
+func (suite *CarbsTestSuite) TestObservedCOB() {
+	m, err := New(defs.CarbConfig{Model: defs.LinearAbsorption, Absorption: 120, CSF: 0.2, ISF: 2})
+	assert.NoError(suite.T(), err)
+
+	carbs := []defs.Carb{{Time: suite.now.Add(-30 * time.Minute), Amount: 40}}
+	glucose := []defs.TransformedReading{
+		{Time: suite.now.Add(-30 * time.Minute), Mmol: 6},
+		{Time: suite.now.Add(-15 * time.Minute), Mmol: 7},
+		{Time: suite.now, Mmol: 8},
+	}
+
+	// A quarter is absorbed by the model alone.
+	assert.InDelta(suite.T(), 30, m.COB(carbs, suite.now, &Observed{Glucose: glucose}), 1e-9,
+		"model absorption is used when no faster than the rise")
+
+	// A rise of 2 mmol/L with 1u of insulin acting (2 mmol/L) means 4 mmol/L
+	// of carbs were absorbed, or 20g.
+	iob := func(t time.Time) float64 { return float64(suite.now.Sub(t)) / float64(30*time.Minute) }
+	assert.InDelta(suite.T(), 20, m.COB(carbs, suite.now, &Observed{Glucose: glucose, IOB: iob}), 1e-9)
+}
@@ -0,0 +1,170 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Check is a periodic check, such as those of the analyzer.
+type Check interface {
+	Name() string
+	Run(ctx context.Context) error
+}
+
+type funcCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (c funcCheck) Name() string                  { return c.name }
+func (c funcCheck) Run(ctx context.Context) error { return c.fn(ctx) }
+
+// Func creates a check from a function.
+func Func(name string, fn func(ctx context.Context) error) Check {
+	return funcCheck{name: name, fn: fn}
+}
+
+// Options are how often a check runs, where a zero interval runs it every
+// time, and how long it can run for.
+type Options struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Metrics describe the runs of a check.
+type Metrics struct {
+	LastRun   time.Time
+	LastError error
+	Duration  time.Duration
+	Runs      int
+	Failures  int // Consecutive failures, reset by a successful run.
+}
+
+type entry struct {
+	check   Check
+	opts    Options
+	metrics Metrics
+	running bool
+}
+
+// Registry runs the registered checks concurrently, and keeps track of how
+// they went. Once a check fails the given number of times in a row, the
+// alert is called with its metrics.
+type Registry struct {
+	logger   *zap.Logger
+	failures int
+	alert    func(name string, m Metrics)
+
+	mu      sync.Mutex
+	entries []*entry
+}
+
+func New(logger *zap.Logger, failures int, alert func(name string, m Metrics)) *Registry {
+	return &Registry{logger: logger, failures: failures, alert: alert}
+}
+
+// Register adds the check, whose name has to be unique.
+func (r *Registry) Register(c Check, opts Options) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.entries {
+		if e.check.Name() == c.Name() {
+			return fmt.Errorf("check %s is already registered", c.Name())
+		}
+	}
+	if opts.Timeout <= 0 {
+		return fmt.Errorf("check %s is missing a timeout", c.Name())
+	}
+
+	r.entries = append(r.entries, &entry{check: c, opts: opts})
+	return nil
+}
+
+// Run runs the checks that are due, and returns once they are done or have
+// timed out. Checks still running from a previous run are skipped.
+func (r *Registry) Run(ctx context.Context) {
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	r.mu.Lock()
+	for _, e := range r.entries {
+		if e.running || (!e.metrics.LastRun.IsZero() && now.Sub(e.metrics.LastRun) < e.opts.Interval) {
+			continue
+		}
+		e.running = true
+
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			r.run(ctx, e, now)
+		}(e)
+	}
+	r.mu.Unlock()
+
+	wg.Wait()
+}
+
+func (r *Registry) run(ctx context.Context, e *entry, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+
+	// The check is only considered done once it returns, even if it has
+	// timed out in the meantime.
+	done := make(chan error, 1)
+	go func() {
+		err := e.check.Run(ctx)
+		r.mu.Lock()
+		e.running = false
+		r.mu.Unlock()
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", e.opts.Timeout)
+	}
+
+	r.mu.Lock()
+	m := &e.metrics
+	m.LastRun, m.LastError, m.Duration = now, err, time.Since(now)
+	m.Runs++
+	if err == nil {
+		m.Failures = 0
+	} else {
+		m.Failures++
+	}
+	metrics := *m
+	r.mu.Unlock()
+
+	if err == nil {
+		return
+	}
+
+	r.logger.Warn("check failed",
+		zap.String("check", e.check.Name()),
+		zap.Int("failures", metrics.Failures),
+		zap.Error(err),
+	)
+	if r.alert != nil && metrics.Failures == r.failures {
+		r.alert(e.check.Name(), metrics)
+	}
+}
+
+// Metrics returns the metrics of each check by name.
+func (r *Registry) Metrics() map[string]Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make(map[string]Metrics)
+	for _, e := range r.entries {
+		metrics[e.check.Name()] = e.metrics
+	}
+	return metrics
+}
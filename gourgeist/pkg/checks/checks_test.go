@@ -0,0 +1,120 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type ChecksTestSuite struct {
+	suite.Suite
+	alerts []string
+	mu     sync.Mutex
+	reg    *Registry
+}
+
+func TestChecksTestSuite(t *testing.T) {
+	suite.Run(t, new(ChecksTestSuite))
+}
+
+func (suite *ChecksTestSuite) SetupTest() {
+	suite.alerts = nil
+	suite.reg = New(zap.NewNop(), 2, func(name string, m Metrics) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.alerts = append(suite.alerts, name)
+	})
+}
+
+func (suite *ChecksTestSuite) TestRunsConcurrently() {
+	// Each check waits for the other to start, which only works if they
+	// run at the same time.
+	var started sync.WaitGroup
+	started.Add(2)
+	wait := func(ctx context.Context) error {
+		started.Done()
+		started.Wait()
+		return nil
+	}
+
+	opts := Options{Timeout: time.Second}
+	assert.NoError(suite.T(), suite.reg.Register(Func("a", wait), opts))
+	assert.NoError(suite.T(), suite.reg.Register(Func("b", wait), opts))
+	suite.reg.Run(context.Background())
+
+	metrics := suite.reg.Metrics()
+	assert.Len(suite.T(), metrics, 2)
+	for name, m := range metrics {
+		assert.NoError(suite.T(), m.LastError, name)
+		assert.Equal(suite.T(), 1, m.Runs, name)
+	}
+}
+
+func (suite *ChecksTestSuite) TestTimeout() {
+	release := make(chan struct{})
+	var runs int32
+	assert.NoError(suite.T(), suite.reg.Register(Func("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return nil
+	}), Options{Timeout: 10 * time.Millisecond}))
+
+	suite.reg.Run(context.Background())
+	m := suite.reg.Metrics()["slow"]
+	assert.Error(suite.T(), m.LastError)
+	assert.Equal(suite.T(), 1, m.Failures)
+
+	// The check is skipped while it is still running.
+	suite.reg.Run(context.Background())
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&runs))
+	close(release)
+}
+
+func (suite *ChecksTestSuite) TestInterval() {
+	var runs int
+	assert.NoError(suite.T(), suite.reg.Register(Func("hourly", func(ctx context.Context) error {
+		runs++
+		return nil
+	}), Options{Interval: time.Hour, Timeout: time.Second}))
+
+	suite.reg.Run(context.Background())
+	suite.reg.Run(context.Background())
+	assert.Equal(suite.T(), 1, runs)
+}
+
+func (suite *ChecksTestSuite) TestFailureAlert() {
+	fail := true
+	assert.NoError(suite.T(), suite.reg.Register(Func("flaky", func(ctx context.Context) error {
+		if fail {
+			return errors.New("unable to read")
+		}
+		return nil
+	}), Options{Timeout: time.Second}))
+
+	for i := 0; i < 3; i++ {
+		suite.reg.Run(context.Background())
+	}
+	assert.Equal(suite.T(), []string{"flaky"}, suite.alerts, "alerted once per streak")
+	assert.Equal(suite.T(), 3, suite.reg.Metrics()["flaky"].Failures)
+
+	fail = false
+	suite.reg.Run(context.Background())
+	m := suite.reg.Metrics()["flaky"]
+	assert.NoError(suite.T(), m.LastError)
+	assert.Equal(suite.T(), 0, m.Failures)
+	assert.Equal(suite.T(), 4, m.Runs)
+}
+
+func (suite *ChecksTestSuite) TestRegister() {
+	noop := Func("noop", func(ctx context.Context) error { return nil })
+	assert.Error(suite.T(), suite.reg.Register(noop, Options{}), "missing timeout")
+	assert.NoError(suite.T(), suite.reg.Register(noop, Options{Timeout: time.Second}))
+	assert.Error(suite.T(), suite.reg.Register(noop, Options{Timeout: time.Second}), "duplicate name")
+}
@@ -1,7 +1,9 @@
 package discgo
 
 import (
+	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
 	"strings"
 
 	"github.com/diamondburned/arikawa/v3/api"
@@ -50,20 +52,88 @@ func marshalSendData(data defs.MessageData) api.SendMessageData {
 	}
 
 	md := api.SendMessageData{
-		Content: data.Content,
-		Embeds:  embeds,
-		Files:   files,
+		Content:    data.Content,
+		Embeds:     embeds,
+		Files:      files,
+		Components: marshalButtons(data.Buttons),
 	}
 
+	if data.MentionEveryone || len(data.Mentions) > 0 {
+		md.AllowedMentions = &api.AllowedMentions{}
+	}
 	if data.MentionEveryone {
-		md.AllowedMentions = &api.AllowedMentions{
-			Parse: []api.AllowedMentionType{api.AllowEveryoneMention},
+		md.AllowedMentions.Parse = []api.AllowedMentionType{api.AllowEveryoneMention}
+	}
+	if len(data.Mentions) > 0 {
+		mentions := make([]string, len(data.Mentions))
+		for i, id := range data.Mentions {
+			mentions[i] = discord.UserID(id).Mention()
+			md.AllowedMentions.Users = append(md.AllowedMentions.Users, discord.UserID(id))
 		}
+		md.Content = fmt.Sprintln(md.Content) + strings.Join(mentions, " ")
 	}
 
 	return md
 }
 
+// marshalButtons transforms buttons into action rows, each of which can
+// hold at most five buttons.
+func marshalButtons(buttons []defs.ButtonData) discord.ContainerComponents {
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	rows := make(discord.ContainerComponents, 0)
+	for i := 0; i < len(buttons); i += 5 {
+		end := i + 5
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+
+		row := make(discord.ActionRowComponent, 0)
+		for _, button := range buttons[i:end] {
+			style := discord.PrimaryButtonStyle()
+			switch button.Style {
+			case defs.SecondaryButton:
+				style = discord.SecondaryButtonStyle()
+			case defs.DangerButton:
+				style = discord.DangerButtonStyle()
+			}
+
+			row = append(row, &discord.ButtonComponent{
+				Style:    style,
+				Label:    button.Label,
+				CustomID: discord.ComponentID(textcmd.EncodeCommand(button.Command)),
+			})
+		}
+		rows = append(rows, &row)
+	}
+
+	return rows
+}
+
+// unmarshalCommand transforms data of type discord.CommandInteraction to
+// defs.CommandInteraction, where a subcommand is passed as the subcommand
+// option followed by its own options.
+func unmarshalCommand(data *discord.CommandInteraction) defs.CommandInteraction {
+	ci := defs.CommandInteraction{
+		Name:    data.Name,
+		Options: make([]defs.CommandInteractionOption, 0),
+	}
+	for _, opt := range data.Options {
+		if opt.Type != discord.SubcommandOptionType {
+			ci.Options = append(ci.Options, defs.CommandInteractionOption{Name: opt.Name, Value: opt.String()})
+			continue
+		}
+
+		ci.Options = append(ci.Options, defs.CommandInteractionOption{Name: defs.SubcommandOption, Value: opt.Name})
+		for _, sub := range opt.Options {
+			ci.Options = append(ci.Options, defs.CommandInteractionOption{Name: sub.Name, Value: sub.String()})
+		}
+	}
+	return ci
+}
+
 // unmarshalMessage transforms data of type discord.Message to defs.MessageData.
 func unmarshalMessage(data discord.Message) defs.MessageData {
 	embeds := make([]defs.EmbedData, 0)
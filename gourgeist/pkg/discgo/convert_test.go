@@ -6,6 +6,7 @@ import (
 
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/utils/json"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -44,6 +45,19 @@ func newMessageData() defs.MessageData {
 				Reader: nil,
 			},
 		},
+		Buttons: []defs.ButtonData{
+			{
+				Label: "button1",
+				Style: defs.DangerButton,
+				Command: defs.CommandInteraction{
+					Name: "command1",
+					Options: []defs.CommandInteractionOption{
+						{Name: "option1", Value: "value|1"},
+						{Name: "option2", Value: "value=2"},
+					},
+				},
+			},
+		},
 		MentionEveryone: true,
 	}
 }
@@ -80,6 +94,16 @@ func (suite *DiscordTypeTestSuite) TestMarshalData() {
 		output.Embeds[0].Image.URL,
 	)
 
+	// Assert buttons.
+	assert.Len(suite.T(), output.Components, 1)
+	row, ok := output.Components[0].(*discord.ActionRowComponent)
+	assert.True(suite.T(), ok, "expected an action row")
+	assert.Len(suite.T(), *row, 1)
+	button, ok := (*row)[0].(*discord.ButtonComponent)
+	assert.True(suite.T(), ok, "expected a button")
+	assert.Equal(suite.T(), input.Buttons[0].Label, button.Label)
+	assert.Equal(suite.T(), discord.DangerButtonStyle(), button.Style)
+
 	// Assert mention.
 	assert.Equal(suite.T(),
 		api.AllowEveryoneMention,
@@ -87,6 +111,14 @@ func (suite *DiscordTypeTestSuite) TestMarshalData() {
 	)
 }
 
+func (suite *DiscordTypeTestSuite) TestMarshalMentions() {
+	output := marshalSendData(defs.MessageData{Content: "unacknowledged", Mentions: []uint64{42, 43}})
+
+	assert.Equal(suite.T(), "unacknowledged\n<@42> <@43>", output.Content)
+	assert.Empty(suite.T(), output.AllowedMentions.Parse, "only the users are mentioned")
+	assert.Equal(suite.T(), []discord.UserID{42, 43}, output.AllowedMentions.Users)
+}
+
 func (suite *DiscordTypeTestSuite) TestUnmarshalData() {
 	input := newMessageData()
 	output := marshalSendData(input)
@@ -100,3 +132,29 @@ func (suite *DiscordTypeTestSuite) TestUnmarshalData() {
 	assert.EqualValues(suite.T(), input.Content, unmarshalled.Content)
 	assert.EqualValues(suite.T(), input.Embeds, unmarshalled.Embeds)
 }
+
+func (suite *DiscordTypeTestSuite) TestUnmarshalCommand() {
+	input := &discord.CommandInteraction{
+		Name: defs.SensorCmd,
+		Options: []discord.CommandInteractionOption{
+			{
+				Type: discord.SubcommandOptionType,
+				Name: defs.SensorStart,
+				Options: []discord.CommandInteractionOption{
+					{Type: discord.IntegerOptionType, Name: "offset", Value: json.Raw(`30`)},
+					{Type: discord.StringOptionType, Name: "lot", Value: json.Raw(`"1234"`)},
+				},
+			},
+		},
+	}
+
+	output := unmarshalCommand(input)
+	assert.Equal(suite.T(), defs.CommandInteraction{
+		Name: defs.SensorCmd,
+		Options: []defs.CommandInteractionOption{
+			{Name: defs.SubcommandOption, Value: defs.SensorStart},
+			{Name: "offset", Value: "30"},
+			{Name: "lot", Value: "1234"},
+		},
+	}, output)
+}
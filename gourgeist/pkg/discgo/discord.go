@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
 	"time"
 
 	"github.com/diamondburned/arikawa/v3/api"
@@ -39,6 +40,7 @@ type Display interface {
 
 type Messager interface {
 	SendMessage(data defs.MessageData, chName string) (uint64, error)
+	SendDirectMessage(data defs.MessageData, userID uint64) (uint64, error)
 	GetMainMessage() (*defs.MessageData, error)
 	NewMainMessage(data defs.MessageData) error
 	UpdateMainMessage(data defs.MessageData) error
@@ -145,25 +147,20 @@ func (d *Discord) Setup(channels []string,
 
 func (d *Discord) addCmdHandler(cmdHandler defs.CommandInteractionHandler) {
 	f := func(e *gateway.InteractionCreateEvent) {
+		ei := defs.EventInfo{
+			ID:    uint64(e.ID),
+			AppID: uint64(e.AppID),
+			Token: e.Token,
+		}
+		if sender := e.Sender(); sender != nil {
+			ei.User = sender.Username
+		}
+
 		switch data := e.Data.(type) {
 		case *discord.CommandInteraction:
-			opts := make([]defs.CommandInteractionOption, 0)
-			for _, opt := range data.Options {
-				opts = append(opts, defs.CommandInteractionOption{
-					Name:  opt.Name,
-					Value: opt.String(),
-				})
-			}
-
-			ci := defs.CommandInteraction{
-				Name:    data.Name,
-				Options: opts,
-			}
-			cmdHandler(defs.EventInfo{
-				ID:    uint64(e.ID),
-				AppID: uint64(e.AppID),
-				Token: e.Token,
-			}, ci)
+			cmdHandler(ei, unmarshalCommand(data))
+		case *discord.ButtonInteraction:
+			cmdHandler(ei, textcmd.DecodeCommand(string(data.CustomID)))
 		}
 	}
 	d.Session.AddHandler(f)
@@ -179,6 +176,20 @@ func (d *Discord) SendMessage(data defs.MessageData, chName string) (uint64, err
 	return uint64(msg.ID), nil
 }
 
+func (d *Discord) SendDirectMessage(data defs.MessageData, userID uint64) (uint64, error) {
+	ch, err := d.Session.CreatePrivateChannel(discord.UserID(userID))
+	if err != nil {
+		return 0, fmt.Errorf("unable to create private channel: %w", err)
+	}
+
+	msg, err := d.Session.SendMessageComplex(ch.ID, marshalSendData(data))
+	if err != nil {
+		return 0, err
+	}
+	d.Logger.Debug("sent direct message", zap.Uint64("user", userID))
+	return uint64(msg.ID), nil
+}
+
 func (d *Discord) GetMainMessage() (*defs.MessageData, error) {
 	discordMsg, err := d.Session.Message(d.channels[d.mainCh], discord.MessageID(d.mid))
 	if err != nil {
@@ -253,12 +264,13 @@ func (d *Discord) deleteMessages(chid discord.ChannelID, exclude discord.Message
 }
 
 func (d *Discord) RespondInteraction(id uint64, token string, resp defs.InteractionResponse) error {
-	// Currently only supports content replies.
+	// Currently only supports content and button replies.
 	sendData := marshalSendData(resp.Data)
 	msgResp := api.InteractionResponse{
 		Type: api.MessageInteractionWithSource,
 		Data: &api.InteractionResponseData{
-			Content: option.NewNullableString(sendData.Content),
+			Content:    option.NewNullableString(sendData.Content),
+			Components: &sendData.Components,
 		},
 	}
 	return d.Session.RespondInteraction(discord.InteractionID(id), token, msgResp)
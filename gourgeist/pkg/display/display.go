@@ -0,0 +1,75 @@
+// Package display holds what the chat backends other than Discord share to
+// show the messages of iv2, which are written for Discord.
+package display
+
+import (
+	"errors"
+	"html"
+	"iv2/gourgeist/defs"
+	"regexp"
+	"sync"
+)
+
+// ErrNoDirectMessages is returned by the backends that cannot send direct
+// messages, as the users to message are Discord users.
+var ErrNoDirectMessages = errors.New("direct messages are not supported")
+
+var bold = regexp.MustCompile(`\*\*(.+?)\*\*`)
+
+// Main caches the main message, for the backends that cannot read it back as
+// it was sent.
+type Main struct {
+	mu   sync.Mutex
+	data *defs.MessageData
+}
+
+// Get returns a copy of the main message, which the caller is free to edit.
+func (m *Main) Get() (*defs.MessageData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil {
+		return nil, errors.New("no main message")
+	}
+	md := *m.data
+	md.Embeds = make([]defs.EmbedData, len(m.data.Embeds))
+	for i, embed := range m.data.Embeds {
+		embed.Fields = append([]defs.EmbedField(nil), embed.Fields...)
+		md.Embeds[i] = embed
+	}
+	return &md, nil
+}
+
+// Set caches the main message, without its files as they are read once sent.
+func (m *Main) Set(data defs.MessageData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data.Files = nil
+	m.data = &data
+}
+
+// Fields returns the fields of the embed, leaving out the empty ones as they
+// only lay out the others on Discord.
+func Fields(embed defs.EmbedData) []defs.EmbedField {
+	empty := defs.EmptyEmbed()
+	fields := make([]defs.EmbedField, 0, len(embed.Fields))
+	for _, field := range embed.Fields {
+		if field.Name == empty.Name && field.Value == empty.Value {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Bold replaces the bold markdown of Discord with repl, where $1 is the bold
+// text.
+func Bold(s, repl string) string {
+	return bold.ReplaceAllString(s, repl)
+}
+
+// HTML escapes the text, converting the bold markdown of Discord.
+func HTML(s string) string {
+	return Bold(html.EscapeString(s), "<b>$1</b>")
+}
@@ -0,0 +1,48 @@
+package display
+
+import (
+	"bytes"
+	"iv2/gourgeist/defs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type DisplayTestSuite struct {
+	suite.Suite
+}
+
+func TestDisplayTestSuite(t *testing.T) {
+	suite.Run(t, new(DisplayTestSuite))
+}
+
+func (suite *DisplayTestSuite) TestMain() {
+	var m Main
+	_, err := m.Get()
+	assert.Error(suite.T(), err)
+
+	m.Set(defs.MessageData{
+		Content: "iv2",
+		Embeds:  []defs.EmbedData{{Title: "glucose", Fields: []defs.EmbedField{{Name: "Current", Value: "5.5"}}}},
+		Files:   []defs.FileData{{Name: "plot.png", Reader: bytes.NewReader(nil)}},
+	})
+	md, err := m.Get()
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), md.Files, "files are read once sent")
+
+	md.Embeds[0].Fields[0].Value = "6.1"
+	md, err = m.Get()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "5.5", md.Embeds[0].Fields[0].Value, "copies are edited freely")
+}
+
+func (suite *DisplayTestSuite) TestFields() {
+	embed := defs.EmbedData{Fields: []defs.EmbedField{{Name: "Delta", Value: "-0.4"}, defs.EmptyEmbed()}}
+	assert.Equal(suite.T(), []defs.EmbedField{{Name: "Delta", Value: "-0.4"}}, Fields(embed))
+}
+
+func (suite *DisplayTestSuite) TestMarkdown() {
+	assert.Equal(suite.T(), "took *4u* of rapid", Bold("took **4u** of rapid", "*$1*"))
+	assert.Equal(suite.T(), "<b>05/12</b> &amp; 05/13", HTML("**05/12** & 05/13"))
+}
@@ -2,7 +2,11 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/insulin"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/stats"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,49 +16,150 @@ import (
 
 type httpStore interface {
 	mg.GlucoseStore
+	mg.InsulinStore
 }
 
 type HttpServer struct {
-	Store httpStore
+	Store   httpStore
+	Insulin *insulin.Model
+	// ExcludeSuspect leaves the suspect readings out of the statistics.
+	ExcludeSuspect bool
 }
 
-func New(s httpStore) *HttpServer {
+// IOBPoint is the insulin on board at a point in time, by type of insulin.
+type IOBPoint struct {
+	Time  time.Time `json:"time"`
+	Rapid float64   `json:"rapid"`
+	Slow  float64   `json:"slow"`
+}
+
+// maxIOBPoints limits the size of the IOB series.
+const maxIOBPoints = 2000
+
+func New(s httpStore, m *insulin.Model, excludeSuspect bool) *HttpServer {
 	hs := &HttpServer{
-		Store: s,
+		Store:          s,
+		Insulin:        m,
+		ExcludeSuspect: excludeSuspect,
 	}
 	hs.serve()
 	return hs
 }
 
 func (s *HttpServer) serve() {
+	r := s.router()
+	r.Run(":4242")
+}
+
+func (s *HttpServer) router() *gin.Engine {
 	r := gin.Default()
 
 	r.GET("/glucose", func(c *gin.Context) {
-		end := c.DefaultQuery("end", "")
-		endUnix, err := strconv.Atoi(end)
+		start, end, err := timeRange(c)
 		if err != nil {
-			c.String(http.StatusBadRequest, "expected unix timestamp for end")
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		start := c.DefaultQuery("start", "")
-		startUnix, err := strconv.Atoi(start)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		glucose, err := s.Store.ReadGlucose(ctx, start, end)
 		if err != nil {
-			c.String(http.StatusBadRequest, "expected unix timestamp for start")
+			c.String(http.StatusInternalServerError, "something went wrong reading glucose: %s", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, glucose)
+	})
+
+	// The consensus metrics of the readings taken between start and end.
+	r.GET("/stats", func(c *gin.Context) {
+		start, end, err := timeRange(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if !end.After(start) {
+			c.String(http.StatusBadRequest, "expected end after start")
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
-		glucose, err := s.Store.ReadGlucose(ctx, time.Unix(int64(startUnix), 0), time.Unix(int64(endUnix), 0))
+		glucose, err := s.Store.ReadGlucose(ctx, start, end)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "something went wrong reading glucose: %w", err)
+			c.String(http.StatusInternalServerError, "something went wrong reading glucose: %s", err)
 			return
 		}
 
-		c.JSON(http.StatusOK, glucose)
+		// No readings are expected after now, as in the reports.
+		if now := time.Now(); end.After(now) {
+			end = now
+		}
+		c.JSON(http.StatusOK, stats.Consensus(glucose, start, end, s.ExcludeSuspect))
 	})
 
-	r.Run(":4242")
+	// The IOB is sampled between start and end, every step minutes.
+	r.GET("/iob", func(c *gin.Context) {
+		start, end, err := timeRange(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		step, err := strconv.Atoi(c.DefaultQuery("step", "5"))
+		if err != nil || step <= 0 {
+			c.String(http.StatusBadRequest, "expected positive number of minutes for step")
+			return
+		}
+		if end.Sub(start)/(time.Duration(step)*time.Minute) > maxIOBPoints {
+			c.String(http.StatusBadRequest, "too many points, expected at most %d", maxIOBPoints)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		rapid, slow := defs.RapidActing.String(), defs.SlowActing.String()
+		lookback := s.Insulin.Duration(rapid)
+		if d := s.Insulin.Duration(slow); d > lookback {
+			lookback = d
+		}
+
+		ins, err := s.Store.ReadInsulin(ctx, start.Add(-lookback), end)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "something went wrong reading insulin: %s", err)
+			return
+		}
+
+		points := make([]IOBPoint, 0)
+		for t := start; !t.After(end); t = t.Add(time.Duration(step) * time.Minute) {
+			points = append(points, IOBPoint{
+				Time:  t,
+				Rapid: s.Insulin.IOB(ins, rapid, t),
+				Slow:  s.Insulin.IOB(ins, slow, t),
+			})
+		}
+
+		c.JSON(http.StatusOK, points)
+	})
+
+	return r
+}
+
+// timeRange parses the start and end query parameters as unix timestamps.
+func timeRange(c *gin.Context) (time.Time, time.Time, error) {
+	endUnix, err := strconv.Atoi(c.DefaultQuery("end", ""))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected unix timestamp for end")
+	}
+
+	startUnix, err := strconv.Atoi(c.DefaultQuery("start", ""))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected unix timestamp for start")
+	}
+
+	return time.Unix(int64(startUnix), 0), time.Unix(int64(endUnix), 0), nil
 }
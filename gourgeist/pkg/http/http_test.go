@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/insulin"
+	"iv2/gourgeist/pkg/stats"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeStore struct {
+	glucose []defs.TransformedReading
+	insulin []defs.Insulin
+}
+
+func (fs *fakeStore) WriteGlucose(ctx context.Context, tr *defs.TransformedReading) (*defs.UpdateResult, error) {
+	return nil, nil
+}
+
+func (fs *fakeStore) UpdateGlucose(ctx context.Context, tr *defs.TransformedReading) (*defs.UpdateResult, error) {
+	return nil, nil
+}
+
+func (fs *fakeStore) ReadGlucose(ctx context.Context, start, end time.Time) ([]defs.TransformedReading, error) {
+	return fs.glucose, nil
+}
+
+func (fs *fakeStore) WriteInsulin(ctx context.Context, in *defs.Insulin) (*defs.UpdateResult, error) {
+	return nil, nil
+}
+
+func (fs *fakeStore) UpdateInsulin(ctx context.Context, in *defs.Insulin) (*defs.UpdateResult, error) {
+	return nil, nil
+}
+
+func (fs *fakeStore) ReadInsulin(ctx context.Context, start, end time.Time) ([]defs.Insulin, error) {
+	return fs.insulin, nil
+}
+
+type HttpTestSuite struct {
+	suite.Suite
+	hs  *HttpServer
+	now time.Time
+}
+
+func TestHttpTestSuite(t *testing.T) {
+	suite.Run(t, new(HttpTestSuite))
+}
+
+func (suite *HttpTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+
+	m, err := insulin.New(defs.InsulinConfig{})
+	assert.NoError(suite.T(), err)
+
+	suite.now = time.Date(2022, time.May, 12, 12, 0, 0, 0, time.UTC)
+	suite.hs = &HttpServer{
+		Store: &fakeStore{
+			glucose: []defs.TransformedReading{
+				{Time: suite.now.Add(-20 * time.Minute), Mmol: 2.8},
+				{Time: suite.now.Add(-15 * time.Minute), Mmol: 6},
+				{Time: suite.now.Add(-10 * time.Minute), Mmol: 7},
+				{Time: suite.now.Add(-5 * time.Minute), Mmol: 11},
+			},
+			insulin: []defs.Insulin{
+				{Time: suite.now.Add(-time.Hour), Type: defs.RapidActing.String(), Amount: 5},
+			},
+		},
+		Insulin: m,
+	}
+}
+
+func (suite *HttpTestSuite) TestIOB() {
+	w := suite.get(fmt.Sprintf(
+		"/iob?start=%d&end=%d&step=60",
+		suite.now.Add(-2*time.Hour).Unix(), suite.now.Unix(),
+	))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var points []IOBPoint
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &points))
+	assert.Len(suite.T(), points, 3)
+	assert.Equal(suite.T(), 0.0, points[0].Rapid, "before the dose")
+	assert.Equal(suite.T(), 5.0, points[1].Rapid, "at the dose")
+	assert.Greater(suite.T(), points[2].Rapid, 0.0)
+	assert.Less(suite.T(), points[2].Rapid, 5.0)
+}
+
+func (suite *HttpTestSuite) TestStats() {
+	w := suite.get(fmt.Sprintf(
+		"/stats?start=%d&end=%d",
+		suite.now.Add(-40*time.Minute).Unix(), suite.now.Unix(),
+	))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var cm stats.ConsensusMetrics
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &cm))
+	assert.Equal(suite.T(), 4, cm.Readings)
+	assert.Equal(suite.T(), 0.5, cm.Active)
+	assert.Equal(suite.T(), 0.25, cm.VeryLow)
+	assert.Equal(suite.T(), 0.25, cm.High)
+	assert.InDelta(suite.T(), 6.7, cm.Mean, 1e-9)
+	assert.Greater(suite.T(), cm.GMI, 0.0)
+
+	w = suite.get(fmt.Sprintf("/stats?start=%d&end=%d", suite.now.Unix(), suite.now.Unix()))
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code, "empty period")
+}
+
+func (suite *HttpTestSuite) TestStatsUntilNow() {
+	store := suite.hs.Store
+	defer func() { suite.hs.Store = store }()
+
+	now := time.Now()
+	fs := &fakeStore{}
+	for i := 4; i > 0; i-- {
+		t := now.Add(-time.Duration(i) * 5 * time.Minute)
+		fs.glucose = append(fs.glucose, defs.TransformedReading{Time: t, Mmol: 6})
+	}
+	suite.hs.Store = fs
+
+	w := suite.get(fmt.Sprintf(
+		"/stats?start=%d&end=%d",
+		now.Add(-20*time.Minute).Unix(), now.Add(24*time.Hour).Unix(),
+	))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var cm stats.ConsensusMetrics
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &cm))
+	assert.Equal(suite.T(), 1.0, cm.Active, "no readings are expected after now")
+}
+
+func (suite *HttpTestSuite) TestBadRequests() {
+	w := suite.get("/iob?start=abc&end=0")
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	w = suite.get(fmt.Sprintf("/iob?start=0&end=%d&step=1", suite.now.Unix()))
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code, "too many points")
+}
+
+func (suite *HttpTestSuite) get(url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	suite.hs.router().ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,182 @@
+package insulin
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"math"
+	"time"
+)
+
+// Curve describes the action of a single unit of insulin over time.
+type Curve interface {
+	// Remaining returns the fraction of the dose still on board after t.
+	Remaining(t time.Duration) float64
+	// Activity returns the fraction of the dose acting per minute after t.
+	Activity(t time.Duration) float64
+	// DIA returns the duration of insulin action.
+	DIA() time.Duration
+}
+
+func NewCurve(cfg defs.ActionCurve) (Curve, error) {
+	dia, peak := float64(cfg.DIA), float64(cfg.Peak)
+	if dia <= 0 || peak <= 0 || peak >= dia {
+		return nil, fmt.Errorf("invalid dia %d and peak %d", cfg.DIA, cfg.Peak)
+	}
+
+	switch cfg.Model {
+	case defs.ExponentialCurve:
+		// The exponential curve is undefined for peaks past half the DIA.
+		if 2*peak >= dia {
+			return nil, fmt.Errorf("exponential curve peak %d must be before half the dia", cfg.Peak)
+		}
+		return newExponential(dia, peak), nil
+	case defs.BilinearCurve:
+		return &bilinear{dia: dia, peak: peak}, nil
+	default:
+		return nil, fmt.Errorf("unknown insulin curve: %s", cfg.Model)
+	}
+}
+
+// exponential is the curve used by Loop and oref0, see
+// https://github.com/LoopKit/Loop/issues/388#issuecomment-317938473.
+type exponential struct {
+	dia, tau, a, s float64 // In minutes.
+}
+
+func newExponential(dia, peak float64) *exponential {
+	tau := peak * (1 - peak/dia) / (1 - 2*peak/dia)
+	a := 2 * tau / dia
+	s := 1 / (1 - a + (1+a)*math.Exp(-dia/tau))
+	return &exponential{dia: dia, tau: tau, a: a, s: s}
+}
+
+func (e *exponential) Remaining(t time.Duration) float64 {
+	m := t.Minutes()
+	switch {
+	case m <= 0:
+		return 1
+	case m >= e.dia:
+		return 0
+	}
+	return 1 - e.s*(1-e.a)*
+		((m*m/(e.tau*e.dia*(1-e.a))-m/e.tau-1)*math.Exp(-m/e.tau)+1)
+}
+
+func (e *exponential) Activity(t time.Duration) float64 {
+	m := t.Minutes()
+	if m <= 0 || m >= e.dia {
+		return 0
+	}
+	return e.s / (e.tau * e.tau) * m * (1 - m/e.dia) * math.Exp(-m/e.tau)
+}
+
+func (e *exponential) DIA() time.Duration {
+	return time.Duration(e.dia) * time.Minute
+}
+
+// bilinear is a triangular activity curve, rising linearly to the peak and
+// falling linearly to zero at the DIA.
+type bilinear struct {
+	dia, peak float64 // In minutes.
+}
+
+func (b *bilinear) Remaining(t time.Duration) float64 {
+	m := t.Minutes()
+	switch {
+	case m <= 0:
+		return 1
+	case m >= b.dia:
+		return 0
+	case m <= b.peak:
+		return 1 - m*m/(b.dia*b.peak)
+	default:
+		return (b.dia - m) * (b.dia - m) / (b.dia * (b.dia - b.peak))
+	}
+}
+
+func (b *bilinear) Activity(t time.Duration) float64 {
+	m := t.Minutes()
+	height := 2 / b.dia
+	switch {
+	case m <= 0 || m >= b.dia:
+		return 0
+	case m <= b.peak:
+		return height * m / b.peak
+	default:
+		return height * (b.dia - m) / (b.dia - b.peak)
+	}
+}
+
+func (b *bilinear) DIA() time.Duration {
+	return time.Duration(b.dia) * time.Minute
+}
+
+// Model computes the insulin on board from the recorded doses, using the
+// action curve of each type of insulin.
+type Model struct {
+	curves map[string]Curve
+}
+
+// New creates a model from the config, falling back to the default curve
+// of any type of insulin that is not configured.
+func New(cfg defs.InsulinConfig) (*Model, error) {
+	configs := map[defs.InsulinType]defs.ActionCurve{
+		defs.RapidActing: withDefault(cfg.Rapid, defs.DefaultRapidCurve),
+		defs.SlowActing:  withDefault(cfg.Slow, defs.DefaultSlowCurve),
+	}
+
+	m := &Model{curves: make(map[string]Curve)}
+	for it, c := range configs {
+		curve, err := NewCurve(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s insulin: %w", it, err)
+		}
+		m.curves[it.String()] = curve
+	}
+	return m, nil
+}
+
+func withDefault(c, def defs.ActionCurve) defs.ActionCurve {
+	if c.Model == "" {
+		return def
+	}
+	return c
+}
+
+// Duration returns how long insulin of the given type stays on board, so
+// that enough doses can be read to compute the IOB.
+func (m *Model) Duration(typ string) time.Duration {
+	if c, ok := m.curves[typ]; ok {
+		return c.DIA()
+	}
+	return 0
+}
+
+// IOB returns the units of insulin of the given type on board at t.
+func (m *Model) IOB(ins []defs.Insulin, typ string, t time.Time) float64 {
+	return m.sum(ins, typ, t, Curve.Remaining)
+}
+
+// Activity returns the units of insulin of the given type acting per minute
+// at t.
+func (m *Model) Activity(ins []defs.Insulin, typ string, t time.Time) float64 {
+	return m.sum(ins, typ, t, Curve.Activity)
+}
+
+func (m *Model) sum(ins []defs.Insulin, typ string, t time.Time,
+	fn func(Curve, time.Duration) float64) float64 {
+	c, ok := m.curves[typ]
+	if !ok {
+		return 0
+	}
+
+	var total float64
+	for _, in := range ins {
+		// Doses recorded after t have not been administered yet.
+		if in.Type != typ || in.Time.After(t) {
+			continue
+		}
+		total += in.Amount * fn(c, t.Sub(in.Time))
+	}
+	return total
+}
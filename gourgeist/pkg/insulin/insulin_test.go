@@ -0,0 +1,80 @@
+package insulin
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type InsulinTestSuite struct {
+	suite.Suite
+}
+
+func TestInsulinTestSuite(t *testing.T) {
+	suite.Run(t, new(InsulinTestSuite))
+}
+
+func (suite *InsulinTestSuite) TestCurves() {
+	for _, model := range []string{defs.ExponentialCurve, defs.BilinearCurve} {
+		c, err := NewCurve(defs.ActionCurve{Model: model, DIA: 360, Peak: 75})
+		assert.NoError(suite.T(), err)
+
+		assert.Equal(suite.T(), 1.0, c.Remaining(0), model)
+		assert.Equal(suite.T(), 0.0, c.Remaining(6*time.Hour), model)
+		assert.Equal(suite.T(), 6*time.Hour, c.DIA(), model)
+
+		// The remaining insulin decreases, at the rate of the activity.
+		var area float64
+		prev := 1.0
+		for m := 1; m <= 360; m++ {
+			t := time.Duration(m) * time.Minute
+			remaining := c.Remaining(t)
+			assert.LessOrEqual(suite.T(), remaining, prev, "%s at %d minutes", model, m)
+			prev = remaining
+			area += c.Activity(t)
+		}
+		assert.InDelta(suite.T(), 1, area, 0.01, model)
+
+		// Activity peaks at the configured peak.
+		assert.Greater(suite.T(), c.Activity(75*time.Minute), c.Activity(60*time.Minute), model)
+		assert.Greater(suite.T(), c.Activity(75*time.Minute), c.Activity(90*time.Minute), model)
+	}
+}
+
+func (suite *InsulinTestSuite) TestInvalidCurves() {
+	_, err := NewCurve(defs.ActionCurve{Model: "linear", DIA: 360, Peak: 75})
+	assert.Error(suite.T(), err, "unknown model")
+
+	_, err = NewCurve(defs.ActionCurve{Model: defs.BilinearCurve, DIA: 60, Peak: 75})
+	assert.Error(suite.T(), err, "peak after dia")
+
+	_, err = NewCurve(defs.ActionCurve{Model: defs.ExponentialCurve, DIA: 120, Peak: 75})
+	assert.Error(suite.T(), err, "exponential peak after half the dia")
+}
+
+func (suite *InsulinTestSuite) TestIOB() {
+	m, err := New(defs.InsulinConfig{
+		Rapid: defs.ActionCurve{Model: defs.BilinearCurve, DIA: 240, Peak: 60},
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 4*time.Hour, m.Duration(defs.RapidActing.String()))
+	assert.Equal(suite.T(), 24*time.Hour, m.Duration(defs.SlowActing.String()), "default slow curve")
+
+	now := time.Date(2022, time.May, 12, 12, 0, 0, 0, time.UTC)
+	ins := []defs.Insulin{
+		{Time: now.Add(-5 * time.Hour), Type: defs.RapidActing.String(), Amount: 4},
+		{Time: now.Add(-time.Hour), Type: defs.RapidActing.String(), Amount: 4},
+		{Time: now.Add(-time.Hour), Type: defs.SlowActing.String(), Amount: 20},
+		{Time: now.Add(time.Hour), Type: defs.RapidActing.String(), Amount: 4},
+	}
+
+	// Only the dose an hour ago is active, at its peak, with a quarter of it
+	// absorbed.
+	rapid := defs.RapidActing.String()
+	assert.InDelta(suite.T(), 3, m.IOB(ins, rapid, now), 1e-9)
+	assert.InDelta(suite.T(), 4*2.0/240, m.Activity(ins, rapid, now), 1e-9)
+	assert.Greater(suite.T(), m.IOB(ins, defs.SlowActing.String(), now), 19.0)
+}
@@ -0,0 +1,107 @@
+package matrix
+
+import (
+	"html"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"iv2/gourgeist/pkg/textcmd"
+	"strings"
+)
+
+// commandPrefix starts the commands sent to the bot, as clients take the
+// ones starting with a slash for themselves.
+const commandPrefix = "!"
+
+type content struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	URL           string     `json:"url,omitempty"`
+	NewContent    *content   `json:"m.new_content,omitempty"`
+	RelatesTo     *relatesTo `json:"m.relates_to,omitempty"`
+}
+
+type relatesTo struct {
+	RelType   string     `json:"rel_type,omitempty"`
+	EventID   string     `json:"event_id,omitempty"`
+	InReplyTo *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// format transforms data of type defs.MessageData to a message with a plain
+// and an HTML body. Embed images are shown inline from their content URIs,
+// and buttons as the commands they run, since Matrix has neither.
+func format(msgType string, data defs.MessageData, uris map[string]string) content {
+	var plain, rich []string
+	add := func(p, r string) {
+		plain = append(plain, p)
+		rich = append(rich, r)
+	}
+
+	if data.Content != "" {
+		add(display.Bold(mention(data.Content, data.MentionEveryone), "$1"),
+			markup(mention(data.Content, data.MentionEveryone)))
+	}
+
+	for _, embed := range data.Embeds {
+		if embed.Title != "" {
+			add(embed.Title, "<h4>"+html.EscapeString(embed.Title)+"</h4>")
+		}
+		if embed.Description != "" {
+			add(display.Bold(embed.Description, "$1"), markup(embed.Description))
+		}
+
+		fields := make([]string, 0)
+		richFields := make([]string, 0)
+		for _, field := range display.Fields(embed) {
+			fields = append(fields, field.Name+": "+field.Value)
+			richFields = append(richFields, "<b>"+html.EscapeString(field.Name)+"</b>: "+markup(field.Value))
+		}
+		if len(fields) > 0 {
+			add(strings.Join(fields, "\n"), strings.Join(richFields, "<br>"))
+		}
+
+		if embed.Image != nil {
+			if uri, ok := uris[embed.Image.Filename]; ok {
+				name := html.EscapeString(embed.Image.Filename)
+				add("["+embed.Image.Filename+"]", `<img src="`+html.EscapeString(uri)+`" alt="`+name+`">`)
+			}
+		}
+	}
+
+	if len(data.Buttons) > 0 {
+		cmds := make([]string, 0)
+		richCmds := make([]string, 0)
+		for _, b := range data.Buttons {
+			cmd := commandPrefix + textcmd.Format(b.Command)
+			cmds = append(cmds, b.Label+": "+cmd)
+			richCmds = append(richCmds, html.EscapeString(b.Label)+": <code>"+html.EscapeString(cmd)+"</code>")
+		}
+		add(strings.Join(cmds, "\n"), strings.Join(richCmds, "<br>"))
+	}
+
+	return content{
+		MsgType:       msgType,
+		Body:          strings.Join(plain, "\n\n"),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: "<p>" + strings.Join(rich, "</p><p>") + "</p>",
+	}
+}
+
+// markup escapes the text, converting the bold markdown of Discord and the
+// line breaks.
+func markup(s string) string {
+	return strings.ReplaceAll(display.HTML(s), "\n", "<br>")
+}
+
+// mention mentions the room instead of everyone, notifying its members.
+func mention(s string, everyone bool) string {
+	if everyone {
+		return strings.ReplaceAll(s, "@everyone", "@room")
+	}
+	return s
+}
@@ -0,0 +1,470 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	clientAPI   = "/_matrix/client/v3"
+	mediaAPI    = "/_matrix/media/v3"
+	mainChannel = "iv2"
+	timeout     = 10 * time.Second
+
+	notFound = "M_NOT_FOUND"
+)
+
+// Matrix displays iv2 in rooms of a homeserver, with the dashboard pinned in
+// the main room and edited in place. Commands are sent to the rooms as text.
+type Matrix struct {
+	Logger   *zap.Logger
+	Location *time.Location
+
+	homeserver string
+	userID     string
+	token      string
+	client     *http.Client
+	config     defs.MatrixConfig
+
+	rooms   map[string]string // Room IDs by channel.
+	handler defs.CommandInteractionHandler
+	since   string // Of the next sync.
+	txn     uint64
+
+	// The API has no way to read back an edited message as it is now, so the
+	// main message is cached along with the URIs of its images.
+	mu        sync.Mutex
+	responses map[string]string // Response events by the token of their interaction.
+	main      display.Main
+	mainEvent string
+	mainURIs  map[string]string
+}
+
+// apiError is an error as returned by the homeserver.
+type apiError struct {
+	Code    string `json:"errcode"`
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func New(cfg defs.MatrixConfig, logger *zap.Logger, loc *time.Location) (*Matrix, error) {
+	u, err := url.Parse(cfg.Homeserver)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid homeserver: %s", cfg.Homeserver)
+	}
+	if cfg.AccessToken == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	m := &Matrix{
+		Logger:     logger,
+		Location:   loc,
+		homeserver: strings.TrimSuffix(cfg.Homeserver, "/"),
+		token:      cfg.AccessToken,
+		client:     &http.Client{Timeout: timeout},
+		config:     cfg,
+		responses:  make(map[string]string),
+	}
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := m.call(http.MethodGet, clientAPI+"/account/whoami", nil, &whoami); err != nil {
+		return nil, fmt.Errorf("unable to authenticate: %w", err)
+	}
+	if cfg.UserID != "" && cfg.UserID != whoami.UserID {
+		return nil, fmt.Errorf("access token is of %s, not %s", whoami.UserID, cfg.UserID)
+	}
+	m.userID = whoami.UserID
+	return m, nil
+}
+
+// Setup joins the rooms of the channels, creating the missing ones, and
+// starts taking commands from them. A dashboard pinned by an earlier run is
+// taken over, to be edited in place.
+func (m *Matrix) Setup(channels []string, cmdHandler defs.CommandInteractionHandler) error {
+	m.rooms = make(map[string]string)
+	for _, chName := range append(channels, mainChannel) {
+		id, err := m.room(chName)
+		if err != nil {
+			return fmt.Errorf("unable to setup room of %s: %w", chName, err)
+		}
+		m.rooms[chName] = id
+	}
+
+	if event, err := m.pinned(); err != nil {
+		m.Logger.Debug("unable to get pinned dashboard", zap.Error(err))
+	} else {
+		m.mainEvent = event
+	}
+
+	// Commands sent while away are not run, as they may be long outdated.
+	if err := m.sync(m.client, 0); err != nil {
+		return fmt.Errorf("unable to sync: %w", err)
+	}
+
+	m.handler = cmdHandler
+	go m.run()
+
+	m.Logger.Debug("matrix setup complete")
+	return nil
+}
+
+func (m *Matrix) SendMessage(data defs.MessageData, chName string) (uint64, error) {
+	room, ok := m.rooms[chName]
+	if !ok {
+		return 0, fmt.Errorf("unknown channel: %s", chName)
+	}
+
+	uris, err := m.uploadImages(data)
+	if err != nil {
+		return 0, err
+	}
+	event, err := m.send(room, format("m.text", data, uris))
+	if err != nil {
+		return 0, fmt.Errorf("unable to send message: %w", err)
+	}
+
+	for _, f := range data.Files {
+		if _, ok := uris[f.Name]; ok {
+			continue
+		}
+		uri, err := m.upload(f)
+		if err != nil {
+			return 0, fmt.Errorf("unable to upload %s: %w", f.Name, err)
+		}
+		if _, err := m.send(room, content{MsgType: "m.file", Body: f.Name, URL: uri}); err != nil {
+			return 0, fmt.Errorf("unable to send %s: %w", f.Name, err)
+		}
+	}
+
+	m.Logger.Debug("sent message", zap.String("channel name", chName))
+	return messageID(event), nil
+}
+
+func (m *Matrix) SendDirectMessage(_ defs.MessageData, _ uint64) (uint64, error) {
+	return 0, display.ErrNoDirectMessages
+}
+
+func (m *Matrix) GetMainMessage() (*defs.MessageData, error) {
+	return m.main.Get()
+}
+
+// NewMainMessage edits the main message to the new one, images included.
+// Only the first main message is sent and pinned. Files other than the
+// images of the embeds are not shown.
+func (m *Matrix) NewMainMessage(data defs.MessageData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uris, err := m.uploadImages(data)
+	if err != nil {
+		return err
+	}
+	if err := m.showMain(data, uris); err != nil {
+		return err
+	}
+	m.mainURIs = uris
+	return nil
+}
+
+// UpdateMainMessage edits the main message, keeping its images.
+func (m *Matrix) UpdateMainMessage(data defs.MessageData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.showMain(data, m.mainURIs)
+}
+
+func (m *Matrix) showMain(data defs.MessageData, uris map[string]string) error {
+	room := m.rooms[mainChannel]
+	c := format("m.notice", data, uris)
+
+	if m.mainEvent != "" {
+		err := m.edit(room, m.mainEvent, c)
+		if err == nil {
+			m.main.Set(data)
+			return nil
+		}
+		m.Logger.Debug("unable to edit main message, sending it again", zap.Error(err))
+	}
+
+	event, err := m.send(room, c)
+	if err != nil {
+		return fmt.Errorf("unable to send main message: %w", err)
+	}
+	pinned := map[string][]string{"pinned": {event}}
+	if err := m.call(http.MethodPut, roomPath(room, "state", "m.room.pinned_events"), pinned, nil); err != nil {
+		m.Logger.Debug("unable to pin main message", zap.Error(err))
+	}
+	m.mainEvent = event
+	m.main.Set(data)
+	return nil
+}
+
+// RespondInteraction replies to the message of the command. The token of an
+// interaction refers to that message.
+func (m *Matrix) RespondInteraction(_ uint64, token string, resp defs.InteractionResponse) error {
+	room, event, ok := strings.Cut(token, "|")
+	if !ok {
+		return fmt.Errorf("invalid token: %s", token)
+	}
+
+	c := format("m.notice", resp.Data, nil)
+	c.RelatesTo = &relatesTo{InReplyTo: &inReplyTo{EventID: event}}
+	reply, err := m.send(room, c)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.responses[token] = reply
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Matrix) DeleteInteractionResponse(_ uint64, token string) error {
+	m.mu.Lock()
+	reply, ok := m.responses[token]
+	delete(m.responses, token)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no response to delete: %s", token)
+	}
+
+	room, _, _ := strings.Cut(token, "|")
+	path := roomPath(room, "redact", reply, m.nextTxn())
+	return m.call(http.MethodPut, path, map[string]string{"reason": "acknowledged"}, nil)
+}
+
+// room returns the ID of the room of the channel, joining the configured
+// one, or the one of its alias on the homeserver, created if missing.
+func (m *Matrix) room(chName string) (string, error) {
+	if room, ok := m.config.Rooms[chName]; ok {
+		return m.join(room)
+	}
+
+	_, server, _ := strings.Cut(m.userID, ":")
+	alias := "#" + aliasName(chName) + ":" + server
+	var resolved struct {
+		RoomID string `json:"room_id"`
+	}
+	err := m.call(http.MethodGet, clientAPI+"/directory/room/"+url.PathEscape(alias), nil, &resolved)
+	var ae *apiError
+	switch {
+	case err == nil:
+		return m.join(resolved.RoomID)
+	case errors.As(err, &ae) && ae.Code == notFound:
+		m.Logger.Debug("creating room", zap.String("alias", alias))
+		var created struct {
+			RoomID string `json:"room_id"`
+		}
+		body := map[string]interface{}{
+			"room_alias_name": aliasName(chName),
+			"name":            chName,
+			"preset":          "private_chat",
+			"invite":          append([]string{}, m.config.Invite...),
+		}
+		if err := m.call(http.MethodPost, clientAPI+"/createRoom", body, &created); err != nil {
+			return "", err
+		}
+		return created.RoomID, nil
+	}
+	return "", err
+}
+
+func (m *Matrix) join(room string) (string, error) {
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	err := m.call(http.MethodPost, clientAPI+"/join/"+url.PathEscape(room), struct{}{}, &joined)
+	return joined.RoomID, err
+}
+
+// pinned returns the dashboard pinned in the main room, if it was sent by
+// the user.
+func (m *Matrix) pinned() (string, error) {
+	var state struct {
+		Pinned []string `json:"pinned"`
+	}
+	room := m.rooms[mainChannel]
+	err := m.call(http.MethodGet, roomPath(room, "state", "m.room.pinned_events"), nil, &state)
+	var ae *apiError
+	if errors.As(err, &ae) && ae.Code == notFound {
+		return "", nil
+	} else if err != nil || len(state.Pinned) == 0 {
+		return "", err
+	}
+
+	last := state.Pinned[len(state.Pinned)-1]
+	var event struct {
+		Sender string `json:"sender"`
+	}
+	if err := m.call(http.MethodGet, roomPath(room, "event", last), nil, &event); err != nil {
+		return "", err
+	}
+	if event.Sender != m.userID {
+		return "", nil
+	}
+	return last, nil
+}
+
+// edit replaces the content of the event, with a fallback for the clients
+// that do not support edits.
+func (m *Matrix) edit(room, event string, c content) error {
+	replacement := c
+	c.Body = "* " + c.Body
+	c.FormattedBody = "* " + c.FormattedBody
+	c.NewContent = &replacement
+	c.RelatesTo = &relatesTo{RelType: "m.replace", EventID: event}
+	_, err := m.send(room, c)
+	return err
+}
+
+func (m *Matrix) send(room string, c content) (string, error) {
+	var sent struct {
+		EventID string `json:"event_id"`
+	}
+	path := roomPath(room, "send", "m.room.message", m.nextTxn())
+	if err := m.call(http.MethodPut, path, c, &sent); err != nil {
+		return "", err
+	}
+	return sent.EventID, nil
+}
+
+// uploadImages uploads the files shown by the embeds, returning their
+// content URIs by name.
+func (m *Matrix) uploadImages(data defs.MessageData) (map[string]string, error) {
+	uris := make(map[string]string)
+	for _, embed := range data.Embeds {
+		if embed.Image == nil {
+			continue
+		}
+		for _, f := range data.Files {
+			if f.Name != embed.Image.Filename {
+				continue
+			}
+			uri, err := m.upload(f)
+			if err != nil {
+				return nil, fmt.Errorf("unable to upload %s: %w", f.Name, err)
+			}
+			uris[f.Name] = uri
+		}
+	}
+	return uris, nil
+}
+
+func (m *Matrix) upload(f defs.FileData) (string, error) {
+	contentType := mime.TypeByExtension(filepath.Ext(f.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		m.homeserver+mediaAPI+"/upload?filename="+url.QueryEscape(f.Name), f.Reader)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var uploaded struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := m.do(req, m.client, &uploaded); err != nil {
+		return "", err
+	}
+	return uploaded.ContentURI, nil
+}
+
+// call calls the API with the body as JSON, decoding the response into v if
+// given.
+func (m *Matrix) call(method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, m.homeserver+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return m.do(req, m.client, v)
+}
+
+func (m *Matrix) do(req *http.Request, client *http.Client, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		ae := &apiError{}
+		if err := json.Unmarshal(b, ae); err != nil || ae.Code == "" {
+			return fmt.Errorf("unexpected status: %s", res.Status)
+		}
+		return ae
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (m *Matrix) nextTxn() string {
+	return "iv2-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" +
+		strconv.FormatUint(atomic.AddUint64(&m.txn, 1), 10)
+}
+
+func roomPath(room string, parts ...string) string {
+	path := clientAPI + "/rooms/" + url.PathEscape(room)
+	for _, p := range parts {
+		path += "/" + url.PathEscape(p)
+	}
+	return path
+}
+
+// aliasName is the local part of the alias of the room of the channel.
+func aliasName(chName string) string {
+	if chName == mainChannel {
+		return mainChannel
+	}
+	return mainChannel + "-" + chName
+}
+
+// messageID hashes the ID of the event, as it is not a number.
+func messageID(event string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(event))
+	return h.Sum64()
+}
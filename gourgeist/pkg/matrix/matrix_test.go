@@ -0,0 +1,404 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+const (
+	testToken = "syt_test"
+	botID     = "@iv2:example.org"
+)
+
+// sent is an event as sent to the stub homeserver.
+type sent struct {
+	id      string
+	room    string
+	kind    string // send, redact or state.
+	content map[string]interface{}
+}
+
+// homeserver is a stub homeserver, keeping what is sent to it.
+type homeserver struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	aliases map[string]string
+	created []map[string]interface{}
+	joined  []string
+	pinned  map[string][]string
+	senders map[string]string // Senders of the events.
+	sent    []sent
+	media   map[string]string
+	pending map[string][]event // Events of the next sync, by room.
+	batch   int
+}
+
+func newHomeserver() *homeserver {
+	hs := &homeserver{
+		aliases: make(map[string]string),
+		pinned:  make(map[string][]string),
+		senders: make(map[string]string),
+		media:   make(map[string]string),
+		pending: make(map[string][]event),
+	}
+	hs.Server = httptest.NewServer(http.HandlerFunc(hs.handle))
+	return hs
+}
+
+func (hs *homeserver) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(apiError{Code: "M_UNKNOWN_TOKEN", Message: "unknown token"})
+		return
+	}
+
+	var parts []string
+	for _, p := range strings.Split(r.URL.EscapedPath(), "/") {
+		p, _ = url.PathUnescape(p)
+		parts = append(parts, p)
+	}
+	path := strings.Join(parts[4:], "/") // After /_matrix/client/v3.
+	var body map[string]interface{}
+	b, _ := ioutil.ReadAll(r.Body)
+	_ = json.Unmarshal(b, &body)
+
+	// Long polls wait a little, as there is nothing new.
+	if path == "sync" && r.URL.Query().Get("timeout") != "0" {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	reply := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		reply(apiError{Code: "M_NOT_FOUND", Message: "not found"})
+	}
+
+	switch {
+	case parts[2] == "media":
+		uri := "mxc://example.org/" + strconv.Itoa(len(hs.media))
+		hs.media[uri] = string(b)
+		reply(map[string]string{"content_uri": uri})
+	case path == "account/whoami":
+		reply(map[string]string{"user_id": botID})
+	case strings.HasPrefix(path, "directory/room/"):
+		if room, ok := hs.aliases[parts[6]]; ok {
+			reply(map[string]string{"room_id": room})
+		} else {
+			notFound()
+		}
+	case path == "createRoom":
+		room := "!" + body["room_alias_name"].(string) + ":example.org"
+		hs.aliases["#"+body["room_alias_name"].(string)+":example.org"] = room
+		hs.created = append(hs.created, body)
+		reply(map[string]string{"room_id": room})
+	case strings.HasPrefix(path, "join/"):
+		room := parts[5]
+		if alias, ok := hs.aliases[room]; ok {
+			room = alias
+		}
+		hs.joined = append(hs.joined, room)
+		reply(map[string]string{"room_id": room})
+	case strings.HasPrefix(path, "rooms/"):
+		room := parts[5]
+		switch parts[6] {
+		case "state":
+			if r.Method == http.MethodPut {
+				hs.pinned[room] = nil
+				for _, id := range body["pinned"].([]interface{}) {
+					hs.pinned[room] = append(hs.pinned[room], id.(string))
+				}
+				hs.sent = append(hs.sent, sent{room: room, kind: "state", content: body})
+				reply(map[string]string{"event_id": "$state"})
+			} else if pinned, ok := hs.pinned[room]; ok {
+				reply(map[string][]string{"pinned": pinned})
+			} else {
+				notFound()
+			}
+		case "event":
+			if sender, ok := hs.senders[parts[7]]; ok {
+				reply(map[string]string{"sender": sender})
+			} else {
+				notFound()
+			}
+		case "send", "redact":
+			id := "$" + strconv.Itoa(len(hs.sent))
+			hs.senders[id] = botID
+			if parts[6] == "redact" {
+				body["redacts"] = parts[7]
+			}
+			hs.sent = append(hs.sent, sent{id: id, room: room, kind: parts[6], content: body})
+			reply(map[string]string{"event_id": id})
+		}
+	case path == "sync":
+		hs.batch++
+		rooms := make(map[string]interface{})
+		for room, events := range hs.pending {
+			rooms[room] = map[string]interface{}{"timeline": map[string]interface{}{"events": events}}
+		}
+		hs.pending = make(map[string][]event)
+		reply(map[string]interface{}{
+			"next_batch": "s" + strconv.Itoa(hs.batch),
+			"rooms":      map[string]interface{}{"join": rooms},
+		})
+	default:
+		notFound()
+	}
+}
+
+func (hs *homeserver) last() sent {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.sent[len(hs.sent)-1]
+}
+
+// message queues a message from the user for the next sync.
+func (hs *homeserver) message(room, sender, body string) {
+	e := event{Type: "m.room.message", EventID: "$cmd" + strconv.Itoa(len(hs.pending[room])), Sender: sender}
+	e.Content.MsgType = "m.text"
+	e.Content.Body = body
+	hs.mu.Lock()
+	hs.pending[room] = append(hs.pending[room], e)
+	hs.mu.Unlock()
+}
+
+type MatrixTestSuite struct {
+	suite.Suite
+	hs *homeserver
+	mx *Matrix
+
+	mu       sync.Mutex
+	commands []defs.CommandInteraction
+	events   []defs.EventInfo
+}
+
+func TestMatrixTestSuite(t *testing.T) {
+	suite.Run(t, new(MatrixTestSuite))
+}
+
+func (suite *MatrixTestSuite) SetupTest() {
+	suite.hs = newHomeserver()
+	suite.T().Cleanup(suite.hs.Close)
+	suite.commands, suite.events = nil, nil
+	suite.hs.aliases["#family:example.org"] = "!family:example.org"
+
+	mx, err := New(suite.config(), zap.NewNop(), time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.mx = mx
+}
+
+func (suite *MatrixTestSuite) config() defs.MatrixConfig {
+	return defs.MatrixConfig{
+		Homeserver:  suite.hs.URL,
+		UserID:      botID,
+		AccessToken: testToken,
+		Rooms:       map[string]string{defs.ReportsChannel: "#family:example.org"},
+		Invite:      []string{"@grandma:example.org"},
+	}
+}
+
+// setup sets up the rooms, without syncing in the background.
+func (suite *MatrixTestSuite) setup() {
+	suite.mx.rooms = make(map[string]string)
+	for _, chName := range []string{defs.AlertsChannel, defs.ReportsChannel, mainChannel} {
+		id, err := suite.mx.room(chName)
+		assert.NoError(suite.T(), err)
+		suite.mx.rooms[chName] = id
+	}
+	suite.mx.handler = func(e defs.EventInfo, ci defs.CommandInteraction) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.events = append(suite.events, e)
+		suite.commands = append(suite.commands, ci)
+	}
+	assert.NoError(suite.T(), suite.mx.sync(suite.mx.client, 0))
+}
+
+func nop(defs.EventInfo, defs.CommandInteraction) {}
+
+func (suite *MatrixTestSuite) TestNew() {
+	cfg := suite.config()
+	cfg.UserID = "@other:example.org"
+	_, err := New(cfg, nil, nil)
+	assert.Error(suite.T(), err, "token of another user")
+
+	cfg = suite.config()
+	cfg.AccessToken = "wrong"
+	_, err = New(cfg, nil, nil)
+	assert.Error(suite.T(), err)
+
+	cfg = suite.config()
+	cfg.Homeserver = "example.org"
+	_, err = New(cfg, nil, nil)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *MatrixTestSuite) TestSetup() {
+	err := suite.mx.Setup([]string{defs.AlertsChannel, defs.ReportsChannel}, nop)
+	assert.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), map[string]string{
+		defs.AlertsChannel:  "!iv2-alerts:example.org",
+		defs.ReportsChannel: "!family:example.org",
+		mainChannel:         "!iv2:example.org",
+	}, suite.mx.rooms)
+	assert.Len(suite.T(), suite.hs.created, 2)
+	assert.Equal(suite.T(), []interface{}{"@grandma:example.org"}, suite.hs.created[0]["invite"])
+	assert.Equal(suite.T(), []string{"!family:example.org"}, suite.hs.joined)
+}
+
+func (suite *MatrixTestSuite) TestSendMessage() {
+	suite.setup()
+	_, err := suite.mx.SendMessage(defs.MessageData{
+		Content:         "⚠️ Low\ncurrent value: **3.1**\n@everyone",
+		MentionEveryone: true,
+		Buttons: []defs.ButtonData{{Label: "Snooze 30m", Command: defs.CommandInteraction{
+			Name: defs.SnoozeCmd,
+			Options: []defs.CommandInteractionOption{
+				{Name: "minutes", Value: "30"}, {Name: "id", Value: "62a1"},
+			},
+		}}},
+	}, defs.AlertsChannel)
+	assert.NoError(suite.T(), err)
+
+	s := suite.hs.last()
+	assert.Equal(suite.T(), "!iv2-alerts:example.org", s.room)
+	assert.Equal(suite.T(), "m.text", s.content["msgtype"])
+	assert.Equal(suite.T(), "⚠️ Low\ncurrent value: 3.1\n@room\n\nSnooze 30m: !snooze minutes=30 id=62a1",
+		s.content["body"])
+	assert.Equal(suite.T(),
+		"<p>⚠️ Low<br>current value: <b>3.1</b><br>@room</p>"+
+			"<p>Snooze 30m: <code>!snooze minutes=30 id=62a1</code></p>",
+		s.content["formatted_body"])
+
+	_, err = suite.mx.SendMessage(defs.MessageData{Content: "low"}, "unknown")
+	assert.Error(suite.T(), err)
+}
+
+func dashboard(value, plot string) defs.MessageData {
+	return defs.MessageData{
+		Embeds: []defs.EmbedData{{
+			Title:  "2022-05-12 03:00 AM",
+			Fields: []defs.EmbedField{{Name: "Current", Value: value}, defs.EmptyEmbed()},
+			Image:  &defs.ImageData{Filename: "daily.png"},
+		}},
+		Files: []defs.FileData{{Name: "daily.png", Reader: strings.NewReader(plot)}},
+	}
+}
+
+func (suite *MatrixTestSuite) TestMainMessage() {
+	suite.setup()
+	_, err := suite.mx.GetMainMessage()
+	assert.Error(suite.T(), err, "no main message yet")
+
+	assert.NoError(suite.T(), suite.mx.NewMainMessage(dashboard("5.20", "plot1")))
+	main := suite.mx.mainEvent
+	assert.Equal(suite.T(), []string{main}, suite.hs.pinned["!iv2:example.org"])
+	assert.Equal(suite.T(), "plot1", suite.hs.media["mxc://example.org/0"])
+
+	assert.NoError(suite.T(), suite.mx.NewMainMessage(dashboard("5.40", "plot2")))
+	s := suite.hs.last()
+	assert.Equal(suite.T(), "* 2022-05-12 03:00 AM\n\nCurrent: 5.40\n\n[daily.png]", s.content["body"])
+	assert.Equal(suite.T(), map[string]interface{}{"rel_type": "m.replace", "event_id": main},
+		s.content["m.relates_to"])
+	newContent := s.content["m.new_content"].(map[string]interface{})
+	assert.Equal(suite.T(), "m.notice", newContent["msgtype"])
+	assert.Contains(suite.T(), newContent["formatted_body"], `<img src="mxc://example.org/1" alt="daily.png">`)
+
+	msg, err := suite.mx.GetMainMessage()
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), msg.Files)
+	msg.Embeds[0].Fields[0].Value = "5.60"
+	assert.NoError(suite.T(), suite.mx.UpdateMainMessage(*msg))
+
+	newContent = suite.hs.last().content["m.new_content"].(map[string]interface{})
+	assert.Contains(suite.T(), newContent["body"], "5.60")
+	assert.Contains(suite.T(), newContent["formatted_body"], "mxc://example.org/1", "image kept")
+	assert.Equal(suite.T(), main, suite.mx.mainEvent, "edited in place")
+}
+
+func (suite *MatrixTestSuite) TestTakeOver() {
+	suite.hs.pinned["!iv2:example.org"] = []string{"$old"}
+	suite.hs.senders["$old"] = botID
+	err := suite.mx.Setup(nil, nop)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "$old", suite.mx.mainEvent)
+
+	assert.NoError(suite.T(), suite.mx.NewMainMessage(dashboard("5.20", "plot")))
+	assert.Equal(suite.T(), "$old", suite.hs.last().content["m.relates_to"].(map[string]interface{})["event_id"])
+}
+
+func (suite *MatrixTestSuite) TestRespondInteraction() {
+	suite.setup()
+	token := "!iv2-alerts:example.org|$cmd0"
+	resp := defs.InteractionResponse{Type: defs.MessageInteraction, Data: defs.MessageData{Content: "received"}}
+	assert.NoError(suite.T(), suite.mx.RespondInteraction(0, token, resp))
+
+	s := suite.hs.last()
+	assert.Equal(suite.T(), "received", s.content["body"])
+	assert.Equal(suite.T(), map[string]interface{}{"m.in_reply_to": map[string]interface{}{"event_id": "$cmd0"}},
+		s.content["m.relates_to"])
+
+	assert.NoError(suite.T(), suite.mx.DeleteInteractionResponse(0, token))
+	assert.Equal(suite.T(), "redact", suite.hs.last().kind)
+	assert.Equal(suite.T(), s.id, suite.hs.last().content["redacts"])
+	assert.Error(suite.T(), suite.mx.DeleteInteractionResponse(0, token), "already deleted")
+}
+
+// handled waits for the commands dispatched in the background.
+func (suite *MatrixTestSuite) handled(n int) []defs.CommandInteraction {
+	assert.Eventually(suite.T(), func() bool {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		return len(suite.commands) == n
+	}, time.Second, 10*time.Millisecond)
+
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.commands
+}
+
+func (suite *MatrixTestSuite) TestCommands() {
+	suite.setup()
+	alerts := "!iv2-alerts:example.org"
+	suite.hs.message(alerts, "@grandma:example.org", "> <@iv2:example.org> low\n\n!carbs 30 slow")
+	suite.hs.message(alerts, "@grandma:example.org", "on my way")
+	suite.hs.message(alerts, botID, "!carbs 30")
+	suite.hs.message("!elsewhere:example.org", "@grandma:example.org", "!carbs 30")
+	assert.NoError(suite.T(), suite.mx.sync(suite.mx.client, 0))
+
+	cmds := suite.handled(1)
+	assert.Equal(suite.T(), defs.CommandInteraction{
+		Name: defs.AddCarbsCmd,
+		Options: []defs.CommandInteractionOption{
+			{Name: "amount", Value: "30"}, {Name: "absorption", Value: "240"},
+		},
+	}, cmds[0])
+	assert.Equal(suite.T(), "grandma", suite.events[0].User)
+	assert.Equal(suite.T(), alerts+"|$cmd0", suite.events[0].Token)
+
+	suite.hs.message(alerts, "@grandma:example.org", "!carbs")
+	assert.NoError(suite.T(), suite.mx.sync(suite.mx.client, 0))
+	assert.Contains(suite.T(), suite.hs.last().content["body"], "usage: !carbs amount [absorption]")
+
+	suite.hs.message(alerts, "@grandma:example.org", "!help")
+	assert.NoError(suite.T(), suite.mx.sync(suite.mx.client, 0))
+	assert.Contains(suite.T(), suite.hs.last().content["body"], "!sensor start|stop|status")
+	assert.Len(suite.T(), suite.handled(1), 1)
+}
@@ -0,0 +1,156 @@
+package matrix
+
+import (
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// syncTimeout is how long the homeserver holds on to a sync.
+	syncTimeout = 30 * time.Second
+	retryDelay  = 5 * time.Second
+
+	helpCmd = "help"
+)
+
+// syncFilter only keeps the messages of the rooms.
+const syncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},` +
+	`"room":{"timeline":{"types":["m.room.message"]},"state":{"types":[]},"ephemeral":{"types":[]}}}`
+
+type event struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+// run syncs until the process exits.
+func (m *Matrix) run() {
+	client := &http.Client{Timeout: syncTimeout + timeout}
+	for {
+		if err := m.sync(client, syncTimeout); err != nil {
+			m.Logger.Debug("unable to sync", zap.Error(err))
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// sync waits for the next messages, handling the commands among them. The
+// first sync only starts from the latest messages.
+func (m *Matrix) sync(client *http.Client, wait time.Duration) error {
+	params := url.Values{
+		"filter":  {syncFilter},
+		"timeout": {strconv.FormatInt(wait.Milliseconds(), 10)},
+	}
+	if m.since != "" {
+		params.Set("since", m.since)
+	}
+	req, err := http.NewRequest(http.MethodGet, m.homeserver+clientAPI+"/sync?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		NextBatch string `json:"next_batch"`
+		Rooms     struct {
+			Join map[string]struct {
+				Timeline struct {
+					Events []event `json:"events"`
+				} `json:"timeline"`
+			} `json:"join"`
+		} `json:"rooms"`
+	}
+	if err := m.do(req, client, &resp); err != nil {
+		return err
+	}
+
+	first := m.since == ""
+	m.since = resp.NextBatch
+	if first {
+		return nil
+	}
+
+	for room, joined := range resp.Rooms.Join {
+		if !m.known(room) {
+			continue
+		}
+		for _, e := range joined.Timeline.Events {
+			if e.Type == "m.room.message" && e.Content.MsgType == "m.text" && e.Sender != m.userID {
+				m.handleMessage(room, e)
+			}
+		}
+	}
+	return nil
+}
+
+// handleMessage parses the commands sent to the rooms, replying with the
+// usage if they are invalid.
+func (m *Matrix) handleMessage(room string, e event) {
+	text := unquote(e.Content.Body)
+	if !strings.HasPrefix(text, commandPrefix) {
+		return
+	}
+	text = strings.TrimPrefix(text, commandPrefix)
+
+	reply := func(s string) {
+		c := format("m.notice", defs.MessageData{Content: s}, nil)
+		c.RelatesTo = &relatesTo{InReplyTo: &inReplyTo{EventID: e.EventID}}
+		if _, err := m.send(room, c); err != nil {
+			m.Logger.Debug("unable to reply", zap.Error(err))
+		}
+	}
+
+	if strings.EqualFold(strings.TrimSpace(text), helpCmd) {
+		lines := strings.Split(textcmd.Help(defs.Commands), "\n")
+		for i, line := range lines {
+			lines[i] = commandPrefix + strings.TrimPrefix(line, "/")
+		}
+		reply(strings.Join(lines, "\n"))
+		return
+	}
+	ci, err := textcmd.Parse("/"+text, defs.Commands)
+	if err != nil {
+		reply(strings.ReplaceAll(err.Error(), "usage: /", "usage: "+commandPrefix))
+		return
+	}
+
+	ei := defs.EventInfo{User: localpart(e.Sender), Token: room + "|" + e.EventID}
+	go m.handler(ei, ci)
+}
+
+// known reports whether the room is the room of a channel.
+func (m *Matrix) known(room string) bool {
+	for _, id := range m.rooms {
+		if id == room {
+			return true
+		}
+	}
+	return false
+}
+
+// unquote removes the quote of the message replied to, which replies start
+// with.
+func unquote(body string) string {
+	lines := strings.Split(body, "\n")
+	for len(lines) > 0 && (strings.HasPrefix(lines[0], "> ") || lines[0] == "") {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// localpart returns the name of the user, e.g. grandma for
+// @grandma:example.org.
+func localpart(userID string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(userID, "@"), ":")
+	return name
+}
@@ -18,11 +18,15 @@ import (
 )
 
 const (
-	GlucoseCollection = "glucose"
-	InsulinCollection = "insulin"
-	CarbsCollection   = "carbs"
-	AlertsCollection  = "alerts"
-	FilesCollection   = "fs.files"
+	GlucoseCollection     = "glucose"
+	InsulinCollection     = "insulin"
+	CarbsCollection       = "carbs"
+	AlertsCollection      = "alerts"
+	TargetsCollection     = "targets"
+	TreatmentsCollection  = "treatments"
+	SensorsCollection     = "sensors"
+	DeadLettersCollection = "deadletters"
+	FilesCollection       = "fs.files"
 )
 
 type MongoStore struct {
@@ -119,6 +123,30 @@ func (ms *MongoStore) InsertNew(ctx context.Context, collection string, doc inte
 	}, nil
 }
 
+// insert inserts the document as a new one, even if another has the same
+// time.
+func (ms *MongoStore) insert(ctx context.Context, collection string, doc interface{}) (*defs.UpdateResult, error) {
+	ms.Logger.Debug(
+		"inserting document",
+		zap.String("collection", collection),
+		zap.Any("document", doc),
+	)
+
+	res, err := ms.Database.Collection(collection).InsertOne(ctx, doc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to insert: %w", err)
+	}
+
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("unexpected inserted id: %v", res.InsertedID)
+	}
+	return &defs.UpdateResult{
+		UpsertedCount: 1,
+		UpsertedID:    defs.MyObjectID(oid.Hex()),
+	}, nil
+}
+
 func (ms *MongoStore) Update(ctx context.Context, collection string, id string, doc interface{}) (*defs.UpdateResult, error) {
 	ms.Logger.Debug(
 		"updating document",
@@ -185,6 +213,7 @@ func (ms *MongoStore) getEventsBetween(ctx context.Context, collection string, s
 
 type GlucoseStore interface {
 	WriteGlucose(ctx context.Context, tr *defs.TransformedReading) (*defs.UpdateResult, error)
+	UpdateGlucose(ctx context.Context, tr *defs.TransformedReading) (*defs.UpdateResult, error)
 	ReadGlucose(ctx context.Context, start, end time.Time) ([]defs.TransformedReading, error)
 }
 
@@ -192,6 +221,10 @@ func (ms *MongoStore) WriteGlucose(ctx context.Context, tr *defs.TransformedRead
 	return ms.InsertNew(ctx, GlucoseCollection, tr)
 }
 
+func (ms *MongoStore) UpdateGlucose(ctx context.Context, tr *defs.TransformedReading) (*defs.UpdateResult, error) {
+	return ms.Update(ctx, GlucoseCollection, string(tr.ID), tr)
+}
+
 func (ms *MongoStore) ReadGlucose(ctx context.Context, start, end time.Time) ([]defs.TransformedReading, error) {
 	var trs []defs.TransformedReading
 	if err := ms.getEventsBetween(ctx, GlucoseCollection, start, end, &trs); err != nil {
@@ -246,11 +279,91 @@ func (ms *MongoStore) ReadCarbs(ctx context.Context, start, end time.Time) ([]de
 
 type AlertStore interface {
 	WriteAlert(ctx context.Context, al *defs.Alert) (*defs.UpdateResult, error)
+	UpdateAlert(ctx context.Context, al *defs.Alert) (*defs.UpdateResult, error)
+	UpdateAlertFields(ctx context.Context, id string, u AlertUpdate) (bool, error)
 	ReadAlerts(ctx context.Context, start, end time.Time) ([]defs.Alert, error)
 }
 
+// AlertUpdate changes only some of the fields of an alert, by their stored
+// names, so that the other fields are left as they are. Unlike UpdateAlert,
+// fields can be cleared, which $set leaves untouched for omitempty fields.
+type AlertUpdate struct {
+	Set   map[string]interface{}
+	Unset []string
+	Event *defs.AlertEvent // Appended to the events.
+
+	// The update only applies if the stored alert is not resolved, not
+	// acknowledged, at the escalation level or not digested yet, for those
+	// that are set. The alerts are updated concurrently, so the state they
+	// were read in may be stale by then.
+	Unresolved bool
+	Unacked    bool
+	Escalation *int
+	Undigested bool
+}
+
+// filter returns the filter of the alert with the conditions of the update.
+func (u AlertUpdate) filter(oid primitive.ObjectID) bson.M {
+	filter := bson.M{"_id": oid}
+	if u.Unresolved {
+		filter["state"] = bson.M{"$ne": defs.AlertResolved}
+	}
+	if u.Unacked {
+		filter["ackedAt"] = bson.M{"$exists": false}
+	}
+	if u.Escalation != nil {
+		filter["escalation"] = *u.Escalation
+	}
+	if u.Undigested {
+		filter["digestedAt"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+// WriteAlert inserts the alert. Unlike readings, alerts raised at the same
+// time are all kept, as the checks raising them run concurrently.
 func (ms *MongoStore) WriteAlert(ctx context.Context, al *defs.Alert) (*defs.UpdateResult, error) {
-	return ms.InsertNew(ctx, AlertsCollection, al)
+	return ms.insert(ctx, AlertsCollection, al)
+}
+
+func (ms *MongoStore) UpdateAlert(ctx context.Context, al *defs.Alert) (*defs.UpdateResult, error) {
+	return ms.Update(ctx, AlertsCollection, string(al.ID), al)
+}
+
+// UpdateAlertFields applies the update to the alert, returning whether it
+// was found with the conditions of the update holding.
+func (ms *MongoStore) UpdateAlertFields(ctx context.Context, id string, u AlertUpdate) (bool, error) {
+	ms.Logger.Debug(
+		"updating alert fields",
+		zap.String("id", id),
+		zap.Any("update", u),
+	)
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	update := bson.M{}
+	if len(u.Set) > 0 {
+		update["$set"] = bson.M(u.Set)
+	}
+	if len(u.Unset) > 0 {
+		unset := bson.M{}
+		for _, field := range u.Unset {
+			unset[field] = ""
+		}
+		update["$unset"] = unset
+	}
+	if u.Event != nil {
+		update["$push"] = bson.M{"events": u.Event}
+	}
+
+	res, err := ms.Database.Collection(AlertsCollection).UpdateOne(ctx, u.filter(oid), update)
+	if err != nil {
+		return false, fmt.Errorf("unable to update alert: %w", err)
+	}
+	return res.MatchedCount > 0, nil
 }
 
 func (ms *MongoStore) ReadAlerts(ctx context.Context, start, end time.Time) ([]defs.Alert, error) {
@@ -261,6 +374,89 @@ func (ms *MongoStore) ReadAlerts(ctx context.Context, start, end time.Time) ([]d
 	return alerts, nil
 }
 
+type TempTargetStore interface {
+	WriteTempTarget(ctx context.Context, tt *defs.TempTarget) (*defs.UpdateResult, error)
+	UpdateTempTarget(ctx context.Context, tt *defs.TempTarget) (*defs.UpdateResult, error)
+	ReadTempTargets(ctx context.Context, start, end time.Time) ([]defs.TempTarget, error)
+}
+
+func (ms *MongoStore) WriteTempTarget(ctx context.Context, tt *defs.TempTarget) (*defs.UpdateResult, error) {
+	return ms.InsertNew(ctx, TargetsCollection, tt)
+}
+
+func (ms *MongoStore) UpdateTempTarget(ctx context.Context, tt *defs.TempTarget) (*defs.UpdateResult, error) {
+	return ms.Update(ctx, TargetsCollection, string(tt.ID), tt)
+}
+
+func (ms *MongoStore) ReadTempTargets(ctx context.Context, start, end time.Time) ([]defs.TempTarget, error) {
+	var tts []defs.TempTarget
+	if err := ms.getEventsBetween(ctx, TargetsCollection, start, end, &tts); err != nil {
+		return nil, fmt.Errorf("unable to read temporary targets: %w", err)
+	}
+	return tts, nil
+}
+
+type TreatmentStore interface {
+	WriteTreatment(ctx context.Context, t *defs.Treatment) (*defs.UpdateResult, error)
+	UpdateTreatment(ctx context.Context, t *defs.Treatment) (*defs.UpdateResult, error)
+	ReadTreatments(ctx context.Context, start, end time.Time) ([]defs.Treatment, error)
+}
+
+func (ms *MongoStore) WriteTreatment(ctx context.Context, t *defs.Treatment) (*defs.UpdateResult, error) {
+	return ms.InsertNew(ctx, TreatmentsCollection, t)
+}
+
+func (ms *MongoStore) UpdateTreatment(ctx context.Context, t *defs.Treatment) (*defs.UpdateResult, error) {
+	return ms.Update(ctx, TreatmentsCollection, string(t.ID), t)
+}
+
+func (ms *MongoStore) ReadTreatments(ctx context.Context, start, end time.Time) ([]defs.Treatment, error) {
+	var ts []defs.Treatment
+	if err := ms.getEventsBetween(ctx, TreatmentsCollection, start, end, &ts); err != nil {
+		return nil, fmt.Errorf("unable to read treatments: %w", err)
+	}
+	return ts, nil
+}
+
+type SensorStore interface {
+	WriteSensor(ctx context.Context, s *defs.SensorSession) (*defs.UpdateResult, error)
+	UpdateSensor(ctx context.Context, s *defs.SensorSession) (*defs.UpdateResult, error)
+	ReadSensors(ctx context.Context, start, end time.Time) ([]defs.SensorSession, error)
+}
+
+func (ms *MongoStore) WriteSensor(ctx context.Context, s *defs.SensorSession) (*defs.UpdateResult, error) {
+	return ms.InsertNew(ctx, SensorsCollection, s)
+}
+
+func (ms *MongoStore) UpdateSensor(ctx context.Context, s *defs.SensorSession) (*defs.UpdateResult, error) {
+	return ms.Update(ctx, SensorsCollection, string(s.ID), s)
+}
+
+func (ms *MongoStore) ReadSensors(ctx context.Context, start, end time.Time) ([]defs.SensorSession, error) {
+	var ss []defs.SensorSession
+	if err := ms.getEventsBetween(ctx, SensorsCollection, start, end, &ss); err != nil {
+		return nil, fmt.Errorf("unable to read sensor sessions: %w", err)
+	}
+	return ss, nil
+}
+
+type DeadLetterStore interface {
+	WriteDeadLetter(ctx context.Context, dl *defs.DeadLetter) (*defs.UpdateResult, error)
+	ReadDeadLetters(ctx context.Context, start, end time.Time) ([]defs.DeadLetter, error)
+}
+
+func (ms *MongoStore) WriteDeadLetter(ctx context.Context, dl *defs.DeadLetter) (*defs.UpdateResult, error) {
+	return ms.InsertNew(ctx, DeadLettersCollection, dl)
+}
+
+func (ms *MongoStore) ReadDeadLetters(ctx context.Context, start, end time.Time) ([]defs.DeadLetter, error) {
+	var dls []defs.DeadLetter
+	if err := ms.getEventsBetween(ctx, DeadLettersCollection, start, end, &dls); err != nil {
+		return nil, err
+	}
+	return dls, nil
+}
+
 type FileStore interface {
 	ReadFile(ctx context.Context, fid string) (io.Reader, error)
 	DeleteFile(ctx context.Context, fid string) error
@@ -151,6 +151,28 @@ func (suite *MongoTestSuite) TestIgnoreDupeInsertIntegration() {
 	assert.Equal(suite.T(), int64(0), res.ModifiedCount)
 }
 
+func (suite *MongoTestSuite) TestFlagGlucoseIntegration() {
+	ctx := context.Background()
+	tr := defs.TransformedReading{
+		Time:  time.Date(2022, time.May, 12, 1, 30, 0, 0, time.UTC),
+		Mmol:  2.5,
+		Trend: "SingleDown",
+	}
+
+	res, err := suite.ms.WriteGlucose(ctx, &tr)
+	assert.NoError(suite.T(), err, "unable to write glucose to test db")
+
+	tr.ID = res.UpsertedID
+	tr.Suspect = defs.CompressionSuspect
+	_, err = suite.ms.UpdateGlucose(ctx, &tr)
+	assert.NoError(suite.T(), err, "unable to flag glucose")
+
+	trs, err := suite.ms.ReadGlucose(ctx, tr.Time, tr.Time)
+	assert.NoError(suite.T(), err, "unable to read glucose from test db")
+	assert.Len(suite.T(), trs, 1)
+	assert.Equal(suite.T(), defs.CompressionSuspect, trs[0].Suspect)
+}
+
 func (suite *MongoTestSuite) TestRWInsulinIntegration() {
 	ctx := context.Background()
 	times := []time.Time{
@@ -300,3 +322,193 @@ func (suite *MongoTestSuite) TestRWAlertsIntegration() {
 		assert.EqualValues(suite.T(), alertsInsert[i].Reason, alerts[i].Reason)
 	}
 }
+
+func (suite *MongoTestSuite) TestWriteAlertSameTimeIntegration() {
+	ctx := context.Background()
+	t := time.Date(2022, time.May, 12, 2, 0, 0, 0, time.UTC)
+
+	high, err := suite.ms.WriteAlert(ctx, &defs.Alert{Time: t, Label: defs.HighGlucoseLabel})
+	assert.NoError(suite.T(), err)
+	rise, err := suite.ms.WriteAlert(ctx, &defs.Alert{Time: t, Label: defs.RapidRiseLabel})
+	assert.NoError(suite.T(), err)
+	assert.NotEqual(suite.T(), high.UpsertedID, rise.UpsertedID, "alerts raised at once are all kept")
+
+	var alert defs.Alert
+	assert.NoError(suite.T(), suite.ms.DocByID(ctx, AlertsCollection, string(rise.UpsertedID), &alert))
+	assert.Equal(suite.T(), defs.RapidRiseLabel, alert.Label)
+}
+
+func (suite *MongoTestSuite) TestUpdateAlertIntegration() {
+	ctx := context.Background()
+	alert := defs.Alert{
+		Time:     time.Date(2022, time.May, 12, 1, 30, 0, 0, time.UTC),
+		Label:    "testlabel",
+		Reason:   "testreason",
+		Severity: defs.Urgent,
+	}
+
+	res, err := suite.ms.WriteAlert(ctx, &alert)
+	assert.NoError(suite.T(), err, "unable to write alert to test db")
+
+	alert.ID = res.UpsertedID
+	alert.AckedAt = time.Date(2022, time.May, 12, 1, 35, 0, 0, time.UTC)
+	alert.AckedBy = "tester"
+	ures, err := suite.ms.UpdateAlert(ctx, &alert)
+	assert.NoError(suite.T(), err, "unable to update alert")
+	assert.Equal(suite.T(), int64(1), ures.ModifiedCount)
+
+	var updatedAlert defs.Alert
+	assert.NoError(suite.T(), suite.ms.DocByID(ctx, AlertsCollection, string(res.UpsertedID), &updatedAlert))
+	assert.True(suite.T(), updatedAlert.Acked())
+	assert.EqualValues(suite.T(), alert.AckedBy, updatedAlert.AckedBy)
+}
+
+func (suite *MongoTestSuite) TestUpdateAlertFieldsIntegration() {
+	ctx := context.Background()
+	alert := defs.Alert{
+		Time:     time.Date(2022, time.May, 12, 1, 30, 0, 0, time.UTC),
+		Label:    "testlabel",
+		Reason:   "testreason",
+		Severity: defs.Urgent,
+		AckedAt:  time.Date(2022, time.May, 12, 1, 35, 0, 0, time.UTC),
+		AckedBy:  "tester",
+	}
+
+	res, err := suite.ms.WriteAlert(ctx, &alert)
+	assert.NoError(suite.T(), err, "unable to write alert to test db")
+
+	event := defs.AlertEvent{Time: alert.Time, State: defs.AlertTriggered, Value: 12}
+	ok, err := suite.ms.UpdateAlertFields(ctx, string(res.UpsertedID), AlertUpdate{
+		Set:   map[string]interface{}{"reason": "worse"},
+		Unset: []string{"ackedAt", "ackedBy"},
+		Event: &event,
+	})
+	assert.NoError(suite.T(), err, "unable to update alert fields")
+	assert.True(suite.T(), ok)
+
+	var updatedAlert defs.Alert
+	assert.NoError(suite.T(), suite.ms.DocByID(ctx, AlertsCollection, string(res.UpsertedID), &updatedAlert))
+	assert.Equal(suite.T(), "worse", updatedAlert.Reason)
+	assert.Equal(suite.T(), "testlabel", updatedAlert.Label, "other fields are kept")
+	assert.False(suite.T(), updatedAlert.Acked(), "cleared fields are removed")
+	assert.Empty(suite.T(), updatedAlert.AckedBy)
+	assert.Len(suite.T(), updatedAlert.Events, 1)
+
+	escalation := defs.EscalateCaregivers
+	ok, err = suite.ms.UpdateAlertFields(ctx, string(res.UpsertedID), AlertUpdate{
+		Set:        map[string]interface{}{"escalation": escalation + 1},
+		Escalation: &escalation,
+	})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), ok, "the update is not applied when its conditions do not hold")
+
+	_, err = suite.ms.UpdateAlertFields(ctx, string(res.UpsertedID), AlertUpdate{
+		Set: map[string]interface{}{"state": defs.AlertResolved},
+	})
+	assert.NoError(suite.T(), err)
+	ok, err = suite.ms.UpdateAlertFields(ctx, string(res.UpsertedID), AlertUpdate{
+		Set:        map[string]interface{}{"reason": "reopened"},
+		Unresolved: true,
+		Unacked:    true,
+	})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), ok, "resolved alerts are left as they are")
+}
+
+func (suite *MongoTestSuite) TestRWTempTargetsIntegration() {
+	ctx := context.Background()
+	start := time.Date(2022, time.May, 12, 17, 0, 0, 0, time.UTC)
+	tt := defs.TempTarget{
+		Time:   start,
+		End:    start.Add(2 * time.Hour),
+		Preset: defs.ExercisePreset,
+		Low:    5.5,
+		High:   11,
+	}
+
+	res, err := suite.ms.WriteTempTarget(ctx, &tt)
+	assert.NoError(suite.T(), err, "unable to write temporary target to test db")
+
+	tt.ID = res.UpsertedID
+	tt.End = start.Add(30 * time.Minute)
+	tt.CancelledBy = "tester"
+	ures, err := suite.ms.UpdateTempTarget(ctx, &tt)
+	assert.NoError(suite.T(), err, "unable to cancel temporary target")
+	assert.Equal(suite.T(), int64(1), ures.ModifiedCount)
+
+	tts, err := suite.ms.ReadTempTargets(ctx, start.Add(-time.Hour), start.Add(time.Hour))
+	assert.NoError(suite.T(), err, "unable to read temporary targets from test db")
+	assert.Len(suite.T(), tts, 1)
+	assert.True(suite.T(), tts[0].End.Equal(tt.End))
+	assert.False(suite.T(), tts[0].Active(start.Add(time.Hour)))
+}
+
+func (suite *MongoTestSuite) TestRWTreatmentsIntegration() {
+	ctx := context.Background()
+	start := time.Date(2022, time.May, 12, 3, 0, 0, 0, time.UTC)
+	t := defs.Treatment{Time: start, Glucose: 3.5, Nadir: 3.5, Prompts: 1, LastPrompt: start}
+
+	res, err := suite.ms.WriteTreatment(ctx, &t)
+	assert.NoError(suite.T(), err, "unable to write treatment to test db")
+
+	t.ID = res.UpsertedID
+	t.Nadir = 3.1
+	t.ClosedAt = start.Add(30 * time.Minute)
+	ures, err := suite.ms.UpdateTreatment(ctx, &t)
+	assert.NoError(suite.T(), err, "unable to close treatment")
+	assert.Equal(suite.T(), int64(1), ures.ModifiedCount)
+
+	ts, err := suite.ms.ReadTreatments(ctx, start, start.Add(time.Hour))
+	assert.NoError(suite.T(), err, "unable to read treatments from test db")
+	assert.Len(suite.T(), ts, 1)
+	assert.True(suite.T(), ts[0].Closed())
+	assert.Equal(suite.T(), 3.1, ts[0].Nadir)
+}
+
+func (suite *MongoTestSuite) TestRWSensorsIntegration() {
+	ctx := context.Background()
+	start := time.Date(2022, time.May, 12, 3, 0, 0, 0, time.UTC)
+	s := defs.SensorSession{
+		Time:   start,
+		Expiry: start.Add(10 * 24 * time.Hour),
+		Source: defs.SensorCommandSource,
+		Lot:    "1234",
+	}
+
+	res, err := suite.ms.WriteSensor(ctx, &s)
+	assert.NoError(suite.T(), err, "unable to write sensor to test db")
+
+	s.ID = res.UpsertedID
+	s.EndedAt = start.Add(9 * 24 * time.Hour)
+	ures, err := suite.ms.UpdateSensor(ctx, &s)
+	assert.NoError(suite.T(), err, "unable to end sensor")
+	assert.Equal(suite.T(), int64(1), ures.ModifiedCount)
+
+	ss, err := suite.ms.ReadSensors(ctx, start, start.Add(time.Hour))
+	assert.NoError(suite.T(), err, "unable to read sensors from test db")
+	assert.Len(suite.T(), ss, 1)
+	assert.Equal(suite.T(), "1234", ss[0].Lot)
+	assert.Equal(suite.T(), s.EndedAt, ss[0].End())
+}
+
+func (suite *MongoTestSuite) TestRWDeadLettersIntegration() {
+	ctx := context.Background()
+	start := time.Date(2022, time.May, 12, 3, 0, 0, 0, time.UTC)
+	dl := defs.DeadLetter{
+		Time:     start,
+		URL:      "http://localhost:8123/api/webhook/iv2",
+		Event:    "alert",
+		Payload:  `{"type":"alert"}`,
+		Attempts: 4,
+		Error:    "unexpected status: 503",
+	}
+
+	_, err := suite.ms.WriteDeadLetter(ctx, &dl)
+	assert.NoError(suite.T(), err, "unable to write dead letter to test db")
+
+	dls, err := suite.ms.ReadDeadLetters(ctx, start, start.Add(time.Hour))
+	assert.NoError(suite.T(), err, "unable to read dead letters from test db")
+	assert.Len(suite.T(), dls, 1)
+	assert.Equal(suite.T(), dl.Payload, dls[0].Payload)
+	assert.Equal(suite.T(), 4, dls[0].Attempts)
+}
@@ -0,0 +1,321 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailTimeFormat is how times are shown in the emails.
+const emailTimeFormat = "Jan 2 15:04"
+
+// Page is the content of an HTML email.
+type Page struct {
+	Title  string
+	Lines  []string
+	Fields []Field
+}
+
+// Field is a row of the table shown under the lines of a page.
+type Field struct {
+	Name  string
+	Value string
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>{{.Title}}</h2>
+{{range .Lines}}<p>{{.}}</p>
+{{end}}{{if .Fields}}<table>
+{{range .Fields}}<tr><th align="left">{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+func (p Page) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		return "", fmt.Errorf("unable to render page: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Attachment is a file sent along with an email.
+type Attachment struct {
+	Name   string
+	Reader io.Reader
+}
+
+// Email sends the events as HTML emails over SMTP, for those who do not
+// follow along on Discord.
+type Email struct {
+	addr    string
+	host    string
+	timeout time.Duration
+	auth    smtp.Auth
+	from    *mail.Address
+	to      []*mail.Address
+	events  map[string]bool
+	loc     *time.Location
+}
+
+func NewEmail(cfg defs.EmailConfig, loc *time.Location) (*Email, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("missing smtp host")
+	}
+	if len(cfg.To) == 0 {
+		return nil, fmt.Errorf("missing recipients")
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	port := cfg.Port
+	if port <= 0 {
+		port = defs.DefaultSMTPPort
+	}
+
+	m := &Email{
+		addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		host:    cfg.Host,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+		events:  map[string]bool{AlertEvent: true},
+		loc:     loc,
+	}
+	if m.timeout <= 0 {
+		m.timeout = defs.DefaultSMTPTimeout * time.Second
+	}
+	if cfg.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	var err error
+	if m.from, err = mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("unable to parse sender: %w", err)
+	}
+	for _, to := range cfg.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse recipient: %w", err)
+		}
+		m.to = append(m.to, addr)
+	}
+
+	if len(cfg.Events) > 0 {
+		m.events = make(map[string]bool)
+	}
+	for _, e := range cfg.Events {
+		switch e {
+		case AlertEvent, ReadingEvent, TreatmentEvent:
+			m.events[e] = true
+		default:
+			return nil, fmt.Errorf("unknown event: %s", e)
+		}
+	}
+
+	return m, nil
+}
+
+// Notify emails the event, if it is one of those sent by email.
+func (m *Email) Notify(_ context.Context, e Event) error {
+	if !m.events[e.Type] {
+		return nil
+	}
+
+	var subject string
+	var page Page
+	switch d := e.Data.(type) {
+	case AlertData:
+		// Only the raise and the resolution are emailed, the rest of the
+		// lifecycle would read as new alerts. Quiet alerts wait for the digest.
+		if d.Quiet || (d.Change != AlertRaised && d.Change != AlertResolved) {
+			return nil
+		}
+		subject = "⚠️ " + d.Label
+		if d.Change == AlertResolved {
+			subject = "✅ " + d.Label + " resolved"
+		}
+		page = Page{
+			Title: subject,
+			Lines: strings.Split(d.Reason, "\n"),
+			Fields: []Field{
+				{Name: "Severity", Value: d.Severity},
+				{Name: "Raised", Value: d.Time.In(m.loc).Format(emailTimeFormat)},
+			},
+		}
+		if d.Value != 0 {
+			page.Fields = append(page.Fields, Field{Name: "Glucose", Value: fmt.Sprintf("%.2f", d.Value)})
+		}
+	case ReadingData:
+		subject = fmt.Sprintf("🩸 %.2f %s", d.Mmol, d.Trend)
+		page = Page{Title: subject, Lines: []string{d.Time.In(m.loc).Format(emailTimeFormat)}}
+	case TreatmentData:
+		subject = fmt.Sprintf("💉 %.1fu of %s insulin", d.Amount, d.Type)
+		if d.Kind == CarbsTreatment {
+			subject = fmt.Sprintf("🍞 %.0fg of carbs", d.Amount)
+		}
+		page = Page{Title: subject, Lines: []string{d.Time.In(m.loc).Format(emailTimeFormat)}}
+	default:
+		return fmt.Errorf("unknown event data: %T", e.Data)
+	}
+
+	return m.Send(subject, page)
+}
+
+// Send emails the page to the recipients, with the attachments.
+func (m *Email) Send(subject string, page Page, attachments ...Attachment) error {
+	msg, err := m.message(subject, page, attachments)
+	if err != nil {
+		return err
+	}
+
+	to := make([]string, len(m.to))
+	for i, addr := range m.to {
+		to[i] = addr.Address
+	}
+	if err := m.send(to, msg); err != nil {
+		return fmt.Errorf("unable to send email: %w", err)
+	}
+	return nil
+}
+
+// send delivers the message as smtp.SendMail does, but within the timeout,
+// so that an unresponsive server cannot hold up the sender for good.
+func (m *Email) send(to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", m.addr, m.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("server does not support authentication")
+		}
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *Email) message(subject string, page Page, attachments []Attachment) ([]byte, error) {
+	html, err := page.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	to := make([]string, len(m.to))
+	for i, addr := range m.to {
+		to[i] = addr.String()
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qw := quotedprintable.NewWriter(pw)
+	if _, err := qw.Write([]byte(html)); err != nil {
+		return nil, err
+	}
+	if err := qw.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		data, err := ioutil.ReadAll(a.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read attachment %s: %w", a.Name, err)
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(a.Name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		aw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Lines of base64 are limited to 76 characters.
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(aw, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(aw, "%s\r\n", encoded)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,255 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// sink is an SMTP server keeping the messages it receives.
+type sink struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	msgs []*mail.Message
+	rcpt [][]string
+}
+
+func newSink() (*sink, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &sink{ln: ln}
+	go s.serve()
+	return s, nil
+}
+
+func (s *sink) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *sink) handle(c *textproto.Conn) {
+	defer c.Close()
+	var rcpt []string
+
+	_ = c.PrintfLine("220 sink ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO", "MAIL", "NOOP", "RSET":
+			_ = c.PrintfLine("250 ok")
+		case "RCPT":
+			rcpt = append(rcpt, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			_ = c.PrintfLine("250 ok")
+		case "DATA":
+			_ = c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+			if err != nil {
+				_ = c.PrintfLine("554 unable to parse message")
+				continue
+			}
+			s.mu.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.rcpt = append(s.rcpt, rcpt)
+			s.mu.Unlock()
+			_ = c.PrintfLine("250 queued")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+type EmailTestSuite struct {
+	suite.Suite
+	sink  *sink
+	email *Email
+}
+
+func TestEmailTestSuite(t *testing.T) {
+	suite.Run(t, new(EmailTestSuite))
+}
+
+func (suite *EmailTestSuite) SetupTest() {
+	s, err := newSink()
+	assert.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { s.ln.Close() })
+	suite.sink = s
+
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	em, err := NewEmail(defs.EmailConfig{
+		Host: host,
+		Port: p,
+		From: "iv2 <iv2@example.com>",
+		To:   []string{"grandma@example.com", "Grandpa <grandpa@example.com>"},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.email = em
+}
+
+type part struct {
+	filename string
+	body     string
+}
+
+// parts returns the decoded parts of the message, by content type.
+func (suite *EmailTestSuite) parts(msg *mail.Message) map[string]part {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "multipart/mixed", mediaType)
+
+	parts := make(map[string]part)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		var r io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			r = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		body, err := ioutil.ReadAll(r)
+		assert.NoError(suite.T(), err)
+
+		contentType := strings.SplitN(p.Header.Get("Content-Type"), ";", 2)[0]
+		parts[contentType] = part{filename: p.FileName(), body: string(body)}
+	}
+	return parts
+}
+
+func (suite *EmailTestSuite) TestAlert() {
+	e := Alert(defs.Alert{
+		Time:     time.Date(2022, time.May, 12, 3, 0, 0, 0, time.UTC),
+		Label:    defs.LowGlucoseLabel,
+		Reason:   "current value: 3.10\n<dropping>",
+		Severity: defs.Urgent,
+		State:    defs.AlertTriggered,
+		Value:    3.1,
+	}, AlertRaised)
+	assert.NoError(suite.T(), suite.email.Notify(context.Background(), e))
+
+	assert.Len(suite.T(), suite.sink.msgs, 1)
+	assert.Equal(suite.T(), []string{"grandma@example.com", "grandpa@example.com"}, suite.sink.rcpt[0])
+
+	msg := suite.sink.msgs[0]
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "⚠️ "+defs.LowGlucoseLabel, subject)
+
+	html := suite.parts(msg)["text/html"].body
+	assert.Contains(suite.T(), html, "<p>current value: 3.10</p>")
+	assert.Contains(suite.T(), html, "&lt;dropping&gt;", "escaped")
+	assert.Contains(suite.T(), html, "May 12 03:00")
+}
+
+func (suite *EmailTestSuite) TestAlertLifecycle() {
+	a := defs.Alert{Label: defs.LowGlucoseLabel, Severity: defs.Urgent, State: defs.AlertTriggered}
+	for _, change := range []string{AlertWorsened, AlertAcked, AlertSnoozed, AlertEscalated} {
+		assert.NoError(suite.T(), suite.email.Notify(context.Background(), Alert(a, change)))
+	}
+	assert.Empty(suite.T(), suite.sink.msgs, "only the raise and the resolution are emailed")
+
+	a.Quiet = true
+	assert.NoError(suite.T(), suite.email.Notify(context.Background(), Alert(a, AlertRaised)))
+	assert.Empty(suite.T(), suite.sink.msgs, "quiet alerts wait for the digest")
+
+	a.Quiet = false
+	a.State = defs.AlertResolved
+	assert.NoError(suite.T(), suite.email.Notify(context.Background(), Alert(a, AlertResolved)))
+	assert.Len(suite.T(), suite.sink.msgs, 1)
+	subject, err := new(mime.WordDecoder).DecodeHeader(suite.sink.msgs[0].Header.Get("Subject"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "✅ "+defs.LowGlucoseLabel+" resolved", subject)
+}
+
+func (suite *EmailTestSuite) TestEvents() {
+	e := Reading(defs.TransformedReading{Mmol: 5})
+	assert.NoError(suite.T(), suite.email.Notify(context.Background(), e))
+	assert.Empty(suite.T(), suite.sink.msgs, "only alerts by default")
+
+	_, err := NewEmail(defs.EmailConfig{Host: "localhost", From: "iv2@example.com"}, nil)
+	assert.Error(suite.T(), err, "no recipients")
+	_, err = NewEmail(defs.EmailConfig{Host: "localhost", To: []string{"a@example.com"}}, nil)
+	assert.Error(suite.T(), err, "no sender")
+	_, err = NewEmail(defs.EmailConfig{
+		Host: "localhost", From: "iv2@example.com", To: []string{"a@example.com"}, Events: []string{"meal"},
+	}, nil)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *EmailTestSuite) TestAttachment() {
+	plot := strings.Repeat("\x89PNG", 100)
+	page := Page{
+		Title:  "summary",
+		Fields: []Field{{Name: "In Range", Value: "72%"}},
+	}
+	err := suite.email.Send("summary", page, Attachment{Name: "daily.png", Reader: strings.NewReader(plot)})
+	assert.NoError(suite.T(), err)
+
+	assert.Len(suite.T(), suite.sink.msgs, 1)
+	parts := suite.parts(suite.sink.msgs[0])
+	assert.Contains(suite.T(), parts["text/html"].body, "<td>72%</td>")
+	assert.Equal(suite.T(), "daily.png", parts["image/png"].filename)
+	assert.Equal(suite.T(), plot, parts["image/png"].body)
+}
+
+func (suite *EmailTestSuite) TestTimeout() {
+	// A server accepting the connection, but never greeting.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	suite.email.addr = ln.Addr().String()
+	suite.email.timeout = 50 * time.Millisecond
+	start := time.Now()
+	assert.Error(suite.T(), suite.email.Send("summary", Page{Title: "summary"}))
+	assert.Less(suite.T(), time.Since(start), time.Second, "gives up after the timeout")
+}
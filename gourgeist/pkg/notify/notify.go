@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/mg"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Types of events.
+const (
+	AlertEvent     = "alert"
+	ReadingEvent   = "reading"
+	TreatmentEvent = "treatment"
+)
+
+// Changes of an alert through its lifecycle.
+const (
+	AlertRaised    = "raised"
+	AlertWorsened  = "worsened"
+	AlertAcked     = "acknowledged"
+	AlertSnoozed   = "snoozed"
+	AlertEscalated = "escalated"
+	AlertResolved  = "resolved"
+)
+
+// Kinds of treatments.
+const (
+	InsulinTreatment = "insulin"
+	CarbsTreatment   = "carbs"
+)
+
+// Notifier sends the events outside of Discord.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Event is what the notifiers send, with the data depending on the type.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// AlertData is an alert as it was raised, or as it moves through its
+// lifecycle.
+type AlertData struct {
+	ID       string    `json:"id,omitempty"`
+	Change   string    `json:"change"`
+	Time     time.Time `json:"time"`
+	Label    string    `json:"label"`
+	Reason   string    `json:"reason"`
+	Severity string    `json:"severity"`
+	State    string    `json:"state,omitempty"`
+	Value    float64   `json:"value,omitempty"`
+	Quiet    bool      `json:"quiet,omitempty"`
+
+	AckedBy    string `json:"ackedBy,omitempty"`
+	Escalation int    `json:"escalation,omitempty"`
+}
+
+type ReadingData struct {
+	Time  time.Time `json:"time"`
+	Mmol  float64   `json:"mmol"`
+	Trend string    `json:"trend"`
+}
+
+// TreatmentData is logged insulin or carbs.
+type TreatmentData struct {
+	Kind       string    `json:"kind"`
+	Time       time.Time `json:"time"`
+	Amount     float64   `json:"amount"`
+	Type       string    `json:"insulinType,omitempty"`
+	Absorption int       `json:"absorption,omitempty"` // In minutes.
+}
+
+// Alert is the event of the change of the alert.
+func Alert(a defs.Alert, change string) Event {
+	return Event{
+		Type: AlertEvent,
+		Time: time.Now(),
+		Data: AlertData{
+			ID:       string(a.ID),
+			Change:   change,
+			Time:     a.Time,
+			Label:    a.Label,
+			Reason:   a.Reason,
+			Severity: a.Severity.String(),
+			State:    string(a.State),
+			Value:    a.Value,
+			Quiet:    a.Quiet,
+
+			AckedBy:    a.AckedBy,
+			Escalation: a.Escalation,
+		},
+	}
+}
+
+func Reading(tr defs.TransformedReading) Event {
+	return Event{
+		Type: ReadingEvent,
+		Time: time.Now(),
+		Data: ReadingData{Time: tr.Time, Mmol: tr.Mmol, Trend: tr.Trend},
+	}
+}
+
+func Insulin(in defs.Insulin) Event {
+	return Event{
+		Type: TreatmentEvent,
+		Time: time.Now(),
+		Data: TreatmentData{Kind: InsulinTreatment, Time: in.Time, Amount: in.Amount, Type: in.Type},
+	}
+}
+
+func Carbs(c defs.Carb) Event {
+	return Event{
+		Type: TreatmentEvent,
+		Time: time.Now(),
+		Data: TreatmentData{Kind: CarbsTreatment, Time: c.Time, Amount: c.Amount, Absorption: c.Absorption},
+	}
+}
+
+// Multi sends the events to each of the notifiers, returning the first
+// error.
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, e Event) error {
+	var first error
+	for _, n := range m {
+		if err := n.Notify(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// asyncQueue is how many events are queued for each notifier.
+const asyncQueue = 256
+
+// Async sends the events in the background, so that a slow or unreachable
+// endpoint never holds up the caller. The events are queued for a single
+// worker, which sends them in order, and dropped once the queue is full.
+// Errors are only logged.
+type Async struct {
+	notifier Notifier
+	logger   *zap.Logger
+	events   chan Event
+}
+
+// NewAsync starts the worker sending the events to the notifier, with room
+// for size events in the queue.
+func NewAsync(n Notifier, size int, logger *zap.Logger) *Async {
+	a := &Async{notifier: n, logger: logger, events: make(chan Event, size)}
+	go a.work()
+	return a
+}
+
+func (a *Async) Notify(_ context.Context, e Event) error {
+	select {
+	case a.events <- e:
+		return nil
+	default:
+		return fmt.Errorf("queue is full, dropped %s event", e.Type)
+	}
+}
+
+func (a *Async) work() {
+	for e := range a.events {
+		if err := a.notifier.Notify(context.Background(), e); err != nil {
+			a.logger.Warn("unable to notify", zap.String("event", e.Type), zap.Error(err))
+		}
+	}
+}
+
+// FromConfig creates the configured notifiers, each sending in the
+// background. Events undeliverable to the webhooks are written to the dead
+// letter store.
+func FromConfig(cfg defs.NotifyConfig, dead mg.DeadLetterStore, loc *time.Location,
+	logger *zap.Logger) (Multi, error) {
+	var m Multi
+	for i, wcfg := range cfg.Webhooks {
+		w, err := NewWebhook(wcfg, dead, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create webhook %d: %w", i, err)
+		}
+		m = append(m, NewAsync(w, asyncQueue, logger))
+	}
+
+	if cfg.Email != nil {
+		em, err := NewEmail(*cfg.Email, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create email: %w", err)
+		}
+		m = append(m, NewAsync(em, asyncQueue, logger))
+	}
+	return m, nil
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// blocking records the events it is sent, each one only once released.
+type blocking struct {
+	started chan Event
+	release chan struct{}
+}
+
+func (b *blocking) Notify(_ context.Context, e Event) error {
+	b.started <- e
+	<-b.release
+	return nil
+}
+
+type NotifyTestSuite struct {
+	suite.Suite
+}
+
+func TestNotifyTestSuite(t *testing.T) {
+	suite.Run(t, new(NotifyTestSuite))
+}
+
+func (suite *NotifyTestSuite) TestAsync() {
+	b := &blocking{started: make(chan Event, 10), release: make(chan struct{})}
+	a := NewAsync(b, 2, zap.NewNop())
+
+	assert.NoError(suite.T(), a.Notify(context.Background(), Event{Type: "1"}))
+	assert.Equal(suite.T(), "1", (<-b.started).Type)
+
+	assert.NoError(suite.T(), a.Notify(context.Background(), Event{Type: "2"}))
+	assert.NoError(suite.T(), a.Notify(context.Background(), Event{Type: "3"}))
+	assert.Error(suite.T(), a.Notify(context.Background(), Event{Type: "4"}), "the queue is full")
+
+	close(b.release)
+	for _, want := range []string{"2", "3"} {
+		select {
+		case e := <-b.started:
+			assert.Equal(suite.T(), want, e.Type, "events are sent in order")
+		case <-time.After(time.Second):
+			suite.T().Fatalf("event %s was not sent", want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/mg"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Headers of the webhook requests.
+const (
+	SignatureHeader = "X-Iv2-Signature"
+	EventHeader     = "X-Iv2-Event"
+)
+
+// Webhook POSTs the events as JSON to a URL, signed with HMAC-SHA256 so that
+// the receiver can check they come from iv2.
+type Webhook struct {
+	url     string
+	secret  string
+	events  map[string]bool
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	dead    mg.DeadLetterStore
+	logger  *zap.Logger
+}
+
+func NewWebhook(cfg defs.WebhookConfig, dead mg.DeadLetterStore, logger *zap.Logger) (*Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+
+	w := &Webhook{
+		url:     cfg.URL,
+		secret:  cfg.Secret,
+		retries: cfg.Retries,
+		backoff: time.Duration(cfg.Backoff) * time.Second,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		dead:    dead,
+		logger:  logger,
+	}
+	if w.retries <= 0 {
+		w.retries = defs.DefaultWebhookRetries
+	}
+	if w.backoff <= 0 {
+		w.backoff = defs.DefaultWebhookBackoff * time.Second
+	}
+	if w.client.Timeout <= 0 {
+		w.client.Timeout = defs.DefaultWebhookTimeout * time.Second
+	}
+
+	if len(cfg.Events) > 0 {
+		w.events = make(map[string]bool)
+	}
+	for _, e := range cfg.Events {
+		switch e {
+		case AlertEvent, ReadingEvent, TreatmentEvent:
+			w.events[e] = true
+		default:
+			return nil, fmt.Errorf("unknown event: %s", e)
+		}
+	}
+
+	return w, nil
+}
+
+// Notify delivers the event, retrying on network errors and server errors.
+// Events that cannot be delivered are written as dead letters.
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	if w.events != nil && !w.events[e.Type] {
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %w", err)
+	}
+
+	var attempts int
+	for {
+		attempts++
+		var retry bool
+		retry, err = w.post(ctx, e.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempts > w.retries || !w.wait(ctx, attempts) {
+			break
+		}
+	}
+
+	if w.dead != nil {
+		dctx, cancel := context.WithTimeout(context.Background(), defs.TimeoutInterval)
+		defer cancel()
+		_, derr := w.dead.WriteDeadLetter(dctx, &defs.DeadLetter{
+			Time:     time.Now(),
+			URL:      w.url,
+			Event:    e.Type,
+			Payload:  string(body),
+			Attempts: attempts,
+			Error:    err.Error(),
+		})
+		if derr != nil {
+			w.logger.Error("unable to write dead letter", zap.String("url", w.url), zap.Error(derr))
+		}
+	}
+	return fmt.Errorf("unable to deliver %s to %s after %d attempts: %w", e.Type, w.url, attempts, err)
+}
+
+// Replay posts the dead letter once more to the webhook it was meant for,
+// among the configured ones.
+func Replay(ctx context.Context, dl defs.DeadLetter, webhooks []defs.WebhookConfig, logger *zap.Logger) error {
+	for _, cfg := range webhooks {
+		if cfg.URL != dl.URL {
+			continue
+		}
+		w, err := NewWebhook(cfg, nil, logger)
+		if err != nil {
+			return err
+		}
+		_, err = w.post(ctx, dl.Event, []byte(dl.Payload))
+		return err
+	}
+	return fmt.Errorf("no webhook is configured for %s", dl.URL)
+}
+
+// wait backs off before the next attempt, doubling each time, unless the
+// context is done first.
+func (w *Webhook) wait(ctx context.Context, attempts int) bool {
+	w.logger.Debug("retrying webhook", zap.String("url", w.url), zap.Int("attempts", attempts))
+	select {
+	case <-time.After(w.backoff << (attempts - 1)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// post sends the body once, and reports whether it is worth retrying if it
+// failed.
+func (w *Webhook) post(ctx context.Context, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("unable to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	if w.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+}
+
+// Sign returns the signature of the body, as sent in the signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
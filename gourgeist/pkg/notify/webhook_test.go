@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type deadLetters struct {
+	mu  sync.Mutex
+	dls []defs.DeadLetter
+}
+
+func (d *deadLetters) WriteDeadLetter(_ context.Context, dl *defs.DeadLetter) (*defs.UpdateResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dls = append(d.dls, *dl)
+	return &defs.UpdateResult{UpsertedCount: 1}, nil
+}
+
+func (d *deadLetters) ReadDeadLetters(context.Context, time.Time, time.Time) ([]defs.DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dls, nil
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+type WebhookTestSuite struct {
+	suite.Suite
+	dead *deadLetters
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}
+
+func (suite *WebhookTestSuite) SetupTest() {
+	suite.dead = &deadLetters{}
+}
+
+// webhook sends to a server responding with the statuses in turn, and
+// then with 200.
+func (suite *WebhookTestSuite) webhook(cfg defs.WebhookConfig, statuses ...int) (*Webhook, *[]received) {
+	var mu sync.Mutex
+	var reqs []received
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs = append(reqs, received{header: r.Header, body: body})
+
+		if len(reqs) <= len(statuses) {
+			w.WriteHeader(statuses[len(reqs)-1])
+		}
+	}))
+	suite.T().Cleanup(srv.Close)
+
+	cfg.URL = srv.URL
+	w, err := NewWebhook(cfg, suite.dead, zap.NewNop())
+	assert.NoError(suite.T(), err)
+	w.backoff = time.Millisecond
+	return w, &reqs
+}
+
+func (suite *WebhookTestSuite) TestSigned() {
+	w, reqs := suite.webhook(defs.WebhookConfig{Secret: "hunter2"})
+
+	e := Reading(defs.TransformedReading{Time: time.Now(), Mmol: 5.4, Trend: "Flat"})
+	assert.NoError(suite.T(), w.Notify(context.Background(), e))
+	assert.Len(suite.T(), *reqs, 1)
+
+	req := (*reqs)[0]
+	assert.Equal(suite.T(), ReadingEvent, req.header.Get(EventHeader))
+	assert.Equal(suite.T(), Sign("hunter2", req.body), req.header.Get(SignatureHeader))
+	assert.NotEqual(suite.T(), Sign("hunter3", req.body), req.header.Get(SignatureHeader))
+
+	var got struct {
+		Type string      `json:"type"`
+		Data ReadingData `json:"data"`
+	}
+	assert.NoError(suite.T(), json.Unmarshal(req.body, &got))
+	assert.Equal(suite.T(), ReadingEvent, got.Type)
+	assert.Equal(suite.T(), 5.4, got.Data.Mmol)
+}
+
+func (suite *WebhookTestSuite) TestRetries() {
+	w, reqs := suite.webhook(defs.WebhookConfig{}, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	assert.NoError(suite.T(), w.Notify(context.Background(), Alert(defs.Alert{Label: defs.LowGlucoseLabel}, AlertRaised)))
+	assert.Len(suite.T(), *reqs, 3)
+	assert.Empty(suite.T(), suite.dead.dls)
+	assert.Empty(suite.T(), (*reqs)[0].header.Get(SignatureHeader), "unsigned without a secret")
+}
+
+func (suite *WebhookTestSuite) TestDeadLetter() {
+	w, reqs := suite.webhook(defs.WebhookConfig{Retries: 2},
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	err := w.Notify(context.Background(), Carbs(defs.Carb{Amount: 15}))
+	assert.Error(suite.T(), err)
+	assert.Len(suite.T(), *reqs, 3)
+	assert.Len(suite.T(), suite.dead.dls, 1)
+
+	dl := suite.dead.dls[0]
+	assert.Equal(suite.T(), TreatmentEvent, dl.Event)
+	assert.Equal(suite.T(), 3, dl.Attempts)
+	assert.Contains(suite.T(), dl.Payload, `"kind":"carbs"`)
+	assert.Contains(suite.T(), dl.Error, "502")
+
+	// Client errors are not retried.
+	w, reqs = suite.webhook(defs.WebhookConfig{}, http.StatusBadRequest)
+	assert.Error(suite.T(), w.Notify(context.Background(), Insulin(defs.Insulin{Amount: 2})))
+	assert.Len(suite.T(), *reqs, 1)
+	assert.Len(suite.T(), suite.dead.dls, 2)
+}
+
+func (suite *WebhookTestSuite) TestReplay() {
+	cfg := defs.WebhookConfig{Secret: "hunter2"}
+	w, reqs := suite.webhook(cfg)
+	cfg.URL = w.url
+	dl := defs.DeadLetter{URL: w.url, Event: TreatmentEvent, Payload: `{"type":"treatment"}`}
+
+	assert.NoError(suite.T(), Replay(context.Background(), dl, []defs.WebhookConfig{cfg}, zap.NewNop()))
+	assert.Len(suite.T(), *reqs, 1)
+	req := (*reqs)[0]
+	assert.Equal(suite.T(), dl.Payload, string(req.body))
+	assert.Equal(suite.T(), TreatmentEvent, req.header.Get(EventHeader))
+	assert.Equal(suite.T(), Sign("hunter2", req.body), req.header.Get(SignatureHeader))
+
+	err := Replay(context.Background(), dl, nil, zap.NewNop())
+	assert.Error(suite.T(), err, "the webhook is no longer configured")
+}
+
+func (suite *WebhookTestSuite) TestEvents() {
+	w, reqs := suite.webhook(defs.WebhookConfig{Events: []string{AlertEvent}})
+
+	assert.NoError(suite.T(), w.Notify(context.Background(), Reading(defs.TransformedReading{})))
+	assert.NoError(suite.T(), w.Notify(context.Background(), Alert(defs.Alert{}, AlertRaised)))
+	assert.Len(suite.T(), *reqs, 1)
+	assert.Equal(suite.T(), AlertEvent, (*reqs)[0].header.Get(EventHeader))
+
+	cfg := defs.WebhookConfig{URL: "http://localhost", Events: []string{"meal"}}
+	_, err := NewWebhook(cfg, nil, zap.NewNop())
+	assert.Error(suite.T(), err)
+	_, err = NewWebhook(defs.WebhookConfig{URL: "ftp://localhost"}, nil, zap.NewNop())
+	assert.Error(suite.T(), err)
+}
+
+func (suite *WebhookTestSuite) TestFromConfig() {
+	m, err := FromConfig(defs.NotifyConfig{}, suite.dead, time.UTC, zap.NewNop())
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), m.Notify(context.Background(), Alert(defs.Alert{}, AlertRaised)), "nothing to notify")
+
+	cfg := defs.NotifyConfig{Webhooks: []defs.WebhookConfig{{URL: "localhost"}}}
+	_, err = FromConfig(cfg, suite.dead, time.UTC, zap.NewNop())
+	assert.Error(suite.T(), err)
+}
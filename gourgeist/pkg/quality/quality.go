@@ -0,0 +1,157 @@
+package quality
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/schedule"
+	"math"
+	"time"
+)
+
+// noiseWindow is how long the glucose has to reverse within to be noise,
+// and noiseReversals how many times.
+const (
+	noiseWindow    = 30 * time.Minute
+	noiseReversals = 2
+)
+
+// Detector flags the readings that are likely sensor artifacts rather than
+// actual changes in glucose.
+type Detector struct {
+	rate    float64
+	drop    float64
+	rebound time.Duration
+	noise   float64
+	night   *schedule.Window
+	loc     *time.Location
+}
+
+func New(cfg defs.QualityConfig, loc *time.Location) (*Detector, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	d := &Detector{
+		rate:    cfg.Rate,
+		drop:    cfg.Drop,
+		rebound: time.Duration(cfg.Rebound) * time.Minute,
+		noise:   cfg.Noise,
+		loc:     loc,
+	}
+	if d.rate <= 0 {
+		d.rate = defs.DefaultCompressionRate
+	}
+	if d.drop <= 0 {
+		d.drop = defs.DefaultCompressionDrop
+	}
+	if d.rebound <= 0 {
+		d.rebound = defs.DefaultCompressionRebound * time.Minute
+	}
+	if d.noise <= 0 {
+		d.noise = defs.DefaultNoise
+	}
+
+	if cfg.Night != nil {
+		window, err := schedule.ParseWindow(cfg.Night.Start, cfg.Night.End)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse night: %w", err)
+		}
+		d.night = &window
+	}
+
+	return d, nil
+}
+
+// Annotate returns why each of the readings (sorted by time) is suspect, or
+// an empty string if it is not.
+func (d *Detector) Annotate(trs []defs.TransformedReading) []string {
+	reasons := make([]string, len(trs))
+
+	for i := range trs {
+		if d.night != nil && !d.night.Contains(trs[i].Time.In(d.loc)) {
+			continue
+		}
+		for k := i + 1; k < len(trs); k++ {
+			if !d.sharpDrop(trs[i], trs[k]) {
+				continue
+			}
+
+			// The rebound is what tells a compression from an actual low.
+			recovered := trs[i].Mmol - d.drop/2
+			for m := k + 1; m < len(trs) && trs[m].Time.Sub(trs[k].Time) <= d.rebound; m++ {
+				if trs[m].Mmol < recovered {
+					continue
+				}
+				for j := i + 1; j < m; j++ {
+					if trs[j].Mmol < recovered {
+						reasons[j] = defs.CompressionSuspect
+					}
+				}
+				break
+			}
+		}
+	}
+
+	// Glucose does not swing back and forth by much within a short time, so
+	// any readings that do are noise.
+	for i := range trs {
+		var reversals, first, end int
+		for j := i + 1; j+1 < len(trs) && trs[j+1].Time.Sub(trs[i].Time) <= noiseWindow; j++ {
+			before, after := trs[j].Mmol-trs[j-1].Mmol, trs[j+1].Mmol-trs[j].Mmol
+			if before*after >= 0 || math.Min(math.Abs(before), math.Abs(after)) < d.noise {
+				continue
+			}
+			if reversals == 0 {
+				first = j - 1
+			}
+			reversals++
+			end = j + 1
+		}
+		if reversals < noiseReversals {
+			continue
+		}
+		for j := first; j <= end; j++ {
+			if reasons[j] == "" {
+				reasons[j] = defs.NoiseSuspect
+			}
+		}
+	}
+
+	return reasons
+}
+
+// Dropping reports whether the latest reading follows a drop sharp enough to
+// be a compression, which has yet to rebound.
+func (d *Detector) Dropping(trs []defs.TransformedReading) bool {
+	if len(trs) == 0 {
+		return false
+	}
+	latest := trs[len(trs)-1]
+	if d.night != nil && !d.night.Contains(latest.Time.In(d.loc)) {
+		return false
+	}
+
+	for i := len(trs) - 2; i >= 0 && latest.Time.Sub(trs[i].Time) <= d.rebound; i-- {
+		if d.sharpDrop(trs[i], latest) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Detector) sharpDrop(from, to defs.TransformedReading) bool {
+	minutes := to.Time.Sub(from.Time).Minutes()
+	drop := from.Mmol - to.Mmol
+	return minutes > 0 && drop >= d.drop && drop/minutes >= d.rate
+}
+
+// Reliable returns the readings that are not suspect.
+func Reliable(trs []defs.TransformedReading) []defs.TransformedReading {
+	reliable := make([]defs.TransformedReading, 0, len(trs))
+	for _, tr := range trs {
+		if tr.Suspect == "" {
+			reliable = append(reliable, tr)
+		}
+	}
+	return reliable
+}
@@ -0,0 +1,95 @@
+package quality
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type QualityTestSuite struct {
+	suite.Suite
+	start time.Time
+	d     *Detector
+}
+
+func TestQualityTestSuite(t *testing.T) {
+	suite.Run(t, new(QualityTestSuite))
+}
+
+func (suite *QualityTestSuite) SetupTest() {
+	suite.start = time.Date(2022, time.May, 12, 3, 0, 0, 0, time.UTC)
+
+	d, err := New(defs.QualityConfig{}, time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.d = d
+}
+
+// readings are five minutes apart.
+func (suite *QualityTestSuite) readings(mmols ...float64) []defs.TransformedReading {
+	trs := make([]defs.TransformedReading, 0)
+	for i, mmol := range mmols {
+		trs = append(trs, defs.TransformedReading{
+			Time: suite.start.Add(time.Duration(i*5) * time.Minute),
+			Mmol: mmol,
+		})
+	}
+	return trs
+}
+
+func (suite *QualityTestSuite) TestCompression() {
+	trs := suite.readings(6, 6.1, 3.2, 2.9, 3.1, 5.8, 6)
+	assert.Equal(suite.T(), []string{
+		"", "", defs.CompressionSuspect, defs.CompressionSuspect, defs.CompressionSuspect, "", "",
+	}, suite.d.Annotate(trs))
+
+	// Without the rebound, it may well be an actual low.
+	trs = suite.readings(6, 6.1, 3.2, 2.9, 3.1, 3.3, 3.4)
+	assert.Equal(suite.T(), make([]string, len(trs)), suite.d.Annotate(trs))
+
+	// Nor is a gradual fall.
+	trs = suite.readings(6, 5.5, 5, 4.5, 4, 3.5, 6)
+	assert.Equal(suite.T(), make([]string, len(trs)), suite.d.Annotate(trs))
+}
+
+func (suite *QualityTestSuite) TestDropping() {
+	assert.True(suite.T(), suite.d.Dropping(suite.readings(6, 6.1, 3.2)))
+	assert.False(suite.T(), suite.d.Dropping(suite.readings(6, 5.5, 5, 4.5, 4, 3.5)))
+	assert.False(suite.T(), suite.d.Dropping(nil))
+}
+
+func (suite *QualityTestSuite) TestNight() {
+	d, err := New(defs.QualityConfig{Night: &defs.TimeWindow{Start: "22:00", End: "02:00"}}, time.UTC)
+	assert.NoError(suite.T(), err)
+
+	trs := suite.readings(6, 6.1, 3.2, 2.9, 3.1, 5.8, 6)
+	assert.Equal(suite.T(), make([]string, len(trs)), d.Annotate(trs), "outside of the night")
+	assert.False(suite.T(), d.Dropping(trs[:3]))
+
+	_, err = New(defs.QualityConfig{Night: &defs.TimeWindow{Start: "25:00", End: "02:00"}}, time.UTC)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *QualityTestSuite) TestNoise() {
+	trs := suite.readings(7, 7.1, 8.5, 7, 8.4, 7.1, 7.2, 7.3, 7.4, 7.5)
+	reasons := suite.d.Annotate(trs)
+	for i, reason := range reasons {
+		if i >= 1 && i <= 5 {
+			assert.Equal(suite.T(), defs.NoiseSuspect, reason, "reading %d", i)
+		} else {
+			assert.Empty(suite.T(), reason, "reading %d", i)
+		}
+	}
+
+	// A single swing is not enough.
+	trs = suite.readings(7, 7.1, 8.5, 7, 7.1, 7.2)
+	assert.Equal(suite.T(), make([]string, len(trs)), suite.d.Annotate(trs))
+}
+
+func (suite *QualityTestSuite) TestReliable() {
+	trs := suite.readings(6, 3, 6)
+	trs[1].Suspect = defs.CompressionSuspect
+	assert.Equal(suite.T(), []defs.TransformedReading{trs[0], trs[2]}, Reliable(trs))
+}
@@ -0,0 +1,458 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/schedule"
+	"text/template"
+	"time"
+)
+
+// Ranges of a condition.
+const (
+	AboveRange = "above"
+	BelowRange = "below"
+)
+
+// Allowance for a missed reading when checking that the readings cover
+// the duration of a rule.
+const readingGap = 5 * time.Minute
+
+type Rule struct {
+	defs.AlertRule
+
+	severity defs.Severity
+	tmpl     *template.Template
+	window   *schedule.Window
+}
+
+// Data is what the rules are evaluated against.
+type Data struct {
+	Now      time.Time
+	Location *time.Location
+	Glucose  []defs.TransformedReading // Sorted by time.
+	Insulin  []defs.Insulin
+	Carbs    []defs.Carb
+	IOB      func(t time.Time) float64
+	COB      func(t time.Time) float64
+
+	// Thresholds are required by conditions on the range.
+	Thresholds func(t time.Time) defs.Thresholds
+
+	// Readings older than MaxAge are considered stale, and never satisfy
+	// a condition. Zero means no limit.
+	MaxAge time.Duration
+}
+
+// Values are the values a rule was triggered with, and are available to
+// the message template.
+type Values struct {
+	Time    time.Time
+	Glucose float64
+	Trend   string
+	IOB     float64
+	COB     float64
+	Low     float64 // Thresholds active at the time.
+	High    float64
+
+	Corrections int
+}
+
+func New(cfg defs.AlertRule) (*Rule, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("rule is missing a name")
+	}
+
+	r := &Rule{AlertRule: cfg, severity: defs.Warning}
+	if cfg.Severity != "" {
+		sev, err := defs.ParseSeverity(cfg.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", cfg.Name, err)
+		}
+		r.severity = sev
+	}
+
+	tmpl, err := template.New(cfg.Name).Parse(cfg.Message)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: unable to parse message: %w", cfg.Name, err)
+	}
+	r.tmpl = tmpl
+
+	if tw := cfg.Condition.Time; tw != nil {
+		window, err := schedule.ParseWindow(tw.Start, tw.End)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", cfg.Name, err)
+		}
+		r.window = &window
+	}
+
+	switch cfg.Condition.Range {
+	case "", AboveRange, BelowRange:
+	default:
+		return nil, fmt.Errorf("rule %s: unknown range: %s", cfg.Name, cfg.Condition.Range)
+	}
+
+	if len(r.Channels) == 0 {
+		r.Channels = []string{defs.AlertsChannel}
+	}
+
+	return r, nil
+}
+
+// FromConfig creates the configured rules, falling back to the default
+// rules when none are configured. The priorities of the alarm config
+// override the severities of the rules.
+func FromConfig(cfgs []defs.AlertRule, acfg defs.AlarmConfig) ([]*Rule, error) {
+	if len(cfgs) == 0 {
+		cfgs = Defaults(acfg)
+	}
+
+	rules := make([]*Rule, 0)
+	for _, cfg := range cfgs {
+		if sev, ok := acfg.Priorities[cfg.Name]; ok {
+			cfg.Severity = sev
+		}
+		r, err := New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Defaults are the high and low glucose alerts against the active
+// thresholds, the repeated corrections alert, and the missing slow acting
+// insulin alert, which is left out when the basal doses are scheduled.
+func Defaults(acfg defs.AlarmConfig) []defs.AlertRule {
+	sc := acfg.Stacking
+	if sc.Corrections <= 0 {
+		sc.Corrections = defs.DefaultStackingCorrections
+	}
+	if sc.Window <= 0 {
+		sc.Window = defs.DefaultStackingWindow
+	}
+
+	rules := []defs.AlertRule{
+		{
+			Name:      defs.HighGlucoseLabel,
+			Condition: defs.RuleCondition{Range: AboveRange},
+			Severity:  defs.Warning.String(),
+			Message:   `current value: {{printf "%.2f" .Glucose}} ≥ {{printf "%.2f" .High}}`,
+		},
+		{
+			Name:      defs.LowGlucoseLabel,
+			Condition: defs.RuleCondition{Range: BelowRange},
+			Severity:  defs.Urgent.String(),
+			Message:   `current value: {{printf "%.2f" .Glucose}} ≤ {{printf "%.2f" .Low}}`,
+		},
+		{
+			Name: defs.RepeatedCorrectionLabel,
+			Condition: defs.RuleCondition{RepeatedCorrections: &defs.RepeatedCorrections{
+				Count:      sc.Corrections,
+				Within:     sc.Window,
+				MealWindow: defs.DefaultBolusWindow,
+			}},
+			Severity: defs.Warning.String(),
+			Cooldown: sc.Window,
+			Message: fmt.Sprintf(
+				"{{.Corrections}} correction boluses in the last %d minutes, beware of stacking",
+				sc.Window,
+			),
+		},
+	}
+
+	if len(acfg.Basal.Doses) == 0 {
+		rules = append(rules, defs.AlertRule{
+			Name: defs.MissingSlowInsulinLabel,
+			Condition: defs.RuleCondition{MissingInsulin: &defs.MissingInsulin{
+				Type:   defs.SlowActing.String(),
+				Within: 24 * 60,
+			}},
+			Severity: defs.Warning.String(),
+			Cooldown: acfg.NoInsulinTimeout,
+			Message:  fmt.Sprintf("last administered: ≥ %d hours ago", 24),
+		})
+	}
+	return rules
+}
+
+// Priorities parses the severities of the alerts by label.
+func Priorities(priorities map[string]string) (map[string]defs.Severity, error) {
+	sevs := make(map[string]defs.Severity)
+	for label, priority := range priorities {
+		sev, err := defs.ParseSeverity(priority)
+		if err != nil {
+			return nil, fmt.Errorf("priority of %s: %w", label, err)
+		}
+		sevs[label] = sev
+	}
+	return sevs, nil
+}
+
+// Channels returns all the channels used by the rules.
+func Channels(rules []*Rule) []string {
+	set := make(map[string]struct{})
+	channels := make([]string, 0)
+	for _, r := range rules {
+		for _, ch := range r.Channels {
+			if _, ok := set[ch]; !ok {
+				set[ch] = struct{}{}
+				channels = append(channels, ch)
+			}
+		}
+	}
+	return channels
+}
+
+func (r *Rule) Severity() defs.Severity {
+	return r.severity
+}
+
+// Lookback is how far back data is needed to evaluate the rule.
+func (r *Rule) Lookback() time.Duration {
+	lookback := time.Duration(r.Duration)*time.Minute + readingGap
+	if mi := r.Condition.MissingInsulin; mi != nil {
+		if within := time.Duration(mi.Within) * time.Minute; within > lookback {
+			lookback = within
+		}
+	}
+	if rc := r.Condition.RepeatedCorrections; rc != nil {
+		if within := time.Duration(rc.Within+rc.MealWindow) * time.Minute; within > lookback {
+			lookback = within
+		}
+	}
+	return lookback
+}
+
+// Evaluate reports whether the condition of the rule holds, along with the
+// values it was evaluated with.
+func (r *Rule) Evaluate(d Data) (bool, Values) {
+	cond := r.Condition
+	vals := Values{Time: d.Now}
+
+	if mi := cond.MissingInsulin; mi != nil {
+		since := d.Now.Add(time.Duration(-mi.Within) * time.Minute)
+		for _, in := range d.Insulin {
+			if in.Type == mi.Type && !in.Time.Before(since) && !in.Time.After(d.Now) {
+				return false, vals
+			}
+		}
+	}
+
+	if rc := cond.RepeatedCorrections; rc != nil {
+		vals.Corrections = corrections(d, rc)
+		if vals.Corrections < rc.Count {
+			return false, vals
+		}
+	}
+
+	// Conditions without anything to check against the readings only
+	// depend on the current time.
+	if cond.Range == "" && cond.Glucose == nil && cond.IOB == nil &&
+		cond.COB == nil && len(cond.Trends) == 0 {
+		return r.inWindow(d.Now, d.Location), vals
+	}
+
+	if len(d.Glucose) == 0 {
+		return false, vals
+	}
+	latest := d.Glucose[len(d.Glucose)-1]
+	if d.MaxAge > 0 && d.Now.Sub(latest.Time) >= d.MaxAge {
+		return false, vals
+	}
+
+	start := latest.Time.Add(time.Duration(-r.Duration) * time.Minute)
+	earliest := latest.Time
+	for i := len(d.Glucose) - 1; i >= 0 && !d.Glucose[i].Time.Before(start); i-- {
+		tr := d.Glucose[i]
+		v := Values{Time: tr.Time, Glucose: tr.Mmol, Trend: tr.Trend, Corrections: vals.Corrections}
+		if d.IOB != nil {
+			v.IOB = d.IOB(tr.Time)
+		}
+		if d.COB != nil {
+			v.COB = d.COB(tr.Time)
+		}
+		if d.Thresholds != nil {
+			th := d.Thresholds(tr.Time)
+			v.Low, v.High = th.Low, th.High
+		} else if cond.Range != "" {
+			return false, vals
+		}
+		if !r.matches(v, d.Location) {
+			return false, vals
+		}
+		if i == len(d.Glucose)-1 {
+			vals = v
+		}
+		earliest = tr.Time
+	}
+
+	// The readings need to cover the whole duration.
+	if earliest.Sub(start) > readingGap {
+		return false, vals
+	}
+
+	return true, vals
+}
+
+// Tracked reports whether the rule is on the glucose, in which case its
+// alerts are resolved once the glucose recovers rather than after the
+// cooldown.
+func (r *Rule) Tracked() bool {
+	return r.Condition.Range != "" || r.Condition.Glucose != nil
+}
+
+// Direction is 1 when higher glucose worsens the condition, -1 when lower
+// glucose does, and 0 when neither does, such as for a band of glucose.
+func (r *Rule) Direction() int {
+	cond := r.Condition
+	switch {
+	case cond.Range == AboveRange:
+		return 1
+	case cond.Range == BelowRange:
+		return -1
+	case cond.Glucose == nil:
+		return 0
+	case cond.Glucose.Above != nil && cond.Glucose.Below == nil:
+		return 1
+	case cond.Glucose.Below != nil && cond.Glucose.Above == nil:
+		return -1
+	}
+	return 0
+}
+
+// Recovered reports whether the latest reading is clear of the glucose
+// condition of the rule by at least the hysteresis, along with its values.
+// Stale or missing readings never count as recovered.
+func (r *Rule) Recovered(d Data, hysteresis float64) (bool, Values) {
+	vals := Values{Time: d.Now}
+	if len(d.Glucose) == 0 {
+		return false, vals
+	}
+	latest := d.Glucose[len(d.Glucose)-1]
+	if d.MaxAge > 0 && d.Now.Sub(latest.Time) >= d.MaxAge {
+		return false, vals
+	}
+
+	vals = Values{Time: latest.Time, Glucose: latest.Mmol, Trend: latest.Trend}
+	if d.Thresholds != nil {
+		th := d.Thresholds(latest.Time)
+		vals.Low, vals.High = th.Low, th.High
+	} else if r.Condition.Range != "" {
+		return false, vals
+	}
+
+	cond := r.Condition
+	switch {
+	case !within(cond.Glucose, vals.Glucose, hysteresis):
+		return true, vals
+	case cond.Range == AboveRange:
+		return vals.Glucose < vals.High-hysteresis, vals
+	case cond.Range == BelowRange:
+		return vals.Glucose > vals.Low+hysteresis, vals
+	}
+	return false, vals
+}
+
+// Entered reports whether the rule holds with the latest reading past the
+// threshold by the margin, so that glucose hovering at the threshold does
+// not trigger it.
+func (r *Rule) Entered(d Data, margin float64) (bool, Values) {
+	ok, vals := r.Evaluate(d)
+	if !ok {
+		return false, vals
+	}
+	if shallow, _ := r.Recovered(d, -margin); shallow {
+		return false, vals
+	}
+	return true, vals
+}
+
+// Message renders the message of the rule with the values.
+func (r *Rule) Message(v Values) (string, error) {
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, v); err != nil {
+		return "", fmt.Errorf("unable to render message: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func (r *Rule) matches(v Values, loc *time.Location) bool {
+	cond := r.Condition
+	if !contains(cond.Glucose, v.Glucose) || !contains(cond.IOB, v.IOB) ||
+		!contains(cond.COB, v.COB) {
+		return false
+	}
+
+	switch {
+	case cond.Range == AboveRange && v.Glucose < v.High:
+		return false
+	case cond.Range == BelowRange && v.Glucose > v.Low:
+		return false
+	}
+
+	if len(cond.Trends) > 0 {
+		var found bool
+		for _, trend := range cond.Trends {
+			found = found || trend == v.Trend
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return r.inWindow(v.Time, loc)
+}
+
+func (r *Rule) inWindow(t time.Time, loc *time.Location) bool {
+	if r.window == nil {
+		return true
+	}
+	if loc != nil {
+		t = t.In(loc)
+	}
+	return r.window.Contains(t)
+}
+
+func contains(b *defs.Bounds, v float64) bool {
+	if b == nil {
+		return true
+	}
+	return (b.Above == nil || v >= *b.Above) && (b.Below == nil || v <= *b.Below)
+}
+
+// within is contains, with the bounds widened by the margin.
+func within(b *defs.Bounds, v, margin float64) bool {
+	if b == nil {
+		return true
+	}
+	return (b.Above == nil || v >= *b.Above-margin) && (b.Below == nil || v <= *b.Below+margin)
+}
+
+// corrections counts the rapid acting doses in the window without carbs
+// logged around them.
+func corrections(d Data, rc *defs.RepeatedCorrections) int {
+	since := d.Now.Add(time.Duration(-rc.Within) * time.Minute)
+	mealWindow := time.Duration(rc.MealWindow) * time.Minute
+
+	var count int
+	for _, in := range d.Insulin {
+		if in.Type != defs.RapidActing.String() || in.Time.Before(since) || in.Time.After(d.Now) {
+			continue
+		}
+
+		var meal bool
+		for _, c := range d.Carbs {
+			if !c.Time.Before(in.Time.Add(-mealWindow)) && !c.Time.After(in.Time.Add(mealWindow)) {
+				meal = true
+				break
+			}
+		}
+		if !meal {
+			count++
+		}
+	}
+	return count
+}
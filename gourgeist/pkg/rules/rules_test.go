@@ -0,0 +1,394 @@
+package rules
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type RulesTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func TestRulesTestSuite(t *testing.T) {
+	suite.Run(t, new(RulesTestSuite))
+}
+
+func (suite *RulesTestSuite) SetupTest() {
+	suite.now = time.Date(2022, time.May, 12, 12, 0, 0, 0, time.UTC)
+}
+
+func (suite *RulesTestSuite) TestDefaults() {
+	rs, err := FromConfig(nil, defs.AlarmConfig{})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), rs, 4)
+
+	data := Data{
+		Now:     suite.now,
+		Glucose: suite.readings(3.5, 3.5),
+		Thresholds: func(time.Time) defs.Thresholds {
+			return defs.Thresholds{Low: 4, High: 9}
+		},
+	}
+	for _, r := range rs {
+		ok, vals := r.Evaluate(data)
+		switch r.Name {
+		case defs.HighGlucoseLabel:
+			assert.False(suite.T(), ok, "high glucose should not trigger")
+		case defs.LowGlucoseLabel:
+			assert.True(suite.T(), ok, "low glucose should trigger")
+			msg, err := r.Message(vals)
+			assert.NoError(suite.T(), err)
+			assert.Equal(suite.T(), "current value: 3.50 ≤ 4.00", msg)
+		case defs.MissingSlowInsulinLabel:
+			assert.True(suite.T(), ok, "missing slow insulin should trigger")
+		}
+	}
+}
+
+func (suite *RulesTestSuite) TestDefaultsWithBasalSchedule() {
+	rs, err := FromConfig(nil, defs.AlarmConfig{
+		Basal: defs.BasalConfig{Doses: []defs.BasalDose{{Time: "22:00"}}},
+	})
+	assert.NoError(suite.T(), err)
+	for _, r := range rs {
+		assert.NotEqual(suite.T(), defs.MissingSlowInsulinLabel, r.Name,
+			"scheduled basal doses replace the 24 hour check")
+	}
+}
+
+func (suite *RulesTestSuite) TestPriorities() {
+	acfg := defs.AlarmConfig{Priorities: map[string]string{
+		defs.HighGlucoseLabel: defs.Info.String(),
+		defs.NoDataLabel:      defs.Urgent.String(),
+	}}
+	rs, err := FromConfig(nil, acfg)
+	assert.NoError(suite.T(), err)
+	for _, r := range rs {
+		switch r.Name {
+		case defs.HighGlucoseLabel:
+			assert.Equal(suite.T(), defs.Info, r.Severity())
+		case defs.LowGlucoseLabel:
+			assert.Equal(suite.T(), defs.Urgent, r.Severity())
+		}
+	}
+
+	sevs, err := Priorities(acfg.Priorities)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), defs.Urgent, sevs[defs.NoDataLabel])
+
+	acfg.Priorities[defs.NoDataLabel] = "critical"
+	_, err = Priorities(acfg.Priorities)
+	assert.Error(suite.T(), err)
+	_, err = FromConfig([]defs.AlertRule{{Name: defs.NoDataLabel}}, acfg)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *RulesTestSuite) TestRangeFollowsThresholds() {
+	r, err := New(defs.AlertRule{
+		Name:      defs.HighGlucoseLabel,
+		Condition: defs.RuleCondition{Range: AboveRange},
+		Message:   `{{printf "%.1f" .Glucose}} ≥ {{printf "%.1f" .High}}`,
+	})
+	assert.NoError(suite.T(), err)
+
+	data := Data{Now: suite.now, Glucose: suite.readings(8)}
+	ok, _ := r.Evaluate(data)
+	assert.False(suite.T(), ok, "range requires thresholds")
+
+	data.Thresholds = func(time.Time) defs.Thresholds { return defs.Thresholds{Low: 4, High: 9} }
+	ok, _ = r.Evaluate(data)
+	assert.False(suite.T(), ok, "within the default range")
+
+	data.Thresholds = func(time.Time) defs.Thresholds { return defs.Thresholds{Low: 4, High: 7} }
+	ok, vals := r.Evaluate(data)
+	assert.True(suite.T(), ok, "above the tightened range")
+	msg, err := r.Message(vals)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "8.0 ≥ 7.0", msg)
+}
+
+func (suite *RulesTestSuite) TestDuration() {
+	above := 10.0
+	r, err := New(defs.AlertRule{
+		Name:      "Sustained High",
+		Condition: defs.RuleCondition{Glucose: &defs.Bounds{Above: &above}},
+		Duration:  15,
+	})
+	assert.NoError(suite.T(), err)
+
+	ok, _ := r.Evaluate(Data{Now: suite.now, Glucose: suite.readings(9, 11, 11, 11, 11)})
+	assert.True(suite.T(), ok, "condition held over the duration")
+
+	ok, _ = r.Evaluate(Data{Now: suite.now, Glucose: suite.readings(11, 9, 11, 11)})
+	assert.False(suite.T(), ok, "condition did not hold over the duration")
+
+	ok, _ = r.Evaluate(Data{Now: suite.now, Glucose: suite.readings(11, 11)})
+	assert.False(suite.T(), ok, "readings do not cover the duration")
+}
+
+func (suite *RulesTestSuite) TestStaleReadings() {
+	below := 4.0
+	r, err := New(defs.AlertRule{
+		Name:      "Low",
+		Condition: defs.RuleCondition{Glucose: &defs.Bounds{Below: &below}},
+	})
+	assert.NoError(suite.T(), err)
+
+	data := Data{
+		Now:     suite.now.Add(time.Hour),
+		Glucose: suite.readings(3),
+		MaxAge:  20 * time.Minute,
+	}
+	ok, _ := r.Evaluate(data)
+	assert.False(suite.T(), ok, "stale readings should not trigger")
+}
+
+func (suite *RulesTestSuite) TestTrendsAndIOB() {
+	above := 2.0
+	r, err := New(defs.AlertRule{
+		Name: "Falling With Insulin",
+		Condition: defs.RuleCondition{
+			IOB:    &defs.Bounds{Above: &above},
+			Trends: []string{"SingleDown", "DoubleDown"},
+		},
+		Message: `iob: {{printf "%.1f" .IOB}}, trend: {{.Trend}}`,
+	})
+	assert.NoError(suite.T(), err)
+
+	trs := suite.readings(6)
+	trs[0].Trend = "SingleDown"
+	data := Data{Now: suite.now, Glucose: trs, IOB: func(time.Time) float64 { return 3 }}
+
+	ok, vals := r.Evaluate(data)
+	assert.True(suite.T(), ok)
+	msg, err := r.Message(vals)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "iob: 3.0, trend: SingleDown", msg)
+
+	data.IOB = func(time.Time) float64 { return 1 }
+	ok, _ = r.Evaluate(data)
+	assert.False(suite.T(), ok)
+}
+
+func (suite *RulesTestSuite) TestCOB() {
+	below := 10.0
+	r, err := New(defs.AlertRule{
+		Name: "Rising Without Carbs",
+		Condition: defs.RuleCondition{
+			COB:    &defs.Bounds{Below: &below},
+			Trends: []string{"SingleUp", "DoubleUp"},
+		},
+		Message: `cob: {{printf "%.0f" .COB}}g`,
+	})
+	assert.NoError(suite.T(), err)
+
+	trs := suite.readings(9)
+	trs[0].Trend = "SingleUp"
+	data := Data{Now: suite.now, Glucose: trs, COB: func(time.Time) float64 { return 5 }}
+
+	ok, vals := r.Evaluate(data)
+	assert.True(suite.T(), ok)
+	msg, err := r.Message(vals)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "cob: 5g", msg)
+
+	data.COB = func(time.Time) float64 { return 40 }
+	ok, _ = r.Evaluate(data)
+	assert.False(suite.T(), ok, "carbs still absorbing")
+}
+
+func (suite *RulesTestSuite) TestRepeatedCorrections() {
+	rs, err := FromConfig(nil, defs.AlarmConfig{})
+	assert.NoError(suite.T(), err)
+
+	var r *Rule
+	for _, rule := range rs {
+		if rule.Name == defs.RepeatedCorrectionLabel {
+			r = rule
+		}
+	}
+	assert.NotNil(suite.T(), r)
+
+	rapid := defs.RapidActing.String()
+	data := Data{
+		Now: suite.now,
+		Insulin: []defs.Insulin{
+			{Time: suite.now.Add(-4 * time.Hour), Type: rapid, Amount: 2},
+			{Time: suite.now.Add(-2 * time.Hour), Type: rapid, Amount: 4},
+			{Time: suite.now.Add(-time.Hour), Type: rapid, Amount: 2},
+		},
+		Carbs: []defs.Carb{{Time: suite.now.Add(-2*time.Hour - 10*time.Minute), Amount: 50}},
+	}
+
+	ok, _ := r.Evaluate(data)
+	assert.False(suite.T(), ok, "the meal bolus is not a correction")
+
+	data.Insulin = append(data.Insulin, defs.Insulin{Time: suite.now, Type: rapid, Amount: 1})
+	ok, vals := r.Evaluate(data)
+	assert.True(suite.T(), ok)
+	msg, err := r.Message(vals)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "2 correction boluses in the last 180 minutes, beware of stacking", msg)
+}
+
+func (suite *RulesTestSuite) TestRecovered() {
+	r, err := New(defs.AlertRule{
+		Name:      defs.HighGlucoseLabel,
+		Condition: defs.RuleCondition{Range: AboveRange},
+	})
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), r.Tracked())
+	assert.Equal(suite.T(), 1, r.Direction())
+
+	data := Data{
+		Now:        suite.now,
+		Thresholds: func(time.Time) defs.Thresholds { return defs.Thresholds{Low: 4, High: 10} },
+		MaxAge:     20 * time.Minute,
+	}
+
+	for _, tc := range []struct {
+		mmol      float64
+		recovered bool
+	}{
+		{10.5, false},
+		{9.8, false}, // Below the threshold, but within the hysteresis.
+		{9.5, false},
+		{9.4, true},
+	} {
+		data.Glucose = suite.readings(tc.mmol)
+		ok, vals := r.Recovered(data, 0.5)
+		assert.Equal(suite.T(), tc.recovered, ok, "glucose %.1f", tc.mmol)
+		assert.Equal(suite.T(), tc.mmol, vals.Glucose)
+	}
+
+	data.Now = suite.now.Add(time.Hour)
+	ok, _ := r.Recovered(data, 0.5)
+	assert.False(suite.T(), ok, "stale readings are not a recovery")
+
+	below := 3.0
+	r, err = New(defs.AlertRule{
+		Name:      "Urgent Low",
+		Condition: defs.RuleCondition{Glucose: &defs.Bounds{Below: &below}},
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), -1, r.Direction())
+
+	ok, _ = r.Recovered(Data{Now: suite.now, Glucose: suite.readings(3.4)}, 0.5)
+	assert.False(suite.T(), ok, "within the hysteresis")
+	ok, _ = r.Recovered(Data{Now: suite.now, Glucose: suite.readings(3.6)}, 0.5)
+	assert.True(suite.T(), ok, "clear of the hysteresis")
+
+	r, err = New(defs.AlertRule{
+		Name:      defs.RepeatedCorrectionLabel,
+		Condition: defs.RuleCondition{RepeatedCorrections: &defs.RepeatedCorrections{Count: 2}},
+	})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), r.Tracked())
+	assert.Equal(suite.T(), 0, r.Direction())
+}
+
+func (suite *RulesTestSuite) TestEntered() {
+	r, err := New(defs.AlertRule{
+		Name:      defs.HighGlucoseLabel,
+		Condition: defs.RuleCondition{Range: AboveRange},
+	})
+	assert.NoError(suite.T(), err)
+
+	data := Data{
+		Now:        suite.now,
+		Thresholds: func(time.Time) defs.Thresholds { return defs.Thresholds{Low: 4, High: 10} },
+	}
+	for _, tc := range []struct {
+		mmol    float64
+		entered bool
+	}{
+		{9.8, false},
+		{10.2, false}, // Above the threshold, but within the margin.
+		{10.5, true},
+	} {
+		data.Glucose = suite.readings(tc.mmol)
+		ok, _ := r.Entered(data, 0.5)
+		assert.Equal(suite.T(), tc.entered, ok, "glucose %.1f", tc.mmol)
+	}
+
+	below := 3.0
+	r, err = New(defs.AlertRule{
+		Name:      "Urgent Low",
+		Condition: defs.RuleCondition{Glucose: &defs.Bounds{Below: &below}},
+	})
+	assert.NoError(suite.T(), err)
+
+	ok, _ := r.Entered(Data{Now: suite.now, Glucose: suite.readings(2.8)}, 0.5)
+	assert.False(suite.T(), ok, "within the margin")
+	ok, _ = r.Entered(Data{Now: suite.now, Glucose: suite.readings(2.4)}, 0.5)
+	assert.True(suite.T(), ok, "clear of the margin")
+}
+
+func (suite *RulesTestSuite) TestTimeWindow() {
+	r, err := New(defs.AlertRule{
+		Name: "Overnight Bolus Check",
+		Condition: defs.RuleCondition{
+			Time: &defs.TimeWindow{Start: "22:00", End: "06:00"},
+			MissingInsulin: &defs.MissingInsulin{
+				Type:   defs.SlowActing.String(),
+				Within: 60,
+			},
+		},
+	})
+	assert.NoError(suite.T(), err)
+
+	night := time.Date(2022, time.May, 12, 23, 0, 0, 0, time.UTC)
+	ok, _ := r.Evaluate(Data{Now: night, Location: time.UTC})
+	assert.True(suite.T(), ok, "should trigger within the window")
+
+	ok, _ = r.Evaluate(Data{Now: suite.now, Location: time.UTC})
+	assert.False(suite.T(), ok, "should not trigger outside the window")
+
+	ok, _ = r.Evaluate(Data{
+		Now:      night,
+		Location: time.UTC,
+		Insulin: []defs.Insulin{
+			{Time: night.Add(-30 * time.Minute), Type: defs.SlowActing.String()},
+		},
+	})
+	assert.False(suite.T(), ok, "should not trigger with recent insulin")
+}
+
+func (suite *RulesTestSuite) TestInvalidRules() {
+	_, err := New(defs.AlertRule{})
+	assert.Error(suite.T(), err, "rule without a name")
+
+	_, err = New(defs.AlertRule{Name: "test", Severity: "loud"})
+	assert.Error(suite.T(), err, "rule with unknown severity")
+
+	_, err = New(defs.AlertRule{Name: "test", Message: "{{.Glucose"})
+	assert.Error(suite.T(), err, "rule with invalid template")
+
+	_, err = New(defs.AlertRule{
+		Name:      "test",
+		Condition: defs.RuleCondition{Time: &defs.TimeWindow{Start: "25:00", End: "06:00"}},
+	})
+	assert.Error(suite.T(), err, "rule with invalid time window")
+
+	_, err = New(defs.AlertRule{Name: "test", Condition: defs.RuleCondition{Range: "inside"}})
+	assert.Error(suite.T(), err, "rule with unknown range")
+}
+
+// readings returns readings every five minutes, with the last reading at
+// the current time.
+func (suite *RulesTestSuite) readings(mmols ...float64) []defs.TransformedReading {
+	trs := make([]defs.TransformedReading, 0)
+	for i, mmol := range mmols {
+		trs = append(trs, defs.TransformedReading{
+			Time:  suite.now.Add(time.Duration(-5*(len(mmols)-1-i)) * time.Minute),
+			Mmol:  mmol,
+			Trend: "Flat",
+		})
+	}
+	return trs
+}
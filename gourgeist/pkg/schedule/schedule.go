@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"strings"
+	"time"
+)
+
+const clockFormat = "15:04"
+
+// Window is a time of day range, which wraps around midnight when the end
+// is before the start.
+type Window struct {
+	start time.Duration // Offsets from midnight.
+	end   time.Duration
+}
+
+func ParseWindow(start, end string) (Window, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return Window{}, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{start: s, end: e}, nil
+}
+
+// Contains reports whether the time of day of t falls within the window,
+// where a window with the same start and end covers the whole day.
+func (w Window) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	switch {
+	case w.start == w.end:
+		return true
+	case w.start < w.end:
+		return offset >= w.start && offset < w.end
+	default:
+		return offset >= w.start || offset < w.end
+	}
+}
+
+// Weekly maps times of the week onto named profiles.
+type Weekly struct {
+	entries []entry
+	loc     *time.Location
+}
+
+type entry struct {
+	days    map[time.Weekday]struct{} // Empty means every day.
+	window  Window
+	profile string
+}
+
+// New creates a weekly schedule from the entries, where earlier entries
+// take precedence over later ones.
+func New(entries []defs.ScheduleEntry, loc *time.Location) (*Weekly, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	w := &Weekly{entries: make([]entry, 0), loc: loc}
+	for _, e := range entries {
+		window, err := ParseWindow(e.Start, e.End)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", e.Profile, err)
+		}
+
+		days := make(map[time.Weekday]struct{})
+		for _, day := range e.Days {
+			wd, err := parseWeekday(day)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", e.Profile, err)
+			}
+			days[wd] = struct{}{}
+		}
+
+		w.entries = append(w.entries, entry{days: days, window: window, profile: e.Profile})
+	}
+	return w, nil
+}
+
+// Profile returns the name of the profile active at t, or an empty string
+// if none of the entries cover t.
+func (w *Weekly) Profile(t time.Time) string {
+	t = t.In(w.loc)
+	for _, e := range w.entries {
+		if !e.window.Contains(t) {
+			continue
+		}
+
+		// Windows that wrap around midnight belong to the day they start on.
+		day := t.Weekday()
+		if e.window.start > e.window.end && sinceMidnight(t) < e.window.end {
+			day = t.AddDate(0, 0, -1).Weekday()
+		}
+		if _, ok := e.days[day]; ok || len(e.days) == 0 {
+			return e.profile
+		}
+	}
+	return ""
+}
+
+// Profiles returns the names of all the profiles in the schedule.
+func (w *Weekly) Profiles() []string {
+	names := make([]string, 0)
+	for _, e := range w.entries {
+		names = append(names, e.profile)
+	}
+	return names
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
+// OnDay returns the time of day (e.g. 22:00) on the day of t, in the
+// location of t.
+func OnDay(t time.Time, clock string) (time.Time, error) {
+	offset, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, t.Location()), nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(clockFormat, s)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse time of day %s: %w", s, err)
+	}
+	return sinceMidnight(t), nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.HasPrefix(strings.ToLower(wd.String()), strings.ToLower(s)) && len(s) >= 3 {
+			return wd, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown day of the week: %s", s)
+}
@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ScheduleTestSuite struct {
+	suite.Suite
+}
+
+func TestScheduleTestSuite(t *testing.T) {
+	suite.Run(t, new(ScheduleTestSuite))
+}
+
+func (suite *ScheduleTestSuite) TestWindow() {
+	w, err := ParseWindow("22:00", "06:00")
+	assert.NoError(suite.T(), err)
+
+	day := time.Date(2022, time.May, 12, 0, 0, 0, 0, time.UTC)
+	assert.True(suite.T(), w.Contains(day.Add(23*time.Hour)))
+	assert.True(suite.T(), w.Contains(day.Add(5*time.Hour)))
+	assert.False(suite.T(), w.Contains(day.Add(6*time.Hour)))
+	assert.False(suite.T(), w.Contains(day.Add(12*time.Hour)))
+
+	_, err = ParseWindow("22:00", "6pm")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ScheduleTestSuite) TestWeekly() {
+	w, err := New([]defs.ScheduleEntry{
+		{Days: []string{"sat"}, Start: "09:00", End: "12:00", Profile: "sports"},
+		{Days: []string{"fri", "saturday"}, Start: "22:00", End: "07:00", Profile: "night"},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+
+	// May 13, 2022 is a Friday.
+	friday := time.Date(2022, time.May, 13, 0, 0, 0, 0, time.UTC)
+	assert.Equal(suite.T(), "", w.Profile(friday.Add(3*time.Hour)), "belongs to thursday night")
+	assert.Equal(suite.T(), "night", w.Profile(friday.Add(23*time.Hour)))
+	assert.Equal(suite.T(), "night", w.Profile(friday.Add(30*time.Hour)), "saturday morning")
+	assert.Equal(suite.T(), "sports", w.Profile(friday.Add(34*time.Hour)))
+	assert.Equal(suite.T(), "", w.Profile(friday.Add(38*time.Hour)))
+	assert.ElementsMatch(suite.T(), []string{"sports", "night"}, w.Profiles())
+
+	_, err = New([]defs.ScheduleEntry{{Days: []string{"someday"}, Start: "09:00", End: "12:00"}}, time.UTC)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ScheduleTestSuite) TestOnDay() {
+	t := time.Date(2022, time.May, 12, 8, 30, 0, 0, time.UTC)
+	at, err := OnDay(t, "22:15")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), time.Date(2022, time.May, 12, 22, 15, 0, 0, time.UTC), at)
+
+	_, err = OnDay(t, "noon")
+	assert.Error(suite.T(), err)
+}
@@ -0,0 +1,107 @@
+package sensors
+
+import (
+	"iv2/gourgeist/defs"
+	"time"
+)
+
+// earlyEnd is how long before its expiry a sensor has to end to count as
+// ended early. Only sensors within it of their expiry are expected to be
+// replaced.
+const earlyEnd = 24 * time.Hour
+
+// Detect returns the insertion times of the sensors started after a gap in
+// the readings of about the warmup, up to half as long again to swap the
+// sensors, taken as the warmup before the first reading after the gap.
+// Share does not expose the sensor sessions, so this is the best guess
+// without them being started by command. Longer gaps are outages rather
+// than warmups.
+func Detect(glucose []defs.TransformedReading, warmup time.Duration) []time.Time {
+	inserted := make([]time.Time, 0)
+	for i := 1; i < len(glucose); i++ {
+		gap := glucose[i].Time.Sub(glucose[i-1].Time)
+		if gap >= warmup && gap <= warmup*3/2 {
+			inserted = append(inserted, glucose[i].Time.Add(-warmup))
+		}
+	}
+	return inserted
+}
+
+// Due reports whether a new sensor is expected at t, that is no session is
+// active then or the current one is within a day of its expiry.
+func Due(ss []defs.SensorSession, t time.Time) bool {
+	cur := Current(ss, t)
+	return cur == nil || cur.Expiry.Sub(t) <= earlyEnd
+}
+
+// Current returns the latest session active at t, if any.
+func Current(ss []defs.SensorSession, t time.Time) *defs.SensorSession {
+	for i := len(ss) - 1; i >= 0; i-- {
+		if ss[i].Active(t) {
+			return &ss[i]
+		}
+	}
+	return nil
+}
+
+// Lifetime summarizes how long the sensors lasted.
+type Lifetime struct {
+	Count    int
+	Average  time.Duration
+	Shortest time.Duration
+	Longest  time.Duration
+	Early    int // Ended at least a day before they were due to expire.
+}
+
+// Lifetimes summarizes the sessions that are over at t.
+func Lifetimes(ss []defs.SensorSession, t time.Time) Lifetime {
+	var l Lifetime
+	var total time.Duration
+	for _, s := range ss {
+		end := s.End()
+		if end.After(t) {
+			continue
+		}
+
+		lasted := end.Sub(s.Time)
+		if l.Count == 0 || lasted < l.Shortest {
+			l.Shortest = lasted
+		}
+		if lasted > l.Longest {
+			l.Longest = lasted
+		}
+		if s.Expiry.Sub(end) >= earlyEnd {
+			l.Early++
+		}
+		total += lasted
+		l.Count++
+	}
+
+	if l.Count > 0 {
+		l.Average = total / time.Duration(l.Count)
+	}
+	return l
+}
+
+// Split groups the readings (sorted by time) by the session they were
+// taken with, in the order of the sessions. Readings outside of any session
+// are left out.
+func Split(glucose []defs.TransformedReading, ss []defs.SensorSession) [][]defs.TransformedReading {
+	split := make([][]defs.TransformedReading, len(ss))
+	for i, s := range ss {
+		// A later session takes over from an earlier one that was not
+		// ended when it started.
+		end := s.End()
+		if i+1 < len(ss) && ss[i+1].Time.Before(end) {
+			end = ss[i+1].Time
+		}
+
+		split[i] = make([]defs.TransformedReading, 0)
+		for _, tr := range glucose {
+			if !tr.Time.Before(s.Time) && tr.Time.Before(end) {
+				split[i] = append(split[i], tr)
+			}
+		}
+	}
+	return split
+}
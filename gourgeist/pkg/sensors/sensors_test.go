@@ -0,0 +1,100 @@
+package sensors
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SensorsTestSuite struct {
+	suite.Suite
+	start time.Time
+}
+
+func TestSensorsTestSuite(t *testing.T) {
+	suite.Run(t, new(SensorsTestSuite))
+}
+
+func (suite *SensorsTestSuite) SetupTest() {
+	suite.start = time.Date(2022, time.May, 12, 12, 0, 0, 0, time.UTC)
+}
+
+func (suite *SensorsTestSuite) session(days, lifetime, ended int) defs.SensorSession {
+	s := defs.SensorSession{
+		Time:   suite.start.AddDate(0, 0, days),
+		Expiry: suite.start.AddDate(0, 0, days+lifetime),
+	}
+	if ended > 0 {
+		s.EndedAt = suite.start.AddDate(0, 0, days+ended)
+	}
+	return s
+}
+
+func (suite *SensorsTestSuite) TestDetect() {
+	var glucose []defs.TransformedReading
+	for _, minutes := range []int{0, 5, 10, 140, 145, 150, 250, 255, 600} {
+		glucose = append(glucose, defs.TransformedReading{
+			Time: suite.start.Add(time.Duration(minutes) * time.Minute),
+		})
+	}
+
+	inserted := Detect(glucose, 2*time.Hour)
+	assert.Equal(suite.T(), []time.Time{suite.start.Add(20 * time.Minute)}, inserted,
+		"only the gap of about the warmup is a new sensor, not shorter or longer ones")
+	assert.Empty(suite.T(), Detect(glucose[:3], 2*time.Hour))
+}
+
+func (suite *SensorsTestSuite) TestDue() {
+	ss := []defs.SensorSession{suite.session(0, 10, 0)}
+
+	assert.False(suite.T(), Due(ss, suite.start.AddDate(0, 0, 5)), "a gap mid-session is not a new sensor")
+	assert.True(suite.T(), Due(ss, suite.start.AddDate(0, 0, 9).Add(time.Hour)))
+	assert.True(suite.T(), Due(ss, suite.start.AddDate(0, 0, 11)), "past the expiry")
+	assert.True(suite.T(), Due(nil, suite.start))
+}
+
+func (suite *SensorsTestSuite) TestCurrent() {
+	ss := []defs.SensorSession{suite.session(0, 10, 0), suite.session(10, 10, 5)}
+
+	assert.Equal(suite.T(), &ss[0], Current(ss, suite.start.AddDate(0, 0, 9)))
+	assert.Equal(suite.T(), &ss[1], Current(ss, suite.start.AddDate(0, 0, 12)))
+	assert.Nil(suite.T(), Current(ss, suite.start.AddDate(0, 0, 16)), "ended early")
+	assert.Nil(suite.T(), Current(ss, suite.start.Add(-time.Hour)))
+}
+
+func (suite *SensorsTestSuite) TestLifetimes() {
+	ss := []defs.SensorSession{
+		suite.session(0, 10, 0),
+		suite.session(10, 10, 6),
+		suite.session(16, 10, 0), // Still active.
+	}
+
+	l := Lifetimes(ss, suite.start.AddDate(0, 0, 18))
+	assert.Equal(suite.T(), 2, l.Count)
+	assert.Equal(suite.T(), 1, l.Early)
+	assert.Equal(suite.T(), 6*24*time.Hour, l.Shortest)
+	assert.Equal(suite.T(), 10*24*time.Hour, l.Longest)
+	assert.Equal(suite.T(), 8*24*time.Hour, l.Average)
+
+	assert.Equal(suite.T(), Lifetime{}, Lifetimes(nil, suite.start))
+}
+
+func (suite *SensorsTestSuite) TestSplit() {
+	// The first session is never ended, and is taken over by the second.
+	ss := []defs.SensorSession{suite.session(0, 10, 0), suite.session(5, 10, 0)}
+	glucose := []defs.TransformedReading{
+		{Time: suite.start.Add(-time.Hour), Mmol: 1},
+		{Time: suite.start, Mmol: 2},
+		{Time: suite.start.AddDate(0, 0, 4), Mmol: 3},
+		{Time: suite.start.AddDate(0, 0, 6), Mmol: 4},
+		{Time: suite.start.AddDate(0, 0, 16), Mmol: 5},
+	}
+
+	split := Split(glucose, ss)
+	assert.Len(suite.T(), split, 2)
+	assert.Equal(suite.T(), glucose[1:3], split[0])
+	assert.Equal(suite.T(), glucose[3:4], split[1])
+}
@@ -0,0 +1,151 @@
+package slack
+
+import (
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"iv2/gourgeist/pkg/textcmd"
+	"strconv"
+	"strings"
+)
+
+// Limits of Block Kit.
+const (
+	headerLimit  = 150
+	textLimit    = 3000
+	fieldLimit   = 2000
+	fieldsLimit  = 10
+	buttonsLimit = 5 // Per row, as on Discord.
+)
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func plain(s string, limit int) *text {
+	return &text{Type: "plain_text", Text: truncate(s, limit)}
+}
+
+func mrkdwn(s string, limit int) *text {
+	return &text{Type: "mrkdwn", Text: truncate(s, limit)}
+}
+
+type slackFile struct {
+	ID string `json:"id"`
+}
+
+type element struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text"`
+	ActionID string `json:"action_id"`
+	Value    string `json:"value"`
+	Style    string `json:"style,omitempty"`
+}
+
+type block struct {
+	Type      string     `json:"type"`
+	Text      *text      `json:"text,omitempty"`
+	Fields    []*text    `json:"fields,omitempty"`
+	Elements  []element  `json:"elements,omitempty"`
+	SlackFile *slackFile `json:"slack_file,omitempty"`
+	AltText   string     `json:"alt_text,omitempty"`
+}
+
+// marshalBlocks transforms data of type defs.MessageData to Block Kit
+// blocks, where each embed is a header followed by its description and
+// fields. Embed images are shown from the uploaded files, by name.
+func marshalBlocks(data defs.MessageData, files map[string]string) []block {
+	blocks := make([]block, 0)
+	if data.Content != "" {
+		content := markdown(data.Content, data.MentionEveryone)
+		blocks = append(blocks, block{Type: "section", Text: mrkdwn(content, textLimit)})
+	}
+
+	for _, embed := range data.Embeds {
+		if embed.Title != "" {
+			blocks = append(blocks, block{Type: "header", Text: plain(embed.Title, headerLimit)})
+		}
+		if embed.Description != "" {
+			description := markdown(embed.Description, false)
+			blocks = append(blocks, block{Type: "section", Text: mrkdwn(description, textLimit)})
+		}
+
+		fields := make([]*text, 0)
+		for _, field := range display.Fields(embed) {
+			fields = append(fields, mrkdwn("*"+field.Name+"*\n"+markdown(field.Value, false), fieldLimit))
+		}
+		for i := 0; i < len(fields); i += fieldsLimit {
+			end := i + fieldsLimit
+			if end > len(fields) {
+				end = len(fields)
+			}
+			blocks = append(blocks, block{Type: "section", Fields: fields[i:end]})
+		}
+
+		if embed.Image != nil {
+			if id, ok := files[embed.Image.Filename]; ok {
+				blocks = append(blocks, block{
+					Type:      "image",
+					SlackFile: &slackFile{ID: id},
+					AltText:   embed.Image.Filename,
+				})
+			}
+		}
+	}
+
+	for i := 0; i < len(data.Buttons); i += buttonsLimit {
+		end := i + buttonsLimit
+		if end > len(data.Buttons) {
+			end = len(data.Buttons)
+		}
+
+		elements := make([]element, 0)
+		for j, button := range data.Buttons[i:end] {
+			el := element{
+				Type:     "button",
+				Text:     plain(button.Label, 75),
+				ActionID: "button-" + strconv.Itoa(i+j),
+				Value:    textcmd.EncodeCommand(button.Command),
+			}
+			switch button.Style {
+			case defs.PrimaryButton:
+				el.Style = "primary"
+			case defs.DangerButton:
+				el.Style = "danger"
+			}
+			elements = append(elements, el)
+		}
+		blocks = append(blocks, block{Type: "actions", Elements: elements})
+	}
+
+	return blocks
+}
+
+// fallback is the text shown in notifications, where blocks are not.
+func fallback(data defs.MessageData) string {
+	if data.Content != "" {
+		return truncate(markdown(data.Content, data.MentionEveryone), textLimit)
+	}
+	for _, embed := range data.Embeds {
+		if embed.Title != "" {
+			return embed.Title
+		}
+	}
+	return "iv2"
+}
+
+// markdown converts the markdown of Discord to the mrkdwn of Slack.
+func markdown(s string, mention bool) string {
+	s = display.Bold(s, "*$1*")
+	if mention {
+		s = strings.ReplaceAll(s, "@everyone", "<!channel>")
+	}
+	return s
+}
+
+func truncate(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit-1]) + "…"
+	}
+	return s
+}
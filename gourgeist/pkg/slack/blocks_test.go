@@ -0,0 +1,82 @@
+package slack
+
+import (
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type BlocksTestSuite struct {
+	suite.Suite
+}
+
+func TestBlocksTestSuite(t *testing.T) {
+	suite.Run(t, new(BlocksTestSuite))
+}
+
+func (suite *BlocksTestSuite) TestMarshalBlocks() {
+	cmd := defs.CommandInteraction{
+		Name:    defs.SnoozeCmd,
+		Options: []defs.CommandInteractionOption{{Name: "minutes", Value: "30"}},
+	}
+	data := defs.MessageData{
+		Content: "**low**",
+		Embeds: []defs.EmbedData{{
+			Title:       "Low glucose",
+			Description: "current value: 3.10",
+			Fields:      []defs.EmbedField{{Name: "Delta", Value: "-0.4"}, defs.EmptyEmbed()},
+			Image:       &defs.ImageData{Filename: "daily.png"},
+		}},
+		Buttons: []defs.ButtonData{
+			{Label: "Snooze", Style: defs.DangerButton, Command: cmd},
+			{Label: "Details", Style: defs.SecondaryButton, Command: cmd},
+		},
+	}
+
+	blocks := marshalBlocks(data, map[string]string{"daily.png": "F1"})
+	assert.Equal(suite.T(), []block{
+		{Type: "section", Text: &text{Type: "mrkdwn", Text: "*low*"}},
+		{Type: "header", Text: &text{Type: "plain_text", Text: "Low glucose"}},
+		{Type: "section", Text: &text{Type: "mrkdwn", Text: "current value: 3.10"}},
+		{Type: "section", Fields: []*text{{Type: "mrkdwn", Text: "*Delta*\n-0.4"}}},
+		{Type: "image", SlackFile: &slackFile{ID: "F1"}, AltText: "daily.png"},
+		{Type: "actions", Elements: []element{
+			{
+				Type: "button", Text: &text{Type: "plain_text", Text: "Snooze"}, ActionID: "button-0",
+				Value: textcmd.EncodeCommand(cmd), Style: "danger",
+			},
+			{
+				Type: "button", Text: &text{Type: "plain_text", Text: "Details"}, ActionID: "button-1",
+				Value: textcmd.EncodeCommand(cmd),
+			},
+		}},
+	}, blocks)
+
+	assert.Len(suite.T(), marshalBlocks(data, nil), 5, "image not uploaded")
+	assert.Equal(suite.T(), "*low*", fallback(data))
+	assert.Equal(suite.T(), "Low glucose", fallback(defs.MessageData{Embeds: data.Embeds}))
+}
+
+func (suite *BlocksTestSuite) TestLimits() {
+	fields := make([]defs.EmbedField, 12)
+	buttons := make([]defs.ButtonData, 7)
+	for i := range fields {
+		fields[i] = defs.EmbedField{Name: strconv.Itoa(i), Value: "v"}
+	}
+	data := defs.MessageData{
+		Embeds:  []defs.EmbedData{{Title: string(make([]rune, 200)), Fields: fields}},
+		Buttons: buttons,
+	}
+
+	blocks := marshalBlocks(data, nil)
+	assert.Len(suite.T(), blocks, 5)
+	assert.Len(suite.T(), []rune(blocks[0].Text.Text), headerLimit)
+	assert.Len(suite.T(), blocks[1].Fields, fieldsLimit)
+	assert.Len(suite.T(), blocks[2].Fields, 2)
+	assert.Len(suite.T(), blocks[3].Elements, buttonsLimit)
+	assert.Len(suite.T(), blocks[4].Elements, 2)
+}
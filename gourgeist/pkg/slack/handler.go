@@ -0,0 +1,133 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	commandsPath     = "/slack/commands"
+	interactionsPath = "/slack/interactions"
+
+	signatureHeader = "X-Slack-Signature"
+	timestampHeader = "X-Slack-Request-Timestamp"
+
+	// maxSkew is how old a request can be, against replays.
+	maxSkew = 5 * time.Minute
+)
+
+// Handler serves the slash commands and the button clicks of the app, which
+// are to be configured at /slack/commands and /slack/interactions.
+func (s *Slack) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(commandsPath, s.verified(s.handleCommand))
+	mux.HandleFunc(interactionsPath, s.verified(s.handleInteraction))
+	return mux
+}
+
+// handleCommand parses the slash command as the Discord command of the same
+// name. Slack only waits 3 seconds for the response, so the command is
+// handled in the background and replied to through the response URL.
+func (s *Slack) handleCommand(w http.ResponseWriter, r *http.Request) {
+	ci, err := textcmd.Parse(r.PostForm.Get("command")+" "+r.PostForm.Get("text"), defs.Commands)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"response_type": "ephemeral",
+			"text":          err.Error(),
+		})
+		return
+	}
+
+	ei := defs.EventInfo{
+		User:  r.PostForm.Get("user_name"),
+		Token: r.PostForm.Get("response_url"),
+	}
+	go s.handler(ei, ci)
+}
+
+func (s *Slack) handleInteraction(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		ResponseURL string `json:"response_url"`
+		Actions     []struct {
+			Value string `json:"value"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Type != "block_actions" {
+		return
+	}
+
+	ei := defs.EventInfo{User: payload.User.Username, Token: payload.ResponseURL}
+	for _, action := range payload.Actions {
+		go s.handler(ei, textcmd.DecodeCommand(action.Value))
+	}
+}
+
+// verified only passes on the requests signed by Slack, parsing their form.
+func (s *Slack) verified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unable to read body", http.StatusBadRequest)
+			return
+		}
+
+		ts := r.Header.Get(timestampHeader)
+		if !s.verify(ts, body, r.Header.Get(signatureHeader), time.Now()) {
+			s.Logger.Debug("rejected unsigned slack request", zap.String("path", r.URL.Path))
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// verify reports whether the signature is that of the timestamp and body,
+// and that the timestamp is recent.
+func (s *Slack) verify(ts string, body []byte, signature string, now time.Time) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	return hmac.Equal([]byte(sign(s.secret, ts, body)), []byte(signature))
+}
+
+func sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,380 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	webAPI      = "https://slack.com/api"
+	mainChannel = "iv2"
+	listLimit   = 200
+	timeout     = 10 * time.Second
+)
+
+// Slack displays iv2 in a Slack workspace, as the Discord display does in a
+// guild. The slash commands and buttons of the app are served by Handler.
+type Slack struct {
+	Logger   *zap.Logger
+	Location *time.Location
+
+	token  string
+	secret string
+	addr   string
+	api    string
+	client *http.Client
+
+	channels map[string]string // Channel IDs by name.
+	handler  defs.CommandInteractionHandler
+
+	// Slack has no way to read back the blocks as they were sent, so the
+	// main message is cached along with the IDs of its images.
+	mu        sync.Mutex
+	mainTS    string
+	main      display.Main
+	mainFiles map[string]string
+}
+
+func New(cfg defs.SlackConfig, logger *zap.Logger, loc *time.Location) (*Slack, error) {
+	return newSlack(cfg, webAPI, logger, loc)
+}
+
+func newSlack(cfg defs.SlackConfig, api string, logger *zap.Logger, loc *time.Location) (*Slack, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("missing token")
+	}
+	if cfg.SigningSecret == "" {
+		return nil, errors.New("missing signing secret")
+	}
+
+	s := &Slack{
+		Logger:   logger,
+		Location: loc,
+		token:    cfg.Token,
+		secret:   cfg.SigningSecret,
+		addr:     cfg.Address,
+		api:      strings.TrimSuffix(api, "/"),
+		client:   &http.Client{Timeout: timeout},
+	}
+	if s.addr == "" {
+		s.addr = defs.DefaultSlackAddress
+	}
+
+	if err := s.call("auth.test", url.Values{}, nil); err != nil {
+		return nil, fmt.Errorf("unable to authenticate: %w", err)
+	}
+	return s, nil
+}
+
+// Setup joins the channels, creating the missing ones, and starts serving
+// the commands. The commands themselves are registered in the manifest of
+// the app, as Slack has no API for it.
+func (s *Slack) Setup(channels []string, cmdHandler defs.CommandInteractionHandler) error {
+	type channel struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		IsMember bool   `json:"is_member"`
+	}
+
+	existing := make(map[string]channel)
+	var cursor string
+	for {
+		var resp struct {
+			Channels []channel `json:"channels"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		params := url.Values{
+			"types":            {"public_channel"},
+			"exclude_archived": {"true"},
+			"limit":            {strconv.Itoa(listLimit)},
+			"cursor":           {cursor},
+		}
+		if err := s.call("conversations.list", params, &resp); err != nil {
+			return fmt.Errorf("unable to get channels: %w", err)
+		}
+		for _, ch := range resp.Channels {
+			existing[ch.Name] = ch
+		}
+		if cursor = resp.Metadata.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	s.channels = make(map[string]string)
+	for _, chName := range append(channels, mainChannel) {
+		ch, ok := existing[chName]
+		switch {
+		case !ok:
+			s.Logger.Debug("creating channel", zap.String("channel name", chName))
+			var resp struct {
+				Channel channel `json:"channel"`
+			}
+			if err := s.call("conversations.create", url.Values{"name": {chName}}, &resp); err != nil {
+				return fmt.Errorf("unable to create channel %s: %w", chName, err)
+			}
+			ch = resp.Channel
+		case !ch.IsMember:
+			if err := s.call("conversations.join", url.Values{"channel": {ch.ID}}, nil); err != nil {
+				return fmt.Errorf("unable to join channel %s: %w", chName, err)
+			}
+		}
+		s.channels[chName] = ch.ID
+	}
+
+	s.handler = cmdHandler
+	go func() {
+		if err := http.ListenAndServe(s.addr, s.Handler()); err != nil {
+			s.Logger.Error("unable to serve slack requests", zap.Error(err))
+		}
+	}()
+
+	s.Logger.Debug("slack setup complete")
+	return nil
+}
+
+func (s *Slack) SendMessage(data defs.MessageData, chName string) (uint64, error) {
+	chID, ok := s.channels[chName]
+	if !ok {
+		return 0, fmt.Errorf("unknown channel: %s", chName)
+	}
+
+	ts, _, err := s.post(data, chID)
+	if err != nil {
+		return 0, err
+	}
+	s.Logger.Debug("sent message", zap.String("channel name", chName))
+	return messageID(ts), nil
+}
+
+func (s *Slack) SendDirectMessage(_ defs.MessageData, _ uint64) (uint64, error) {
+	return 0, display.ErrNoDirectMessages
+}
+
+func (s *Slack) GetMainMessage() (*defs.MessageData, error) {
+	return s.main.Get()
+}
+
+func (s *Slack) NewMainMessage(data defs.MessageData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newMainMessage(data)
+}
+
+func (s *Slack) newMainMessage(data defs.MessageData) error {
+	chID := s.channels[mainChannel]
+	if s.mainTS != "" {
+		if err := s.call("chat.delete", url.Values{"channel": {chID}, "ts": {s.mainTS}}, nil); err != nil {
+			return fmt.Errorf("unable to delete message: %w", err)
+		}
+	}
+
+	ts, files, err := s.post(data, chID)
+	if err != nil {
+		return err
+	}
+	s.mainTS, s.mainFiles = ts, files
+	s.main.Set(data)
+	return nil
+}
+
+// UpdateMainMessage edits the main message in place, keeping the images of
+// the message it replaces.
+func (s *Slack) UpdateMainMessage(data defs.MessageData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mainTS == "" {
+		return s.newMainMessage(data)
+	}
+
+	blocks, err := json.Marshal(marshalBlocks(data, s.mainFiles))
+	if err != nil {
+		return err
+	}
+	params := url.Values{
+		"channel": {s.channels[mainChannel]},
+		"ts":      {s.mainTS},
+		"text":    {fallback(data)},
+		"blocks":  {string(blocks)},
+	}
+	if err := s.call("chat.update", params, nil); err != nil {
+		return fmt.Errorf("unable to update message: %w", err)
+	}
+	s.main.Set(data)
+	return nil
+}
+
+// RespondInteraction replies to the user of the command only. Slack has no
+// interaction IDs, the token being the response URL of the interaction.
+func (s *Slack) RespondInteraction(_ uint64, token string, resp defs.InteractionResponse) error {
+	body, err := json.Marshal(struct {
+		ResponseType    string  `json:"response_type"`
+		ReplaceOriginal bool    `json:"replace_original"`
+		Text            string  `json:"text"`
+		Blocks          []block `json:"blocks"`
+	}{
+		ResponseType: "ephemeral",
+		Text:         fallback(resp.Data),
+		Blocks:       marshalBlocks(resp.Data, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Post(token, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// DeleteInteractionResponse does nothing, as the responses are only seen by
+// the user of the command and are gone once the channel is reloaded.
+func (s *Slack) DeleteInteractionResponse(_ uint64, _ string) error {
+	return nil
+}
+
+// post uploads the files and sends the message, returning its timestamp and
+// the IDs of the files by name.
+func (s *Slack) post(data defs.MessageData, chID string) (string, map[string]string, error) {
+	images := make(map[string]bool)
+	for _, embed := range data.Embeds {
+		if embed.Image != nil {
+			images[embed.Image.Filename] = true
+		}
+	}
+
+	files := make(map[string]string)
+	for _, f := range data.Files {
+		// Images are shown in the blocks, the other files are shared as is.
+		var share string
+		if !images[f.Name] {
+			share = chID
+		}
+		id, err := s.upload(f, share)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to upload %s: %w", f.Name, err)
+		}
+		files[f.Name] = id
+	}
+
+	blocks, err := json.Marshal(marshalBlocks(data, files))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	params := url.Values{
+		"channel": {chID},
+		"text":    {fallback(data)},
+		"blocks":  {string(blocks)},
+	}
+	if err := s.call("chat.postMessage", params, &resp); err != nil {
+		return "", nil, fmt.Errorf("unable to send message: %w", err)
+	}
+	return resp.TS, files, nil
+}
+
+// upload uploads the file, sharing it to the channel if given.
+func (s *Slack) upload(f defs.FileData, chID string) (string, error) {
+	b, err := ioutil.ReadAll(f.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	var ur struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	params := url.Values{"filename": {f.Name}, "length": {strconv.Itoa(len(b))}}
+	if err := s.call("files.getUploadURLExternal", params, &ur); err != nil {
+		return "", err
+	}
+
+	res, err := s.client.Post(ur.UploadURL, "application/octet-stream", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
+	completed, err := json.Marshal([]map[string]string{{"id": ur.FileID, "title": f.Name}})
+	if err != nil {
+		return "", err
+	}
+	params = url.Values{"files": {string(completed)}}
+	if chID != "" {
+		params.Set("channel_id", chID)
+	}
+	if err := s.call("files.completeUploadExternal", params, nil); err != nil {
+		return "", err
+	}
+	return ur.FileID, nil
+}
+
+// call calls a method of the Web API, decoding the response into v if
+// given.
+func (s *Slack) call(method string, params url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, s.api+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("unable to decode %s response: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s: %s", method, status.Error)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// messageID packs the timestamp of a message, which is its ID on Slack, into
+// a number, e.g. 1652324400.000200 to 1652324400000200.
+func messageID(ts string) uint64 {
+	sec, micro, _ := strings.Cut(ts, ".")
+	id, _ := strconv.ParseUint(sec+micro, 10, 64)
+	return id
+}
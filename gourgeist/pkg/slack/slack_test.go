@@ -0,0 +1,297 @@
+package slack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// call is a call to the stub Web API.
+type call struct {
+	method string
+	params url.Values
+}
+
+// stub is the Web API of a workspace with a single channel.
+type stub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []call
+	uploads map[string]string // File contents by ID.
+	replies []map[string]interface{}
+}
+
+func newStub() *stub {
+	st := &stub{uploads: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", st.handleAPI)
+	mux.HandleFunc("/upload/", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		st.mu.Lock()
+		st.uploads[strings.TrimPrefix(r.URL.Path, "/upload/")] = string(b)
+		st.mu.Unlock()
+	})
+	mux.HandleFunc("/response", func(w http.ResponseWriter, r *http.Request) {
+		var reply map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&reply)
+		st.mu.Lock()
+		st.replies = append(st.replies, reply)
+		st.mu.Unlock()
+	})
+	st.Server = httptest.NewServer(mux)
+	return st
+}
+
+func (st *stub) handleAPI(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	st.mu.Lock()
+	st.calls = append(st.calls, call{method: method, params: r.PostForm})
+	n := len(st.calls)
+	st.mu.Unlock()
+
+	resp := map[string]interface{}{"ok": r.Header.Get("Authorization") == "Bearer xoxb-test"}
+	switch method {
+	case "conversations.list":
+		resp["channels"] = []map[string]interface{}{{"id": "C1", "name": "alerts", "is_member": false}}
+	case "conversations.create":
+		resp["channel"] = map[string]interface{}{"id": "C-" + r.PostForm.Get("name")}
+	case "chat.postMessage":
+		resp["ts"] = "1652324400.0000" + strconv.Itoa(10+n)
+	case "files.getUploadURLExternal":
+		id := "F" + strconv.Itoa(n)
+		resp["file_id"] = id
+		resp["upload_url"] = st.URL + "/upload/" + id
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// methods returns the methods called, in order.
+func (st *stub) methods() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ms := make([]string, len(st.calls))
+	for i, c := range st.calls {
+		ms[i] = c.method
+	}
+	return ms
+}
+
+func (st *stub) last(method string) url.Values {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := len(st.calls) - 1; i >= 0; i-- {
+		if st.calls[i].method == method {
+			return st.calls[i].params
+		}
+	}
+	return nil
+}
+
+type SlackTestSuite struct {
+	suite.Suite
+	stub  *stub
+	slack *Slack
+
+	mu       sync.Mutex
+	commands []defs.CommandInteraction
+	events   []defs.EventInfo
+}
+
+func TestSlackTestSuite(t *testing.T) {
+	suite.Run(t, new(SlackTestSuite))
+}
+
+func (suite *SlackTestSuite) SetupTest() {
+	suite.stub = newStub()
+	suite.T().Cleanup(suite.stub.Close)
+	suite.commands, suite.events = nil, nil
+
+	cfg := defs.SlackConfig{Token: "xoxb-test", SigningSecret: "secret", Address: "127.0.0.1:0"}
+	s, err := newSlack(cfg, suite.stub.URL+"/api", zap.NewNop(), time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.slack = s
+
+	err = s.Setup([]string{defs.AlertsChannel}, func(e defs.EventInfo, ci defs.CommandInteraction) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.events = append(suite.events, e)
+		suite.commands = append(suite.commands, ci)
+	})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *SlackTestSuite) TestNew() {
+	api := suite.stub.URL + "/api"
+	_, err := newSlack(defs.SlackConfig{Token: "xoxb-wrong", SigningSecret: "secret"}, api, nil, nil)
+	assert.Error(suite.T(), err)
+	_, err = newSlack(defs.SlackConfig{Token: "xoxb-test"}, api, nil, nil)
+	assert.Error(suite.T(), err, "no signing secret")
+}
+
+func (suite *SlackTestSuite) TestSetup() {
+	channels := map[string]string{defs.AlertsChannel: "C1", mainChannel: "C-iv2"}
+	assert.Equal(suite.T(), channels, suite.slack.channels)
+	assert.Equal(suite.T(), []string{
+		"auth.test", "conversations.list", "conversations.join", "conversations.create",
+	}, suite.stub.methods())
+	assert.Equal(suite.T(), "C1", suite.stub.last("conversations.join").Get("channel"))
+}
+
+func (suite *SlackTestSuite) TestSendMessage() {
+	id, err := suite.slack.SendMessage(defs.MessageData{Content: "**low** @everyone", MentionEveryone: true},
+		defs.AlertsChannel)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint64(1652324400000015), id)
+
+	params := suite.stub.last("chat.postMessage")
+	assert.Equal(suite.T(), "C1", params.Get("channel"))
+	assert.Equal(suite.T(), "*low* <!channel>", params.Get("text"))
+
+	_, err = suite.slack.SendMessage(defs.MessageData{Content: "low"}, "unknown")
+	assert.Error(suite.T(), err)
+	_, err = suite.slack.SendDirectMessage(defs.MessageData{Content: "low"}, 1)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *SlackTestSuite) TestMainMessage() {
+	_, err := suite.slack.GetMainMessage()
+	assert.Error(suite.T(), err, "no main message yet")
+
+	data := defs.MessageData{
+		Embeds: []defs.EmbedData{{
+			Title:  "5.2 mmol/L",
+			Fields: []defs.EmbedField{{Name: "Delta", Value: "+0.1"}},
+			Image:  &defs.ImageData{Filename: "daily.png"},
+		}},
+		Files: []defs.FileData{{Name: "daily.png", Reader: strings.NewReader("\x89PNG")}},
+	}
+	assert.NoError(suite.T(), suite.slack.NewMainMessage(data))
+	assert.Equal(suite.T(), "\x89PNG", suite.stub.uploads["F5"])
+	assert.Empty(suite.T(), suite.stub.last("files.completeUploadExternal").Get("channel_id"),
+		"images are not shared")
+
+	msg, err := suite.slack.GetMainMessage()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "5.2 mmol/L", msg.Embeds[0].Title)
+	assert.Empty(suite.T(), msg.Files)
+
+	msg.Embeds[0].Fields[0].Value = "+0.3"
+	assert.NoError(suite.T(), suite.slack.UpdateMainMessage(*msg))
+
+	params := suite.stub.last("chat.update")
+	assert.Equal(suite.T(), "C-iv2", params.Get("channel"))
+	assert.Contains(suite.T(), params.Get("blocks"), "+0.3")
+	assert.Contains(suite.T(), params.Get("blocks"), `"slack_file":{"id":"F5"}`, "image kept")
+
+	assert.NoError(suite.T(), suite.slack.NewMainMessage(defs.MessageData{Content: "new"}))
+	assert.Equal(suite.T(), "1652324400.000017", suite.stub.last("chat.delete").Get("ts"))
+}
+
+func (suite *SlackTestSuite) TestRespondInteraction() {
+	resp := defs.InteractionResponse{Type: defs.MessageInteraction, Data: defs.MessageData{Content: "received"}}
+	assert.NoError(suite.T(), suite.slack.RespondInteraction(0, suite.stub.URL+"/response", resp))
+
+	assert.Len(suite.T(), suite.stub.replies, 1)
+	assert.Equal(suite.T(), "ephemeral", suite.stub.replies[0]["response_type"])
+	assert.Equal(suite.T(), "received", suite.stub.replies[0]["text"])
+}
+
+// request signs the form as Slack does, at the given time.
+func (suite *SlackTestSuite) request(path string, form url.Values, at time.Time) *httptest.ResponseRecorder {
+	body := form.Encode()
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(timestampHeader, ts)
+	r.Header.Set(signatureHeader, sign("secret", ts, []byte(body)))
+
+	w := httptest.NewRecorder()
+	suite.slack.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// handled waits for the commands dispatched in the background.
+func (suite *SlackTestSuite) handled(n int) []defs.CommandInteraction {
+	assert.Eventually(suite.T(), func() bool {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		return len(suite.commands) == n
+	}, time.Second, 10*time.Millisecond)
+
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.commands
+}
+
+func (suite *SlackTestSuite) TestCommand() {
+	form := url.Values{
+		"command":      {"/carbs"},
+		"text":         {"30 slow"},
+		"user_name":    {"grandma"},
+		"response_url": {suite.stub.URL + "/response"},
+	}
+	w := suite.request(commandsPath, form, time.Now())
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	cmds := suite.handled(1)
+	assert.Equal(suite.T(), defs.CommandInteraction{
+		Name: defs.AddCarbsCmd,
+		Options: []defs.CommandInteractionOption{
+			{Name: "amount", Value: "30"}, {Name: "absorption", Value: "240"},
+		},
+	}, cmds[0])
+	assert.Equal(suite.T(), "grandma", suite.events[0].User)
+	assert.Equal(suite.T(), suite.stub.URL+"/response", suite.events[0].Token)
+
+	form.Set("text", "")
+	w = suite.request(commandsPath, form, time.Now())
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "missing amount", "usage is replied")
+	assert.Len(suite.T(), suite.handled(1), 1)
+}
+
+func (suite *SlackTestSuite) TestInteraction() {
+	ci := defs.CommandInteraction{
+		Name:    defs.SnoozeCmd,
+		Options: []defs.CommandInteractionOption{{Name: "minutes", Value: "30"}},
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"username": "grandpa"},
+		"response_url": suite.stub.URL + "/response",
+		"actions":      []map[string]string{{"value": textcmd.EncodeCommand(ci)}},
+	})
+	w := suite.request(interactionsPath, url.Values{"payload": {string(payload)}}, time.Now())
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), ci, suite.handled(1)[0])
+}
+
+func (suite *SlackTestSuite) TestVerify() {
+	form := url.Values{"command": {"/carbs"}, "text": {"30"}}
+	w := suite.request(commandsPath, form, time.Now().Add(-10*time.Minute))
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code, "replayed")
+
+	r := httptest.NewRequest(http.MethodPost, commandsPath, strings.NewReader(form.Encode()))
+	r.Header.Set(timestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	r.Header.Set(signatureHeader, "v0=00")
+	w = httptest.NewRecorder()
+	suite.slack.Handler().ServeHTTP(w, r)
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code, "forged")
+	assert.Empty(suite.T(), suite.commands)
+}
@@ -1,7 +1,10 @@
 package stats
 
 import (
+	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/quality"
+	"math"
 	"sort"
 	"time"
 
@@ -15,12 +18,21 @@ type RangeAnalysis struct {
 }
 
 func TimeSpentInRange(trs []defs.TransformedReading, lower, upper float64) RangeAnalysis {
+	return TimeSpentInRangeFunc(trs, func(time.Time) (float64, float64) {
+		return lower, upper
+	})
+}
+
+// TimeSpentInRangeFunc is like TimeSpentInRange, but with the range at the
+// time of each reading given by rangeAt.
+func TimeSpentInRangeFunc(trs []defs.TransformedReading, rangeAt func(time.Time) (float64, float64)) RangeAnalysis {
 	if len(trs) == 0 {
 		return RangeAnalysis{}
 	}
 
 	below, above := 0.0, 0.0
 	for _, tr := range trs {
+		lower, upper := rangeAt(tr.Time)
 		switch {
 		case tr.Mmol <= lower:
 			below++
@@ -53,6 +65,125 @@ func GlucoseSummary(trs []defs.TransformedReading) SummaryStatistics {
 	return SummaryStatistics{Average: avg, Deviation: dev}
 }
 
+// Thresholds of the international consensus on time in ranges, in mmol/L.
+const (
+	VeryLowThreshold  = 3.0
+	LowThreshold      = 3.9
+	HighThreshold     = 10.0
+	VeryHighThreshold = 13.9
+)
+
+// mgdlPerMmol converts glucose from mmol/L to mg/dL.
+const mgdlPerMmol = 18.018
+
+// ReadingInterval is how often the sensor takes a reading.
+const ReadingInterval = 5 * time.Minute
+
+// ConsensusMetrics are the metrics of the international consensus on CGM
+// data. The times in ranges are fractions of the readings, with those
+// below 3.9 including those below 3.0, and likewise above.
+type ConsensusMetrics struct {
+	Readings int     `json:"readings"`
+	Active   float64 `json:"active"` // Fraction of the time the CGM was taking readings.
+	Mean     float64 `json:"mean"`
+	GMI      float64 `json:"gmi"` // Glucose management indicator, in %.
+	CV       float64 `json:"cv"`  // Coefficient of variation, in %.
+	VeryLow  float64 `json:"veryLow"`
+	Low      float64 `json:"low"`
+	InRange  float64 `json:"inRange"`
+	High     float64 `json:"high"`
+	VeryHigh float64 `json:"veryHigh"`
+}
+
+// Consensus computes the consensus metrics of the readings taken between
+// start and end. Suspect readings are left out of the metrics when
+// excludeSuspect is set, but still count towards the time the CGM was active.
+func Consensus(trs []defs.TransformedReading, start, end time.Time, excludeSuspect bool) ConsensusMetrics {
+	trs = between(trs, start, end)
+
+	var cm ConsensusMetrics
+	expected := float64(end.Sub(start) / ReadingInterval)
+	cm.Active = math.Min(float64(len(trs))/math.Max(expected, 1), 1)
+
+	if excludeSuspect {
+		trs = quality.Reliable(trs)
+	}
+
+	cm.Readings = len(trs)
+	for _, tr := range trs {
+		switch {
+		case tr.Mmol < VeryLowThreshold:
+			cm.VeryLow++
+			cm.Low++
+		case tr.Mmol < LowThreshold:
+			cm.Low++
+		case tr.Mmol > VeryHighThreshold:
+			cm.VeryHigh++
+			cm.High++
+		case tr.Mmol > HighThreshold:
+			cm.High++
+		default:
+			cm.InRange++
+		}
+	}
+	if cm.Readings == 0 {
+		return cm
+	}
+
+	total := float64(cm.Readings)
+	cm.VeryLow /= total
+	cm.Low /= total
+	cm.InRange /= total
+	cm.High /= total
+	cm.VeryHigh /= total
+
+	ss := GlucoseSummary(trs)
+	cm.Mean = ss.Average
+	cm.CV = ss.Deviation / ss.Average * 100
+	cm.GMI = GMI(ss.Average)
+	return cm
+}
+
+// GMI estimates the HbA1c (%) from the mean glucose in mmol/L.
+func GMI(mean float64) float64 {
+	return 3.31 + 0.02392*mean*mgdlPerMmol
+}
+
+func between(trs []defs.TransformedReading, start, end time.Time) []defs.TransformedReading {
+	kept := make([]defs.TransformedReading, 0, len(trs))
+	for _, tr := range trs {
+		if !tr.Time.Before(start) && tr.Time.Before(end) {
+			kept = append(kept, tr)
+		}
+	}
+	return kept
+}
+
+// RateOfChange returns the rate of change of the readings in mmol/L/min.
+// The rate is the slope of a least squares fit over all readings, which
+// smooths out the noise between individual sensor readings.
+func RateOfChange(trs []defs.TransformedReading) (float64, error) {
+	if len(trs) < 2 {
+		return 0, fmt.Errorf("not enough readings: %d", len(trs))
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, tr := range trs {
+		x := tr.Time.Sub(trs[0].Time).Minutes()
+		sumX += x
+		sumY += tr.Mmol
+		sumXY += x * tr.Mmol
+		sumXX += x * x
+	}
+
+	n := float64(len(trs))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, fmt.Errorf("readings do not span any time")
+	}
+	return (n*sumXY - sumX*sumY) / denom, nil
+}
+
 // TODO: Add tests.
 
 type IntakeData struct {
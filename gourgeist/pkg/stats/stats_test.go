@@ -32,6 +32,26 @@ func (suite *StatsTestSuite) TestTimeSpentInRange() {
 	assert.Equal(suite.T(), 25.0/100, ra.AboveRange, "above range should match")
 }
 
+func (suite *StatsTestSuite) TestTimeSpentInRangeFunc() {
+	trs := genReadings([]metaReadings{
+		{size: 50, min: 7, max: 7},
+		{size: 50, min: 7, max: 7},
+	}...)
+	mid := trs[50].Time
+
+	// The upper threshold is tightened halfway through.
+	ra := TimeSpentInRangeFunc(trs, func(t time.Time) (float64, float64) {
+		if t.Before(mid) {
+			return 4, 9
+		}
+		return 4, 6
+	})
+
+	assert.Equal(suite.T(), 0.0, ra.BelowRange, "below range should match")
+	assert.Equal(suite.T(), 0.5, ra.InRange, "in range should match")
+	assert.Equal(suite.T(), 0.5, ra.AboveRange, "above range should match")
+}
+
 func (suite *StatsTestSuite) TestSummaryStatistics() {
 	trs := genReadings([]metaReadings{
 		{size: 100, min: 6, max: 6},
@@ -42,6 +62,70 @@ func (suite *StatsTestSuite) TestSummaryStatistics() {
 	assert.Equal(suite.T(), ss.Deviation, float64(0), "deviations do not equal")
 }
 
+func (suite *StatsTestSuite) TestRateOfChange() {
+	now := time.Now()
+	trs := make([]defs.TransformedReading, 0)
+	for i := 0; i < 4; i++ {
+		trs = append(trs, defs.TransformedReading{
+			Time: now.Add(time.Duration(i*5) * time.Minute),
+			Mmol: 8 - float64(i)*1,
+		})
+	}
+
+	roc, err := RateOfChange(trs)
+	assert.NoError(suite.T(), err)
+	assert.InDelta(suite.T(), -0.2, roc, 1e-9, "rate of change does not match")
+
+	_, err = RateOfChange(trs[:1])
+	assert.Error(suite.T(), err, "expected error with a single reading")
+}
+
+func (suite *StatsTestSuite) TestConsensus() {
+	trs := genReadings([]metaReadings{
+		{size: 2, min: 2.5, max: 2.5},
+		{size: 3, min: 3.5, max: 3.5},
+		{size: 70, min: 7, max: 7},
+		{size: 15, min: 12, max: 12},
+		{size: 10, min: 15, max: 15},
+	}...)
+	start := trs[0].Time
+
+	// The readings span half of the two days they are taken over.
+	cm := Consensus(trs, start, start.Add(2*100*ReadingInterval), false)
+	assert.Equal(suite.T(), 100, cm.Readings)
+	assert.InDelta(suite.T(), 0.5, cm.Active, 1e-9)
+	assert.InDelta(suite.T(), 0.02, cm.VeryLow, 1e-9)
+	assert.InDelta(suite.T(), 0.05, cm.Low, 1e-9, "below 3.9 includes below 3.0")
+	assert.InDelta(suite.T(), 0.70, cm.InRange, 1e-9)
+	assert.InDelta(suite.T(), 0.25, cm.High, 1e-9, "above 10 includes above 13.9")
+	assert.InDelta(suite.T(), 0.10, cm.VeryHigh, 1e-9)
+
+	mean := (2*2.5 + 3*3.5 + 70*7 + 15*12 + 10*15) / 100
+	assert.InDelta(suite.T(), mean, cm.Mean, 1e-9)
+	assert.InDelta(suite.T(), GlucoseSummary(trs).Deviation/mean*100, cm.CV, 1e-9)
+	assert.InDelta(suite.T(), GMI(mean), cm.GMI, 1e-9)
+
+	cm = Consensus(trs, start.Add(-time.Hour), start.Add(50*ReadingInterval), false)
+	assert.Equal(suite.T(), 50, cm.Readings, "readings outside the period are left out")
+	assert.InDelta(suite.T(), 50.0/62, cm.Active, 1e-9, "gaps at the start lower the active time")
+	assert.Zero(suite.T(), Consensus(nil, start, start.Add(time.Hour), false).Active)
+
+	// Suspect readings are left out of the metrics, but not out of the active time.
+	for i := 0; i < 5; i++ {
+		trs[i].Suspect = defs.CompressionSuspect
+	}
+	cm = Consensus(trs, start, start.Add(2*100*ReadingInterval), true)
+	assert.Equal(suite.T(), 95, cm.Readings)
+	assert.InDelta(suite.T(), 0.5, cm.Active, 1e-9)
+	assert.Zero(suite.T(), cm.VeryLow)
+	assert.Zero(suite.T(), cm.Low)
+}
+
+func (suite *StatsTestSuite) TestGMI() {
+	// 154 mg/dL is a GMI of 7%.
+	assert.InDelta(suite.T(), 7.0, GMI(154/mgdlPerMmol), 0.01)
+}
+
 type metaReadings struct {
 	size int
 	min  float64
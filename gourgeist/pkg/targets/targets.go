@@ -0,0 +1,144 @@
+package targets
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/schedule"
+	"sort"
+	"time"
+)
+
+// DefaultProfile is the name of the thresholds used when no other profile
+// is scheduled.
+const DefaultProfile = "default"
+
+const clockFormat = "03:04 PM"
+
+// Store reads the temporary targets that override the schedule.
+type Store interface {
+	ReadTempTargets(ctx context.Context, start, end time.Time) ([]defs.TempTarget, error)
+}
+
+// Resolver resolves the glucose thresholds active at a given time.
+type Resolver struct {
+	base      defs.Thresholds
+	profiles  map[string]defs.Thresholds
+	presets   map[string]defs.Thresholds
+	schedule  *schedule.Weekly
+	overrides []defs.TempTarget
+	loc       *time.Location
+}
+
+func New(cfg defs.GlucoseConfig, loc *time.Location) (*Resolver, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	sched, err := schedule.New(cfg.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse threshold schedule: %w", err)
+	}
+
+	for _, name := range sched.Profiles() {
+		if _, ok := cfg.Profiles[name]; !ok {
+			return nil, fmt.Errorf("unknown threshold profile: %s", name)
+		}
+	}
+
+	presets := make(map[string]defs.Thresholds)
+	for name, th := range defs.DefaultPresets {
+		presets[name] = th
+	}
+	for name, th := range cfg.Presets {
+		presets[name] = th
+	}
+
+	return &Resolver{
+		base:     defs.Thresholds{Low: cfg.Low, High: cfg.High, Target: cfg.Target},
+		profiles: cfg.Profiles,
+		presets:  presets,
+		schedule: sched,
+		loc:      loc,
+	}, nil
+}
+
+// Presets returns the names of the temporary target presets, sorted.
+func (r *Resolver) Presets() []string {
+	names := make([]string, 0, len(r.presets))
+	for name := range r.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preset returns the thresholds of the temporary target preset.
+func (r *Resolver) Preset(name string) (defs.Thresholds, bool) {
+	th, ok := r.presets[name]
+	return th, ok
+}
+
+// WithOverrides returns a copy of the resolver where the temporary targets
+// take precedence over the schedule while they are active.
+func (r *Resolver) WithOverrides(tts []defs.TempTarget) *Resolver {
+	rc := *r
+	rc.overrides = tts
+	return &rc
+}
+
+// Load returns a copy of the resolver with the temporary targets that may be
+// active between start and end.
+func (r *Resolver) Load(ctx context.Context, s Store, start, end time.Time) (*Resolver, error) {
+	tts, err := s.ReadTempTargets(ctx, start.Add(-defs.MaxTempTarget*time.Minute), end)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithOverrides(tts), nil
+}
+
+// Override returns the temporary target active at t, if any. Later targets
+// take precedence over earlier ones.
+func (r *Resolver) Override(t time.Time) *defs.TempTarget {
+	for i := len(r.overrides) - 1; i >= 0; i-- {
+		if r.overrides[i].Active(t) {
+			return &r.overrides[i]
+		}
+	}
+	return nil
+}
+
+// At returns the name of the active profile, and its thresholds.
+func (r *Resolver) At(t time.Time) (string, defs.Thresholds) {
+	if tt := r.Override(t); tt != nil {
+		return tt.Preset, defs.Thresholds{Low: tt.Low, High: tt.High, Target: tt.Target}
+	}
+
+	name := r.schedule.Profile(t)
+	if th, ok := r.profiles[name]; ok {
+		return name, th
+	}
+	return DefaultProfile, r.base
+}
+
+// Thresholds returns the thresholds active at t.
+func (r *Resolver) Thresholds(t time.Time) defs.Thresholds {
+	_, th := r.At(t)
+	return th
+}
+
+// Range returns the low and high thresholds active at t.
+func (r *Resolver) Range(t time.Time) (float64, float64) {
+	th := r.Thresholds(t)
+	return th.Low, th.High
+}
+
+// Describe summarizes the range active at t for display, along with when
+// any temporary target ends.
+func (r *Resolver) Describe(t time.Time) string {
+	name, th := r.At(t)
+	if tt := r.Override(t); tt != nil {
+		name = fmt.Sprintf("%s until %s", name, tt.End.In(r.loc).Format(clockFormat))
+	}
+	return fmt.Sprintf("%.1f - %.1f (%s)", th.Low, th.High, name)
+}
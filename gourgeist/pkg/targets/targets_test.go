@@ -0,0 +1,87 @@
+package targets
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TargetsTestSuite struct {
+	suite.Suite
+}
+
+func TestTargetsTestSuite(t *testing.T) {
+	suite.Run(t, new(TargetsTestSuite))
+}
+
+func (suite *TargetsTestSuite) TestResolveProfile() {
+	r, err := New(defs.GlucoseConfig{
+		Low:  4,
+		High: 9,
+		Profiles: map[string]defs.Thresholds{
+			"night": {Low: 5, High: 8, Target: 6},
+		},
+		Schedule: []defs.ScheduleEntry{
+			{Start: "22:00", End: "07:00", Profile: "night"},
+		},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+
+	day := time.Date(2022, time.May, 12, 0, 0, 0, 0, time.UTC)
+	name, th := r.At(day.Add(2 * time.Hour))
+	assert.Equal(suite.T(), "night", name)
+	assert.Equal(suite.T(), defs.Thresholds{Low: 5, High: 8, Target: 6}, th)
+
+	name, th = r.At(day.Add(12 * time.Hour))
+	assert.Equal(suite.T(), DefaultProfile, name)
+	assert.Equal(suite.T(), defs.Thresholds{Low: 4, High: 9}, th)
+}
+
+func (suite *TargetsTestSuite) TestUnknownProfile() {
+	_, err := New(defs.GlucoseConfig{
+		Schedule: []defs.ScheduleEntry{{Start: "22:00", End: "07:00", Profile: "night"}},
+	}, time.UTC)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *TargetsTestSuite) TestOverride() {
+	r, err := New(defs.GlucoseConfig{
+		Low:     4,
+		High:    9,
+		Presets: map[string]defs.Thresholds{defs.ExercisePreset: {Low: 6, High: 12}},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+
+	th, ok := r.Preset(defs.ExercisePreset)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), defs.Thresholds{Low: 6, High: 12}, th, "configured presets take precedence")
+	_, ok = r.Preset(defs.PreMealPreset)
+	assert.True(suite.T(), ok, "default presets are kept")
+
+	r, err = New(defs.GlucoseConfig{
+		Low:     4,
+		High:    9,
+		Presets: map[string]defs.Thresholds{"swimming": {Low: 7, High: 12}},
+	}, time.UTC)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{
+		defs.ExercisePreset, defs.PreMealPreset, defs.SickDayPreset, "swimming",
+	}, r.Presets())
+
+	start := time.Date(2022, time.May, 12, 17, 0, 0, 0, time.UTC)
+	or := r.WithOverrides([]defs.TempTarget{
+		{Time: start, End: start.Add(2 * time.Hour), Preset: defs.ExercisePreset, Low: 6, High: 12},
+		{Time: start.Add(time.Hour), End: start.Add(90 * time.Minute), Preset: defs.PreMealPreset, Low: 4, High: 7},
+	})
+
+	assert.Equal(suite.T(), "4.0 - 9.0 (default)", or.Describe(start.Add(-time.Minute)))
+	assert.Equal(suite.T(), "6.0 - 12.0 (exercise until 07:00 PM)", or.Describe(start))
+	assert.Equal(suite.T(), "4.0 - 7.0 (premeal until 06:30 PM)", or.Describe(start.Add(time.Hour)))
+	assert.Equal(suite.T(), "6.0 - 12.0 (exercise until 07:00 PM)", or.Describe(start.Add(100*time.Minute)))
+	assert.Equal(suite.T(), "4.0 - 9.0 (default)", or.Describe(start.Add(2*time.Hour)))
+
+	assert.Nil(suite.T(), r.Override(start), "the original resolver is unchanged")
+}
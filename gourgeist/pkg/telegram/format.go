@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"html"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"iv2/gourgeist/pkg/textcmd"
+	"strconv"
+	"strings"
+)
+
+// Limits of the Bot API.
+const (
+	textLimit     = 4096
+	captionLimit  = 1024
+	callbackLimit = 64 // Bytes of callback data.
+	buttonsLimit  = 5  // Per row, as on Discord.
+)
+
+// callbacksLimit is how many commands too long for callback data are kept,
+// the oldest being forgotten first.
+const callbacksLimit = 1024
+
+type button struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type keyboard struct {
+	InlineKeyboard [][]button `json:"inline_keyboard"`
+}
+
+// format transforms data of type defs.MessageData to the HTML text of a
+// message, where each embed is its title in bold followed by its
+// description and fields.
+func format(data defs.MessageData) string {
+	parts := make([]string, 0)
+	if content := display.HTML(mention(data.Content)); content != "" {
+		parts = append(parts, content)
+	}
+
+	for _, embed := range data.Embeds {
+		lines := make([]string, 0)
+		if embed.Title != "" {
+			lines = append(lines, "<b>"+html.EscapeString(embed.Title)+"</b>")
+		}
+		if embed.Description != "" {
+			lines = append(lines, display.HTML(embed.Description))
+		}
+		for _, field := range display.Fields(embed) {
+			lines = append(lines, "<b>"+html.EscapeString(field.Name)+"</b>: "+display.HTML(field.Value))
+		}
+		if len(lines) > 0 {
+			parts = append(parts, strings.Join(lines, "\n"))
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// fit cuts the text to the limit by whole lines, so that no tag is left
+// open.
+func fit(text string, limit int) string {
+	if len([]rune(text)) <= limit {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for len(lines) > 0 && len([]rune(strings.Join(lines, "\n")))+2 > limit {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n") + "\n…"
+}
+
+// mention removes the mentions of everyone, as every member of a chat is
+// notified already.
+func mention(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(strings.ReplaceAll(line, "@everyone", "")); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// keyboard lays out the buttons in rows, with the commands they run as
+// their callback data.
+func (t *Telegram) keyboard(buttons []defs.ButtonData) *keyboard {
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	kb := &keyboard{InlineKeyboard: make([][]button, 0)}
+	for i := 0; i < len(buttons); i += buttonsLimit {
+		end := i + buttonsLimit
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+
+		row := make([]button, 0)
+		for _, b := range buttons[i:end] {
+			row = append(row, button{Text: b.Label, CallbackData: t.encode(b.Command)})
+		}
+		kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+	}
+	return kb
+}
+
+// encode packs the command into callback data. Commands too long for it are
+// kept by the bot, and referred to by a key instead, the same for as long as
+// the command is kept.
+func (t *Telegram) encode(ci defs.CommandInteraction) string {
+	s := textcmd.EncodeCommand(ci)
+	if len(s) <= callbackLimit {
+		return s
+	}
+
+	t.cbMu.Lock()
+	defer t.cbMu.Unlock()
+	if key, ok := t.cbKeys[s]; ok {
+		return key
+	}
+	key := "#" + strconv.Itoa(t.cbNext)
+	t.cbNext++
+	t.callbacks[key], t.cbKeys[s] = s, key
+	t.cbOrder = append(t.cbOrder, key)
+
+	if len(t.cbOrder) > callbacksLimit {
+		oldest := t.cbOrder[0]
+		t.cbOrder = t.cbOrder[1:]
+		delete(t.cbKeys, t.callbacks[oldest])
+		delete(t.callbacks, oldest)
+	}
+	return key
+}
+
+// decode is the inverse of encode. It is false for the keys of the commands
+// no longer kept, such as those of the buttons sent before a restart.
+func (t *Telegram) decode(data string) (defs.CommandInteraction, bool) {
+	if strings.HasPrefix(data, "#") {
+		t.cbMu.Lock()
+		s, ok := t.callbacks[data]
+		t.cbMu.Unlock()
+		if !ok {
+			return defs.CommandInteraction{}, false
+		}
+		data = s
+	}
+	return textcmd.DecodeCommand(data), true
+}
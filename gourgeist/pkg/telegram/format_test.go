@@ -0,0 +1,42 @@
+package telegram
+
+import (
+	"iv2/gourgeist/defs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type FormatTestSuite struct {
+	suite.Suite
+}
+
+func TestFormatTestSuite(t *testing.T) {
+	suite.Run(t, new(FormatTestSuite))
+}
+
+func (suite *FormatTestSuite) TestFormat() {
+	data := defs.MessageData{
+		Content: "**iv2** report",
+		Embeds: []defs.EmbedData{{
+			Title:       "05/12 & 05/13",
+			Description: "took **4u** of rapid",
+			Fields:      []defs.EmbedField{{Name: "In Range", Value: "0.72"}, defs.EmptyEmbed()},
+		}},
+	}
+	assert.Equal(suite.T(),
+		"<b>iv2</b> report\n\n<b>05/12 &amp; 05/13</b>\ntook <b>4u</b> of rapid\n<b>In Range</b>: 0.72",
+		format(data))
+	assert.Equal(suite.T(), "low", format(defs.MessageData{Content: "low\n@everyone"}))
+}
+
+func (suite *FormatTestSuite) TestFit() {
+	text := "<b>title</b>\n" + strings.Repeat("line\n", 10)
+	assert.Equal(suite.T(), text, fit(text, len(text)))
+
+	fitted := fit(text, 20)
+	assert.Equal(suite.T(), "<b>title</b>\nline\n…", fitted)
+	assert.LessOrEqual(suite.T(), len([]rune(fitted)), 20)
+}
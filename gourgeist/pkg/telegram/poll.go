@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/textcmd"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// pollTimeout is how long the Bot API holds on to a poll for updates.
+	pollTimeout = 30
+	retryDelay  = 5 * time.Second
+
+	expiredButton = "This button has expired, send the command instead."
+)
+
+type update struct {
+	UpdateID      int            `json:"update_id"`
+	Message       *message       `json:"message"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+}
+
+// callbackQuery is a click on a button.
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+// run polls for updates until the process exits. Polling needs no public
+// address, unlike webhooks.
+func (t *Telegram) run() {
+	client := &http.Client{Timeout: pollTimeout*time.Second + timeout}
+	for {
+		if err := t.poll(client); err != nil {
+			t.Logger.Debug("unable to poll updates", zap.Error(err))
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// poll waits for the next updates, handling the commands among them.
+func (t *Telegram) poll(client *http.Client) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"offset":          t.offset,
+		"timeout":         pollTimeout,
+		"allowed_updates": []string{"message", "callback_query"},
+	})
+	if err != nil {
+		return err
+	}
+
+	var updates []update
+	if err := t.do("getUpdates", bytes.NewReader(b), "application/json", client, &updates); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		t.offset = u.UpdateID + 1
+		switch {
+		case u.Message != nil:
+			t.handleMessage(*u.Message)
+		case u.CallbackQuery != nil:
+			cq := u.CallbackQuery
+			ci, ok := t.decode(cq.Data)
+			// Stops the button from loading, whether it is handled or not.
+			params := map[string]interface{}{"callback_query_id": cq.ID}
+			if !ok {
+				params["text"], params["show_alert"] = expiredButton, true
+			}
+			if err := t.call("answerCallbackQuery", params, nil, nil); err != nil {
+				t.Logger.Debug("unable to answer callback query", zap.Error(err))
+			}
+			if !ok || cq.Message == nil || !t.allowed(cq.Message.Chat.ID) {
+				continue
+			}
+			ref := messageRef{chat: cq.Message.Chat.ID, id: cq.Message.MessageID}
+			ei := defs.EventInfo{
+				ID:    uint64(cq.Message.MessageID),
+				Token: tokenOf(ref, cq.ID),
+				User:  cq.From.name(),
+			}
+			go t.handler(ei, ci)
+		}
+	}
+	return nil
+}
+
+// handleMessage parses the commands sent to the bot, replying with the
+// usage if they are invalid.
+func (t *Telegram) handleMessage(msg message) {
+	if !strings.HasPrefix(msg.Text, "/") || !t.allowed(msg.Chat.ID) {
+		return
+	}
+	// Commands to other bots of the chat are ignored.
+	cmd := strings.Fields(msg.Text)[0]
+	if _, bot, ok := strings.Cut(cmd, "@"); ok && !strings.EqualFold(bot, t.username) {
+		return
+	}
+
+	ref := messageRef{chat: msg.Chat.ID, id: msg.MessageID}
+	ci, err := textcmd.Parse(msg.Text, defs.Commands)
+	if err != nil {
+		params := map[string]interface{}{
+			"chat_id":             ref.chat,
+			"text":                err.Error(),
+			"reply_to_message_id": ref.id,
+		}
+		if err := t.call("sendMessage", params, nil, nil); err != nil {
+			t.Logger.Debug("unable to reply usage", zap.Error(err))
+		}
+		return
+	}
+
+	ei := defs.EventInfo{
+		ID:    uint64(msg.MessageID),
+		Token: tokenOf(ref, ""),
+		User:  msg.From.name(),
+	}
+	go t.handler(ei, ci)
+}
+
+// allowed reports whether commands are taken from the chat.
+func (t *Telegram) allowed(chat int64) bool {
+	if chat == t.chat {
+		return true
+	}
+	for _, c := range t.chats {
+		if c == chat {
+			return true
+		}
+	}
+	t.Logger.Debug("ignored update from unknown chat", zap.Int64("chat", chat))
+	return false
+}
+
+func (u user) name() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.FirstName
+}
@@ -0,0 +1,466 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/display"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	botAPI      = "https://api.telegram.org"
+	mainChannel = "iv2"
+	timeout     = 10 * time.Second
+
+	// notModified is the error of edits that change nothing, which are
+	// frequent as the dashboard is edited in place.
+	notModified = "message is not modified"
+)
+
+// Telegram displays iv2 through a bot, with the dashboard pinned in the main
+// chat and edited in place. Commands are sent to the bot as text.
+type Telegram struct {
+	Logger   *zap.Logger
+	Location *time.Location
+
+	token  string
+	api    string
+	client *http.Client
+
+	chat     int64
+	chats    map[string]int64
+	username string // Of the bot.
+	handler  defs.CommandInteractionHandler
+	offset   int // Of the next update.
+
+	cbMu      sync.Mutex
+	callbacks map[string]string // Commands too long for callback data, by key.
+	cbKeys    map[string]string // Keys by command.
+	cbOrder   []string          // Keys, oldest first.
+	cbNext    int
+
+	mu        sync.Mutex
+	responses map[string]messageRef // Responses by the token of their interaction.
+	main      display.Main          // The Bot API cannot read messages back.
+	mainRef   *messageRef           // Of the main message.
+	mainPhoto bool                  // Whether the main message is a photo.
+}
+
+type messageRef struct {
+	chat int64
+	id   int
+}
+
+type message struct {
+	MessageID int `json:"message_id"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	From user   `json:"from"`
+	Text string `json:"text"`
+}
+
+type user struct {
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+}
+
+func New(cfg defs.TelegramConfig, logger *zap.Logger, loc *time.Location) (*Telegram, error) {
+	return newTelegram(cfg, botAPI, logger, loc)
+}
+
+func newTelegram(cfg defs.TelegramConfig, api string, logger *zap.Logger,
+	loc *time.Location) (*Telegram, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("missing token")
+	}
+	if cfg.Chat == 0 {
+		return nil, errors.New("missing chat")
+	}
+
+	t := &Telegram{
+		Logger:    logger,
+		Location:  loc,
+		token:     cfg.Token,
+		api:       strings.TrimSuffix(api, "/"),
+		client:    &http.Client{Timeout: timeout},
+		chat:      cfg.Chat,
+		chats:     cfg.Chats,
+		callbacks: make(map[string]string),
+		cbKeys:    make(map[string]string),
+		responses: make(map[string]messageRef),
+	}
+	if t.chats == nil {
+		t.chats = make(map[string]int64)
+	}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := t.call("getMe", nil, nil, &me); err != nil {
+		return nil, fmt.Errorf("unable to get bot: %w", err)
+	}
+	t.username = me.Username
+	return t, nil
+}
+
+// Setup registers the commands, and starts taking them from the chats.
+// Channels without a chat of their own are sent to the main chat.
+func (t *Telegram) Setup(channels []string, cmdHandler defs.CommandInteractionHandler) error {
+	type command struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	cmds := make([]command, 0, len(defs.Commands))
+	for _, cmd := range defs.Commands {
+		cmds = append(cmds, command{Command: cmd.Name, Description: cmd.Description})
+	}
+	if err := t.call("setMyCommands", map[string]interface{}{"commands": cmds}, nil, nil); err != nil {
+		return fmt.Errorf("unable to set commands: %w", err)
+	}
+
+	for _, chName := range channels {
+		if _, ok := t.chats[chName]; !ok {
+			t.Logger.Debug("sending channel to main chat", zap.String("channel name", chName))
+		}
+	}
+
+	t.handler = cmdHandler
+	go t.run()
+
+	t.Logger.Debug("telegram setup complete")
+	return nil
+}
+
+func (t *Telegram) SendMessage(data defs.MessageData, chName string) (uint64, error) {
+	ref, _, err := t.send(t.chatOf(chName), data, false)
+	if err != nil {
+		return 0, err
+	}
+	t.Logger.Debug("sent message", zap.String("channel name", chName))
+	return uint64(ref.id), nil
+}
+
+func (t *Telegram) SendDirectMessage(_ defs.MessageData, _ uint64) (uint64, error) {
+	return 0, display.ErrNoDirectMessages
+}
+
+func (t *Telegram) GetMainMessage() (*defs.MessageData, error) {
+	return t.main.Get()
+}
+
+// NewMainMessage replaces the photo of the main message when both have one,
+// so that the dashboard stays pinned. Otherwise the main message is sent
+// again, silently, and pinned.
+func (t *Telegram) NewMainMessage(data defs.MessageData) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	text := format(data)
+	photo := image(data)
+	if t.mainRef != nil && t.mainPhoto && photo != nil && len([]rune(text)) <= captionLimit {
+		media, err := json.Marshal(map[string]string{
+			"type":       "photo",
+			"media":      "attach://photo",
+			"caption":    text,
+			"parse_mode": "HTML",
+		})
+		if err != nil {
+			return err
+		}
+		params := map[string]interface{}{
+			"chat_id":      t.mainRef.chat,
+			"message_id":   t.mainRef.id,
+			"media":        string(media),
+			"reply_markup": t.keyboard(data.Buttons),
+		}
+		files := map[string]defs.FileData{"photo": *photo}
+		if err := t.call("editMessageMedia", params, files, nil); err != nil {
+			return fmt.Errorf("unable to edit main message: %w", err)
+		}
+		t.main.Set(data)
+		return nil
+	}
+
+	if t.mainRef != nil {
+		params := map[string]interface{}{"chat_id": t.mainRef.chat, "message_id": t.mainRef.id}
+		if err := t.call("deleteMessage", params, nil, nil); err != nil {
+			t.Logger.Debug("unable to delete main message", zap.Error(err))
+		}
+	}
+
+	ref, isPhoto, err := t.send(t.chatOf(mainChannel), data, true)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{"chat_id": ref.chat, "message_id": ref.id, "disable_notification": true}
+	if err := t.call("pinChatMessage", params, nil, nil); err != nil {
+		t.Logger.Debug("unable to pin main message", zap.Error(err))
+	}
+
+	t.mainRef, t.mainPhoto = &ref, isPhoto
+	t.main.Set(data)
+	return nil
+}
+
+// UpdateMainMessage edits the text of the main message, keeping its photo.
+func (t *Telegram) UpdateMainMessage(data defs.MessageData) error {
+	t.mu.Lock()
+	if t.mainRef == nil {
+		t.mu.Unlock()
+		return t.NewMainMessage(data)
+	}
+	defer t.mu.Unlock()
+
+	params := map[string]interface{}{
+		"chat_id":      t.mainRef.chat,
+		"message_id":   t.mainRef.id,
+		"parse_mode":   "HTML",
+		"reply_markup": t.keyboard(data.Buttons),
+	}
+	method := "editMessageText"
+	if t.mainPhoto {
+		method = "editMessageCaption"
+		params["caption"] = fit(format(data), captionLimit)
+	} else {
+		params["text"] = fit(format(data), textLimit)
+	}
+
+	if err := t.call(method, params, nil, nil); err != nil {
+		return fmt.Errorf("unable to update main message: %w", err)
+	}
+	t.main.Set(data)
+	return nil
+}
+
+// RespondInteraction replies to the message of the command. The token of an
+// interaction refers to that message.
+func (t *Telegram) RespondInteraction(_ uint64, token string, resp defs.InteractionResponse) error {
+	ref, err := parseToken(token)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{
+		"chat_id":                     ref.chat,
+		"text":                        fit(format(resp.Data), textLimit),
+		"parse_mode":                  "HTML",
+		"reply_to_message_id":         ref.id,
+		"allow_sending_without_reply": true,
+		"disable_notification":        true,
+		"reply_markup":                t.keyboard(resp.Data.Buttons),
+	}
+	var msg message
+	if err := t.call("sendMessage", params, nil, &msg); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.responses[token] = messageRef{chat: ref.chat, id: msg.MessageID}
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Telegram) DeleteInteractionResponse(_ uint64, token string) error {
+	t.mu.Lock()
+	ref, ok := t.responses[token]
+	delete(t.responses, token)
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no response to delete: %s", token)
+	}
+
+	return t.call("deleteMessage", map[string]interface{}{"chat_id": ref.chat, "message_id": ref.id}, nil, nil)
+}
+
+// send sends the message to the chat, as a photo captioned with the text if
+// an embed has one. Other files are sent as documents after it.
+func (t *Telegram) send(chat int64, data defs.MessageData, silent bool) (messageRef, bool, error) {
+	text := format(data)
+	photo := image(data)
+	kb := t.keyboard(data.Buttons)
+
+	var msg message
+	isPhoto := photo != nil && len([]rune(text)) <= captionLimit
+	if photo != nil {
+		params := map[string]interface{}{"chat_id": chat, "disable_notification": silent}
+		if isPhoto {
+			params["caption"] = text
+			params["parse_mode"] = "HTML"
+			params["reply_markup"] = kb
+		}
+		if err := t.call("sendPhoto", params, map[string]defs.FileData{"photo": *photo}, &msg); err != nil {
+			return messageRef{}, false, fmt.Errorf("unable to send photo: %w", err)
+		}
+	}
+	if !isPhoto {
+		params := map[string]interface{}{
+			"chat_id":              chat,
+			"text":                 fit(text, textLimit),
+			"parse_mode":           "HTML",
+			"disable_notification": silent,
+			"reply_markup":         kb,
+		}
+		if err := t.call("sendMessage", params, nil, &msg); err != nil {
+			return messageRef{}, false, fmt.Errorf("unable to send message: %w", err)
+		}
+	}
+
+	for _, f := range data.Files {
+		if photo != nil && f.Name == photo.Name {
+			continue
+		}
+		params := map[string]interface{}{"chat_id": chat, "disable_notification": silent}
+		if err := t.call("sendDocument", params, map[string]defs.FileData{"document": f}, nil); err != nil {
+			return messageRef{}, false, fmt.Errorf("unable to send %s: %w", f.Name, err)
+		}
+	}
+
+	return messageRef{chat: chat, id: msg.MessageID}, isPhoto, nil
+}
+
+// chatOf returns the chat of the channel, the main chat by default.
+func (t *Telegram) chatOf(chName string) int64 {
+	if chat, ok := t.chats[chName]; ok {
+		return chat
+	}
+	return t.chat
+}
+
+// call calls a method of the Bot API, decoding the result into v if given.
+// Parameters are sent as JSON, or as a multipart form along with the files.
+func (t *Telegram) call(method string, params map[string]interface{}, files map[string]defs.FileData,
+	v interface{}) error {
+	for k, p := range params {
+		// Optional parameters are left out when empty.
+		if kb, ok := p.(*keyboard); ok && kb == nil {
+			delete(params, k)
+		}
+	}
+
+	var body io.Reader
+	var contentType string
+	if len(files) == 0 {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(b), "application/json"
+	} else {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, p := range params {
+			s, ok := p.(string)
+			if !ok {
+				b, err := json.Marshal(p)
+				if err != nil {
+					return err
+				}
+				s = string(b)
+			}
+			if err := mw.WriteField(k, s); err != nil {
+				return err
+			}
+		}
+		for field, f := range files {
+			w, err := mw.CreateFormFile(field, f.Name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, f.Reader); err != nil {
+				return err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+		body, contentType = &buf, mw.FormDataContentType()
+	}
+
+	return t.do(method, body, contentType, t.client, v)
+}
+
+func (t *Telegram) do(method string, body io.Reader, contentType string, client *http.Client,
+	v interface{}) error {
+	res, err := client.Post(t.api+"/bot"+t.token+"/"+method, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return fmt.Errorf("unable to decode %s response: %w", method, err)
+	}
+	if !resp.OK {
+		if strings.Contains(resp.Description, notModified) {
+			return nil
+		}
+		return fmt.Errorf("%s: %s", method, resp.Description)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, v)
+}
+
+// image returns the file shown by an embed, if any.
+func image(data defs.MessageData) *defs.FileData {
+	for _, embed := range data.Embeds {
+		if embed.Image == nil {
+			continue
+		}
+		for _, f := range data.Files {
+			if f.Name == embed.Image.Filename {
+				return &f
+			}
+		}
+	}
+	return nil
+}
+
+// tokenOf refers to the message of a command, as chat:message, followed by
+// the ID of the callback query of a button.
+func tokenOf(ref messageRef, query string) string {
+	s := strconv.FormatInt(ref.chat, 10) + ":" + strconv.Itoa(ref.id)
+	if query != "" {
+		s += ":" + query
+	}
+	return s
+}
+
+func parseToken(s string) (messageRef, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 {
+		return messageRef{}, fmt.Errorf("invalid token: %s", s)
+	}
+	chat, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return messageRef{}, fmt.Errorf("invalid token: %s", s)
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return messageRef{}, fmt.Errorf("invalid token: %s", s)
+	}
+	return messageRef{chat: chat, id: id}, nil
+}
@@ -0,0 +1,342 @@
+package telegram
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"iv2/gourgeist/defs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+const (
+	testToken = "123:test"
+	testChat  = int64(-1001)
+	alertChat = int64(-1002)
+)
+
+// call is a call to the stub Bot API, with the files sent along by field.
+type call struct {
+	method string
+	params map[string]interface{}
+	files  map[string]string
+}
+
+// stub is the Bot API of a bot, with the updates to poll.
+type stub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []call
+	updates []update
+}
+
+func newStub() *stub {
+	st := &stub{}
+	st.Server = httptest.NewServer(http.HandlerFunc(st.handle))
+	return st
+}
+
+func (st *stub) handle(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if method == r.URL.Path {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Unauthorized"})
+		return
+	}
+
+	c := call{method: method, params: make(map[string]interface{}), files: make(map[string]string)}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		_ = r.ParseMultipartForm(1 << 20)
+		for k, v := range r.MultipartForm.Value {
+			c.params[k] = v[0]
+		}
+		for k, fhs := range r.MultipartForm.File {
+			f, _ := fhs[0].Open()
+			b, _ := ioutil.ReadAll(f)
+			c.files[k] = string(b)
+		}
+	} else {
+		_ = json.NewDecoder(r.Body).Decode(&c.params)
+	}
+
+	st.mu.Lock()
+	st.calls = append(st.calls, c)
+	n := len(st.calls)
+	updates := st.updates
+	st.updates = nil
+	st.mu.Unlock()
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = map[string]interface{}{"username": "iv2bot"}
+	case "sendMessage", "sendPhoto", "sendDocument":
+		result = map[string]interface{}{"message_id": 100 + n}
+	case "getUpdates":
+		result = updates
+	case "editMessageCaption":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": false, "description": "Bad Request: message is not modified",
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (st *stub) methods() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ms := make([]string, len(st.calls))
+	for i, c := range st.calls {
+		ms[i] = c.method
+	}
+	return ms
+}
+
+func (st *stub) last(method string) call {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := len(st.calls) - 1; i >= 0; i-- {
+		if st.calls[i].method == method {
+			return st.calls[i]
+		}
+	}
+	return call{}
+}
+
+type TelegramTestSuite struct {
+	suite.Suite
+	stub *stub
+	tg   *Telegram
+
+	mu       sync.Mutex
+	commands []defs.CommandInteraction
+	events   []defs.EventInfo
+}
+
+func TestTelegramTestSuite(t *testing.T) {
+	suite.Run(t, new(TelegramTestSuite))
+}
+
+func (suite *TelegramTestSuite) SetupTest() {
+	suite.stub = newStub()
+	suite.T().Cleanup(suite.stub.Close)
+	suite.commands, suite.events = nil, nil
+
+	cfg := defs.TelegramConfig{
+		Token: testToken,
+		Chat:  testChat,
+		Chats: map[string]int64{defs.AlertsChannel: alertChat},
+	}
+	tg, err := newTelegram(cfg, suite.stub.URL, zap.NewNop(), time.UTC)
+	assert.NoError(suite.T(), err)
+	suite.tg = tg
+	suite.tg.handler = func(e defs.EventInfo, ci defs.CommandInteraction) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.events = append(suite.events, e)
+		suite.commands = append(suite.commands, ci)
+	}
+}
+
+func (suite *TelegramTestSuite) TestNew() {
+	assert.Equal(suite.T(), "iv2bot", suite.tg.username)
+
+	_, err := newTelegram(defs.TelegramConfig{Token: "456:wrong", Chat: testChat}, suite.stub.URL, nil, nil)
+	assert.Error(suite.T(), err)
+	_, err = newTelegram(defs.TelegramConfig{Token: testToken}, suite.stub.URL, nil, nil)
+	assert.Error(suite.T(), err, "no chat")
+}
+
+func (suite *TelegramTestSuite) TestSendMessage() {
+	id, err := suite.tg.SendMessage(defs.MessageData{
+		Content:         "⚠️ Low\ncurrent value: <3.1>\n@everyone",
+		MentionEveryone: true,
+		Buttons: []defs.ButtonData{{Label: "Ack", Command: defs.CommandInteraction{
+			Name: defs.AckCmd, Options: []defs.CommandInteractionOption{{Name: "id", Value: "42"}},
+		}}},
+	}, defs.AlertsChannel)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint64(102), id)
+
+	c := suite.stub.last("sendMessage")
+	assert.Equal(suite.T(), float64(alertChat), c.params["chat_id"])
+	assert.Equal(suite.T(), "⚠️ Low\ncurrent value: &lt;3.1&gt;", c.params["text"])
+	assert.Equal(suite.T(), false, c.params["disable_notification"], "alerts notify")
+	assert.Equal(suite.T(), map[string]interface{}{"inline_keyboard": []interface{}{[]interface{}{
+		map[string]interface{}{"text": "Ack", "callback_data": "ack|id=42"},
+	}}}, c.params["reply_markup"])
+
+	_, err = suite.tg.SendMessage(defs.MessageData{Content: "report"}, defs.ReportsChannel)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), float64(testChat), suite.stub.last("sendMessage").params["chat_id"], "main chat")
+}
+
+func (suite *TelegramTestSuite) TestMainMessage() {
+	_, err := suite.tg.GetMainMessage()
+	assert.Error(suite.T(), err, "no main message yet")
+
+	dashboard := func(value, plot string) defs.MessageData {
+		return defs.MessageData{
+			Embeds: []defs.EmbedData{{
+				Title:  "2022-05-12 03:00 AM",
+				Fields: []defs.EmbedField{{Name: "Current", Value: value}, defs.EmptyEmbed()},
+				Image:  &defs.ImageData{Filename: "daily.png"},
+			}},
+			Files: []defs.FileData{{Name: "daily.png", Reader: strings.NewReader(plot)}},
+		}
+	}
+
+	assert.NoError(suite.T(), suite.tg.NewMainMessage(dashboard("5.20", "plot1")))
+	c := suite.stub.last("sendPhoto")
+	assert.Equal(suite.T(), "plot1", c.files["photo"])
+	assert.Equal(suite.T(), "<b>2022-05-12 03:00 AM</b>\n<b>Current</b>: 5.20", c.params["caption"])
+	assert.Equal(suite.T(), "true", c.params["disable_notification"], "dashboard is silent")
+	assert.Equal(suite.T(), float64(102), suite.stub.last("pinChatMessage").params["message_id"])
+
+	assert.NoError(suite.T(), suite.tg.NewMainMessage(dashboard("5.40", "plot2")))
+	c = suite.stub.last("editMessageMedia")
+	assert.Equal(suite.T(), "plot2", c.files["photo"])
+	assert.Equal(suite.T(), "102", c.params["message_id"], "edited in place")
+	assert.Contains(suite.T(), c.params["media"], "5.40")
+
+	msg, err := suite.tg.GetMainMessage()
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), msg.Files)
+	msg.Embeds[0].Fields[0].Value = "5.40"
+	assert.NoError(suite.T(), suite.tg.UpdateMainMessage(*msg), "not modified is no error")
+	assert.Equal(suite.T(), float64(102), suite.stub.last("editMessageCaption").params["message_id"])
+
+	assert.NoError(suite.T(), suite.tg.NewMainMessage(defs.MessageData{Content: "no plot"}))
+	assert.Equal(suite.T(), float64(102), suite.stub.last("deleteMessage").params["message_id"])
+	assert.Equal(suite.T(), []string{
+		"getMe", "sendPhoto", "pinChatMessage", "editMessageMedia", "editMessageCaption",
+		"deleteMessage", "sendMessage", "pinChatMessage",
+	}, suite.stub.methods())
+}
+
+func (suite *TelegramTestSuite) TestRespondInteraction() {
+	token := tokenOf(messageRef{chat: testChat, id: 7}, "")
+	resp := defs.InteractionResponse{Type: defs.MessageInteraction, Data: defs.MessageData{Content: "received"}}
+	assert.NoError(suite.T(), suite.tg.RespondInteraction(7, token, resp))
+
+	c := suite.stub.last("sendMessage")
+	assert.Equal(suite.T(), float64(7), c.params["reply_to_message_id"])
+	assert.Equal(suite.T(), "received", c.params["text"])
+
+	assert.NoError(suite.T(), suite.tg.DeleteInteractionResponse(0, token))
+	assert.Equal(suite.T(), float64(102), suite.stub.last("deleteMessage").params["message_id"])
+	assert.Error(suite.T(), suite.tg.DeleteInteractionResponse(0, token), "already deleted")
+}
+
+// handled waits for the commands dispatched in the background.
+func (suite *TelegramTestSuite) handled(n int) []defs.CommandInteraction {
+	assert.Eventually(suite.T(), func() bool {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		return len(suite.commands) == n
+	}, time.Second, 10*time.Millisecond)
+
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.commands
+}
+
+func (suite *TelegramTestSuite) poll(updates ...update) {
+	suite.stub.mu.Lock()
+	suite.stub.updates = updates
+	suite.stub.mu.Unlock()
+	assert.NoError(suite.T(), suite.tg.poll(suite.tg.client))
+}
+
+func newMessage(id int, chat int64, text string) *message {
+	msg := &message{MessageID: id, From: user{Username: "teen"}, Text: text}
+	msg.Chat.ID = chat
+	return msg
+}
+
+func (suite *TelegramTestSuite) TestPoll() {
+	suite.poll(
+		update{UpdateID: 1, Message: newMessage(7, testChat, "/carbs@iv2bot 30 slow")},
+		update{UpdateID: 2, Message: newMessage(8, testChat, "/carbs@otherbot 30")},
+		update{UpdateID: 3, Message: newMessage(9, 555, "/carbs 30")},
+		update{UpdateID: 4, Message: newMessage(10, testChat, "hello")},
+	)
+	assert.Equal(suite.T(), 5, suite.tg.offset)
+
+	cmds := suite.handled(1)
+	assert.Equal(suite.T(), defs.CommandInteraction{
+		Name: defs.AddCarbsCmd,
+		Options: []defs.CommandInteractionOption{
+			{Name: "amount", Value: "30"}, {Name: "absorption", Value: "240"},
+		},
+	}, cmds[0])
+	assert.Equal(suite.T(), "teen", suite.events[0].User)
+	assert.Equal(suite.T(), "-1001:7", suite.events[0].Token)
+
+	suite.poll(update{UpdateID: 5, Message: newMessage(11, testChat, "/carbs")})
+	assert.Equal(suite.T(), float64(5), suite.stub.last("getUpdates").params["offset"])
+	c := suite.stub.last("sendMessage")
+	assert.Equal(suite.T(), float64(11), c.params["reply_to_message_id"])
+	assert.Contains(suite.T(), c.params["text"], "missing amount", "usage is replied")
+	assert.Len(suite.T(), suite.handled(1), 1)
+}
+
+func (suite *TelegramTestSuite) TestCallback() {
+	long := defs.CommandInteraction{
+		Name:    defs.SnoozeCmd,
+		Options: []defs.CommandInteractionOption{{Name: "id", Value: strings.Repeat("a", 64)}},
+	}
+	kb := suite.tg.keyboard([]defs.ButtonData{{Label: "Snooze", Command: long}})
+	data := kb.InlineKeyboard[0][0].CallbackData
+	assert.Equal(suite.T(), "#0", data, "too long for callback data")
+
+	suite.poll(update{UpdateID: 1, CallbackQuery: &callbackQuery{
+		ID:      "q1",
+		From:    user{FirstName: "Grandma"},
+		Message: newMessage(7, alertChat, ""),
+		Data:    data,
+	}})
+
+	assert.Equal(suite.T(), "q1", suite.stub.last("answerCallbackQuery").params["callback_query_id"])
+	assert.Equal(suite.T(), long, suite.handled(1)[0])
+	assert.Equal(suite.T(), "Grandma", suite.events[0].User)
+	assert.Equal(suite.T(), "-1002:7:q1", suite.events[0].Token)
+
+	kb = suite.tg.keyboard([]defs.ButtonData{{Label: "Snooze", Command: long}})
+	assert.Equal(suite.T(), data, kb.InlineKeyboard[0][0].CallbackData, "keys are reused")
+
+	suite.poll(update{UpdateID: 2, CallbackQuery: &callbackQuery{
+		ID:      "q2",
+		Message: newMessage(8, alertChat, ""),
+		Data:    "#41",
+	}})
+	c := suite.stub.last("answerCallbackQuery")
+	assert.Equal(suite.T(), expiredButton, c.params["text"], "stale keys are replied to")
+	assert.Len(suite.T(), suite.handled(1), 1)
+}
+
+func (suite *TelegramTestSuite) TestCallbacksLimit() {
+	var first string
+	for i := 0; i <= callbacksLimit; i++ {
+		id := strings.Repeat("a", 64) + strconv.Itoa(i)
+		key := suite.tg.encode(defs.CommandInteraction{
+			Name:    defs.SnoozeCmd,
+			Options: []defs.CommandInteractionOption{{Name: "id", Value: id}},
+		})
+		if i == 0 {
+			first = key
+		}
+	}
+	assert.Len(suite.T(), suite.tg.callbacks, callbacksLimit)
+	_, ok := suite.tg.decode(first)
+	assert.False(suite.T(), ok, "the oldest command is forgotten")
+}
@@ -0,0 +1,318 @@
+package textcmd
+
+import (
+	"fmt"
+	"iv2/gourgeist/defs"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// Parse parses a command written as text, such as "/carbs 30 absorption=120",
+// against the commands registered on Discord. Arguments fill the options in
+// order, unless named as name=value, and the choices of an option can be
+// given by name or by value. The first argument of a command with
+// subcommands is the subcommand, passed as the subcommand option.
+func Parse(text string, cmds []api.CreateCommandData) (defs.CommandInteraction, error) {
+	args, err := fields(text)
+	if err != nil {
+		return defs.CommandInteraction{}, err
+	}
+	if len(args) == 0 {
+		return defs.CommandInteraction{}, fmt.Errorf("missing command")
+	}
+
+	// Bots can be mentioned after the command, e.g. /carbs@iv2bot.
+	name, _, _ := strings.Cut(strings.TrimPrefix(args[0], "/"), "@")
+	cmd, ok := find(name, cmds)
+	if !ok {
+		return defs.CommandInteraction{}, fmt.Errorf("unknown command: %s", name)
+	}
+
+	ci := defs.CommandInteraction{
+		Name:    cmd.Name,
+		Options: make([]defs.CommandInteractionOption, 0),
+	}
+	opts := []discord.CommandOption(cmd.Options)
+	args = args[1:]
+
+	if subs := subcommands(opts); len(subs) > 0 {
+		if len(args) == 0 {
+			return ci, fmt.Errorf("missing subcommand, one of: %s", strings.Join(names(subs), ", "))
+		}
+		var sub *discord.SubcommandOption
+		for _, s := range subs {
+			if strings.EqualFold(s.OptionName, args[0]) {
+				sub = s
+			}
+		}
+		if sub == nil {
+			return ci, fmt.Errorf("unknown subcommand: %s", args[0])
+		}
+
+		ci.Options = append(ci.Options, defs.CommandInteractionOption{
+			Name:  defs.SubcommandOption,
+			Value: sub.OptionName,
+		})
+		opts = make([]discord.CommandOption, len(sub.Options))
+		for i, opt := range sub.Options {
+			opts[i] = opt
+		}
+		args = args[1:]
+	}
+
+	values := make(map[string]string)
+	var next int
+	for _, arg := range args {
+		if k, v, ok := strings.Cut(arg, "="); ok && index(opts, k) >= 0 {
+			values[opts[index(opts, k)].Name()] = v
+			continue
+		}
+		for ; next < len(opts); next++ {
+			if _, ok := values[opts[next].Name()]; !ok {
+				break
+			}
+		}
+		if next >= len(opts) {
+			return ci, fmt.Errorf("too many arguments, usage: %s", Usage(cmd))
+		}
+		values[opts[next].Name()] = arg
+	}
+
+	// The options are passed in the order they are registered in, as some
+	// handlers rely on it.
+	for _, opt := range opts {
+		v, ok := values[opt.Name()]
+		if !ok {
+			if required(opt) {
+				return ci, fmt.Errorf("missing %s, usage: %s", opt.Name(), Usage(cmd))
+			}
+			continue
+		}
+		v, err := value(opt, v)
+		if err != nil {
+			return ci, err
+		}
+		ci.Options = append(ci.Options, defs.CommandInteractionOption{Name: opt.Name(), Value: v})
+	}
+
+	return ci, nil
+}
+
+// Usage returns how to write the command as text, with the optional options
+// in brackets.
+func Usage(cmd api.CreateCommandData) string {
+	opts := []discord.CommandOption(cmd.Options)
+	if subs := subcommands(opts); len(subs) > 0 {
+		return fmt.Sprintf("/%s %s", cmd.Name, strings.Join(names(subs), "|"))
+	}
+
+	parts := []string{"/" + cmd.Name}
+	for _, opt := range opts {
+		if required(opt) {
+			parts = append(parts, opt.Name())
+		} else {
+			parts = append(parts, "["+opt.Name()+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Help lists the usage and description of the commands.
+func Help(cmds []api.CreateCommandData) string {
+	lines := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		lines = append(lines, fmt.Sprintf("%s: %s", Usage(cmd), cmd.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Format writes the command as text that parses back to it, without the
+// leading slash, e.g. "snooze minutes=30 id=abc".
+func Format(ci defs.CommandInteraction) string {
+	parts := []string{ci.Name}
+	for _, opt := range ci.Options {
+		v := opt.Value
+		if v == "" || strings.ContainsAny(v, " \t\n") {
+			v = `"` + v + `"`
+		}
+		if opt.Name == defs.SubcommandOption {
+			parts = append(parts, v)
+		} else {
+			parts = append(parts, opt.Name+"="+v)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// EncodeCommand packs a command into a string, so that buttons can carry
+// the command they run.
+func EncodeCommand(ci defs.CommandInteraction) string {
+	parts := []string{ci.Name}
+	for _, opt := range ci.Options {
+		parts = append(parts, url.QueryEscape(opt.Name)+"="+url.QueryEscape(opt.Value))
+	}
+	return strings.Join(parts, "|")
+}
+
+// DecodeCommand is the inverse of EncodeCommand.
+func DecodeCommand(s string) defs.CommandInteraction {
+	parts := strings.Split(s, "|")
+	ci := defs.CommandInteraction{
+		Name:    parts[0],
+		Options: make([]defs.CommandInteractionOption, 0),
+	}
+	for _, part := range parts[1:] {
+		name, value, _ := strings.Cut(part, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		ci.Options = append(ci.Options, defs.CommandInteractionOption{Name: name, Value: value})
+	}
+	return ci
+}
+
+func find(name string, cmds []api.CreateCommandData) (api.CreateCommandData, bool) {
+	for _, cmd := range cmds {
+		if strings.EqualFold(cmd.Name, name) {
+			return cmd, true
+		}
+	}
+	return api.CreateCommandData{}, false
+}
+
+func subcommands(opts []discord.CommandOption) []*discord.SubcommandOption {
+	subs := make([]*discord.SubcommandOption, 0)
+	for _, opt := range opts {
+		if sub, ok := opt.(*discord.SubcommandOption); ok {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func names(subs []*discord.SubcommandOption) []string {
+	ns := make([]string, len(subs))
+	for i, sub := range subs {
+		ns[i] = sub.OptionName
+	}
+	return ns
+}
+
+func index(opts []discord.CommandOption, name string) int {
+	for i, opt := range opts {
+		if opt.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func required(opt discord.CommandOption) bool {
+	switch o := opt.(type) {
+	case *discord.StringOption:
+		return o.Required
+	case *discord.IntegerOption:
+		return o.Required
+	case *discord.NumberOption:
+		return o.Required
+	case *discord.BooleanOption:
+		return o.Required
+	}
+	return false
+}
+
+// value checks the argument against the type of the option, resolving the
+// name of a choice to its value.
+func value(opt discord.CommandOption, arg string) (string, error) {
+	var cs []choice
+	var parse func(string) error
+	switch o := opt.(type) {
+	case *discord.StringOption:
+		for _, c := range o.Choices {
+			cs = append(cs, choice{c.Name, c.Value})
+		}
+	case *discord.IntegerOption:
+		for _, c := range o.Choices {
+			cs = append(cs, choice{c.Name, strconv.Itoa(c.Value)})
+		}
+		parse = func(s string) error {
+			_, err := strconv.Atoi(s)
+			return err
+		}
+	case *discord.NumberOption:
+		for _, c := range o.Choices {
+			cs = append(cs, choice{c.Name, strconv.FormatFloat(c.Value, 'f', -1, 64)})
+		}
+		parse = func(s string) error {
+			_, err := strconv.ParseFloat(s, 64)
+			return err
+		}
+	case *discord.BooleanOption:
+		b, err := strconv.ParseBool(arg)
+		if err != nil {
+			return "", fmt.Errorf("%s must be true or false: %s", o.OptionName, arg)
+		}
+		return strconv.FormatBool(b), nil
+	}
+
+	if len(cs) > 0 {
+		names := make([]string, len(cs))
+		for i, c := range cs {
+			if c.matches(arg) {
+				return c.value, nil
+			}
+			names[i] = c.name
+		}
+		return "", fmt.Errorf("%s must be one of: %s", opt.Name(), strings.Join(names, ", "))
+	}
+	if parse != nil {
+		if err := parse(arg); err != nil {
+			return "", fmt.Errorf("%s must be a number: %s", opt.Name(), arg)
+		}
+	}
+	return arg, nil
+}
+
+type choice struct {
+	name  string
+	value string
+}
+
+// matches reports whether the argument is the choice, by value, name, or
+// the first word of the name, e.g. fast for "fast (2h)".
+func (c choice) matches(arg string) bool {
+	words := strings.Fields(c.name)
+	return c.value == arg || strings.EqualFold(c.name, arg) || len(words) > 0 && strings.EqualFold(words[0], arg)
+}
+
+// fields splits the text on whitespace, keeping double quoted text together.
+func fields(text string) ([]string, error) {
+	args := make([]string, 0)
+	var cur strings.Builder
+	var quoted, started bool
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if started {
+				args = append(args, cur.String())
+				cur.Reset()
+				started = false
+			}
+		default:
+			cur.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if started {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,121 @@
+package textcmd
+
+import (
+	"iv2/gourgeist/defs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TextCmdTestSuite struct {
+	suite.Suite
+}
+
+func TestTextCmdTestSuite(t *testing.T) {
+	suite.Run(t, new(TextCmdTestSuite))
+}
+
+func options(kvs ...string) []defs.CommandInteractionOption {
+	opts := make([]defs.CommandInteractionOption, 0)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		opts = append(opts, defs.CommandInteractionOption{Name: kvs[i], Value: kvs[i+1]})
+	}
+	return opts
+}
+
+func (suite *TextCmdTestSuite) TestParse() {
+	for _, tc := range []struct {
+		text string
+		want defs.CommandInteraction
+	}{
+		{"/carbs 30", defs.CommandInteraction{Name: defs.AddCarbsCmd, Options: options("amount", "30")}},
+		{"/carbs@iv2bot 30 slow", defs.CommandInteraction{
+			Name: defs.AddCarbsCmd, Options: options("amount", "30", "absorption", "240"),
+		}},
+		{"carbs absorption=120 45", defs.CommandInteraction{
+			Name: defs.AddCarbsCmd, Options: options("amount", "45", "absorption", "120"),
+		}},
+		{"/insulin rapid 4", defs.CommandInteraction{
+			Name: defs.AddInsulinCmd, Options: options("type", defs.RapidActing.String(), "units", "4"),
+		}},
+		{"/snooze 30m", defs.CommandInteraction{Name: defs.SnoozeCmd, Options: options("minutes", "30")}},
+		{`/sensor start 30 notes="left arm"`, defs.CommandInteraction{
+			Name:    defs.SensorCmd,
+			Options: options(defs.SubcommandOption, defs.SensorStart, "offset", "30", "notes", "left arm"),
+		}},
+		{"/sensor status", defs.CommandInteraction{
+			Name: defs.SensorCmd, Options: options(defs.SubcommandOption, defs.SensorStatus),
+		}},
+		{"/deadletters 1w replay=true", defs.CommandInteraction{
+			Name: defs.DeadLettersCmd, Options: options("hours", "168", "replay", "true"),
+		}},
+	} {
+		ci, err := Parse(tc.text, defs.Commands)
+		assert.NoError(suite.T(), err, tc.text)
+		assert.Equal(suite.T(), tc.want, ci, tc.text)
+	}
+}
+
+func (suite *TextCmdTestSuite) TestConfiguredPresets() {
+	defs.SetPresets([]string{defs.ExercisePreset, "swimming"})
+	defer defs.SetPresets([]string{defs.ExercisePreset, defs.PreMealPreset, defs.SickDayPreset})
+
+	ci, err := Parse("/temptarget swimming", defs.Commands)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), options("preset", "swimming"), ci.Options)
+
+	_, err = Parse("/temptarget cancel", defs.Commands)
+	assert.NoError(suite.T(), err, "cancelling is always a choice")
+	_, err = Parse("/temptarget premeal", defs.Commands)
+	assert.Error(suite.T(), err, "not a configured preset")
+}
+
+func (suite *TextCmdTestSuite) TestParseErrors() {
+	for _, text := range []string{
+		"",
+		"/unknown",
+		"/carbs",
+		"/carbs lots",
+		"/carbs 30 120 extra",
+		"/carbs 30 absorption=150",
+		"/sensor",
+		"/sensor restart",
+		`/sensor start notes="left arm`,
+	} {
+		_, err := Parse(text, defs.Commands)
+		assert.Error(suite.T(), err, text)
+	}
+}
+
+func (suite *TextCmdTestSuite) TestUsage() {
+	assert.Equal(suite.T(), "/carbs amount [absorption]", Usage(defs.Commands[0]))
+	assert.Contains(suite.T(), Help(defs.Commands), "/sensor start|stop|status")
+}
+
+func (suite *TextCmdTestSuite) TestEncodeCommand() {
+	ci := defs.CommandInteraction{Name: defs.AckCmd, Options: options("id", "a|b=c")}
+	assert.Equal(suite.T(), ci, DecodeCommand(EncodeCommand(ci)))
+
+	ci = DecodeCommand(EncodeCommand(defs.CommandInteraction{Name: "command"}))
+	assert.Equal(suite.T(), "command", ci.Name)
+	assert.Empty(suite.T(), ci.Options)
+}
+
+func (suite *TextCmdTestSuite) TestFormat() {
+	for _, ci := range []defs.CommandInteraction{
+		{Name: defs.SnoozeCmd, Options: options("minutes", "30", "id", "62a1")},
+		{Name: defs.AddInsulinCmd, Options: options(
+			"type", defs.RapidActing.String(), "units", "4.55", "suggestion", "ri2s0x",
+		)},
+		{Name: defs.SensorCmd, Options: options(defs.SubcommandOption, defs.SensorStart, "notes", "left arm")},
+	} {
+		text := Format(ci)
+		parsed, err := Parse("/"+text, defs.Commands)
+		assert.NoError(suite.T(), err, text)
+		assert.Equal(suite.T(), ci, parsed, text)
+	}
+	assert.Equal(suite.T(), "snooze minutes=30 id=62a1", Format(defs.CommandInteraction{
+		Name: defs.SnoozeCmd, Options: options("minutes", "30", "id", "62a1"),
+	}))
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/carbs"
 	dcr "iv2/gourgeist/pkg/desc"
 	"iv2/gourgeist/pkg/discgo"
 	"iv2/gourgeist/pkg/ghastly"
+	"iv2/gourgeist/pkg/insulin"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/quality"
 	"iv2/gourgeist/pkg/stats"
+	"iv2/gourgeist/pkg/targets"
 	"strconv"
 	"time"
 
@@ -19,6 +23,7 @@ type PlotterStore interface {
 	mg.GlucoseStore
 	mg.InsulinStore
 	mg.CarbStore
+	mg.TempTargetStore
 	mg.FileStore
 }
 
@@ -29,10 +34,15 @@ type PlotUpdater struct {
 	Plotter  ghastly.Plotter
 	Store    PlotterStore
 
-	Logger        *zap.Logger
-	Descriptor    *dcr.Descriptor
-	Location      *time.Location
-	GlucoseConfig defs.GlucoseConfig
+	Logger     *zap.Logger
+	Descriptor *dcr.Descriptor
+	Location   *time.Location
+	Targets    *targets.Resolver
+	Insulin    *insulin.Model
+	Carbs      *carbs.Model
+
+	// ExcludeSuspect leaves the suspect readings out of the statistics.
+	ExcludeSuspect bool
 }
 
 func (pu PlotUpdater) Update() error {
@@ -45,12 +55,12 @@ func (pu PlotUpdater) Update() error {
 		return err
 	}
 
-	insulin, err := pu.Store.ReadInsulin(ctx, start, end)
+	ins, err := pu.Store.ReadInsulin(ctx, start, end)
 	if err != nil {
 		return err
 	}
 
-	carbs, err := pu.Store.ReadCarbs(ctx, start, end)
+	cbs, err := pu.Store.ReadCarbs(ctx, start, end)
 	if err != nil {
 		return err
 	}
@@ -88,21 +98,40 @@ func (pu PlotUpdater) Update() error {
 		pu.Logger.Debug("unable to delete file", zap.Error(err))
 	}
 
-	ra := stats.TimeSpentInRange(glucose, pu.GlucoseConfig.Low, pu.GlucoseConfig.High)
+	tr, err := pu.Targets.Load(ctx, pu.Store, start, end)
+	if err != nil {
+		pu.Logger.Debug("unable to load temporary targets", zap.Error(err))
+		tr = pu.Targets
+	}
+	statsGlucose := glucose
+	if pu.ExcludeSuspect {
+		statsGlucose = quality.Reliable(glucose)
+	}
+	ra := stats.TimeSpentInRangeFunc(statsGlucose, tr.Range)
+	ob := onBoard{
+		Insulin:     pu.Insulin,
+		Carbs:       pu.Carbs,
+		Doses:       ins,
+		CarbEntries: cbs,
+		Glucose:     glucose,
+	}
 
 	embed := defs.EmbedData{
 		Title: recentGlucose.Time.In(pu.Location).Format(discgo.TimeFormat),
 		Fields: []defs.EmbedField{
 			{Name: "Current", Value: strconv.FormatFloat(recentGlucose.Mmol, 'f', 2, 64), Inline: true},
+			{Name: "COB", Value: fmt.Sprintf("%.0f g", ob.COB(end)), Inline: true},
+			{Name: "IOB", Value: fmt.Sprintf("%.2f u", ob.IOB(end)), Inline: true},
 			{Name: "Trend", Value: recentGlucose.Trend, Inline: true},
-			defs.EmptyEmbed(),
+			{Name: defs.RangeField, Value: tr.Describe(end), Inline: true},
 			{Name: "In Range", Value: strconv.FormatFloat(ra.InRange, 'f', 2, 64), Inline: true},
 			{Name: "Above Range", Value: strconv.FormatFloat(ra.AboveRange, 'f', 2, 64), Inline: true},
 			defs.EmptyEmbed(),
+			defs.EmptyEmbed(),
 		},
 	}
 
-	desc, err := pu.Descriptor.New(insulin, carbs)
+	desc, err := pu.Descriptor.New(ins, cbs)
 	if err == nil {
 		embed.Description = desc
 	}
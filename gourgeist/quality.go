@@ -0,0 +1,60 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/stats"
+	"time"
+)
+
+// qualityWindow is how far back the readings are checked for artifacts,
+// which covers the rebound of a compression.
+const qualityWindow = 3 * time.Hour
+
+// AnalyzeQuality flags the recent readings that are likely sensor artifacts
+// as suspect in the store.
+func (an *Analyzer) AnalyzeQuality(ctx context.Context) error {
+	if an.Quality == nil {
+		return nil
+	}
+
+	now := time.Now()
+	glucose, err := an.Store.ReadGlucose(ctx, now.Add(-qualityWindow), now)
+	if err != nil {
+		return err
+	}
+
+	for i, reason := range an.Quality.Annotate(glucose) {
+		if reason == "" || glucose[i].Suspect != "" {
+			continue
+		}
+		glucose[i].Suspect = reason
+		if _, err := an.Store.UpdateGlucose(ctx, &glucose[i]); err != nil {
+			return fmt.Errorf("unable to flag glucose: %w", err)
+		}
+	}
+	return nil
+}
+
+// reliable returns the readings that are neither flagged as suspect, nor
+// would be by the quality detector. Very low readings are kept regardless,
+// as missing an actual severe low is far worse than a false alarm.
+func (an *Analyzer) reliable(glucose []defs.TransformedReading) []defs.TransformedReading {
+	annotated := make([]defs.TransformedReading, len(glucose))
+	copy(annotated, glucose)
+	if an.Quality != nil {
+		for i, reason := range an.Quality.Annotate(glucose) {
+			if reason != "" {
+				annotated[i].Suspect = reason
+			}
+		}
+	}
+	for i := range annotated {
+		if annotated[i].Mmol < stats.VeryLowThreshold {
+			annotated[i].Suspect = ""
+		}
+	}
+	return quality.Reliable(annotated)
+}
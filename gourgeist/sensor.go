@@ -0,0 +1,117 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/discgo"
+	"iv2/gourgeist/pkg/sensors"
+	"time"
+)
+
+// sensorDetectWindow is how far back the readings are checked for the
+// warmup of a new sensor.
+const sensorDetectWindow = 24 * time.Hour
+
+// AnalyzeSensor records the sensors detected from the warmup gaps in the
+// readings, and reminds about the current sensor before it expires.
+func (an *Analyzer) AnalyzeSensor(ctx context.Context) error {
+	now := time.Now()
+	lifetime := an.SensorConfig.LifetimeDuration()
+
+	ss, err := an.Store.ReadSensors(ctx, now.Add(-lifetime-sensorDetectWindow), now)
+	if err != nil {
+		return err
+	}
+
+	glucose, err := an.Store.ReadGlucose(ctx, now.Add(-sensorDetectWindow), now)
+	if err != nil {
+		return err
+	}
+
+	warmup := minutesOr(an.SensorConfig.Warmup, defs.DefaultSensorWarmup)
+	for _, inserted := range sensors.Detect(glucose, warmup) {
+		s, err := an.detectSensor(ctx, ss, inserted, lifetime)
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			ss = append(ss, *s)
+		}
+	}
+
+	cur := sensors.Current(ss, now)
+	if cur == nil {
+		return nil
+	}
+
+	// Only the latest reminder that is due is sent.
+	reminders := an.SensorConfig.Reminders
+	if len(reminders) == 0 {
+		reminders = defs.DefaultSensorReminders
+	}
+	var due time.Time
+	for _, hours := range reminders {
+		at := cur.Expiry.Add(time.Duration(-hours) * time.Hour)
+		if !now.Before(at) && at.After(due) {
+			due = at
+		}
+	}
+	if due.IsZero() {
+		return nil
+	}
+
+	alerts, _ := an.Store.ReadAlerts(ctx, due, now)
+	if alertedSince(alerts, defs.SensorExpiryLabel, due) {
+		return nil
+	}
+	return an.genAndSendAlert(ctx, defs.SensorExpiryLabel, fmt.Sprintf(
+		"sensor expires in %s, at %s\ninserted: %s",
+		cur.Expiry.Sub(now).Round(time.Minute),
+		cur.Expiry.In(an.Location).Format(discgo.TimeFormat),
+		cur.Time.In(an.Location).Format(discgo.TimeFormat),
+	))
+}
+
+// detectSensor records the sensor inserted at the time, ending the one it
+// replaced, unless a session was already started since. Gaps while the
+// current sensor is not yet due to be replaced are outages, and ignored.
+func (an *Analyzer) detectSensor(ctx context.Context, ss []defs.SensorSession, inserted time.Time,
+	lifetime time.Duration) (*defs.SensorSession, error) {
+	warmup := minutesOr(an.SensorConfig.Warmup, defs.DefaultSensorWarmup)
+	for _, s := range ss {
+		if !s.Time.Before(inserted.Add(-warmup)) {
+			return nil, nil
+		}
+	}
+	if !sensors.Due(ss, inserted) {
+		return nil, nil
+	}
+
+	if prev := sensors.Current(ss, inserted); prev != nil {
+		prev.EndedAt = inserted
+		if _, err := an.Store.UpdateSensor(ctx, prev); err != nil {
+			return nil, fmt.Errorf("unable to end sensor: %w", err)
+		}
+	}
+
+	s := defs.SensorSession{
+		Time:   inserted,
+		Expiry: inserted.Add(lifetime),
+		Source: defs.SensorWarmupSource,
+	}
+	res, err := an.Store.WriteSensor(ctx, &s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write sensor: %w", err)
+	}
+	s.ID = res.UpsertedID
+
+	_, err = an.Messager.SendMessage(defs.MessageData{
+		Content: fmt.Sprintf(
+			"🩸 new sensor detected, inserted around %s, expires at %s\nuse /sensor start to correct it",
+			inserted.In(an.Location).Format(discgo.TimeFormat),
+			s.Expiry.In(an.Location).Format(discgo.TimeFormat),
+		),
+	}, defs.AlertsChannel)
+	return &s, err
+}
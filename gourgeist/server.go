@@ -5,12 +5,23 @@ import (
 	"fmt"
 	"iv2/gourgeist/commander"
 	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/bolus"
+	"iv2/gourgeist/pkg/carbs"
 	dcr "iv2/gourgeist/pkg/desc"
 	"iv2/gourgeist/pkg/dexcom"
 	"iv2/gourgeist/pkg/discgo"
 	"iv2/gourgeist/pkg/ghastly"
 	"iv2/gourgeist/pkg/http"
+	"iv2/gourgeist/pkg/insulin"
+	"iv2/gourgeist/pkg/matrix"
 	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/rules"
+	"iv2/gourgeist/pkg/schedule"
+	"iv2/gourgeist/pkg/slack"
+	"iv2/gourgeist/pkg/targets"
+	"iv2/gourgeist/pkg/telegram"
 	"strconv"
 	"time"
 
@@ -19,11 +30,18 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// display is where iv2 is shown and commanded from.
+type display interface {
+	discgo.Display
+	Setup(channels []string, cmdHandler defs.CommandInteractionHandler) error
+}
+
 type Gourgeist struct {
 	commandHandler commander.CommandHandler
-	fetcher        Fetcher
+	fetcher        *Fetcher
 	plotUpdater    PlotUpdater
 	analyzer       Analyzer
+	summarizer     *Summarizer
 	logger         *zap.Logger
 }
 
@@ -45,23 +63,50 @@ func NewGourgeist(cfg defs.Config) (*Gourgeist, error) {
 		return nil, fmt.Errorf("unable to create store: %w", err)
 	}
 
+	nf, err := notify.FromConfig(cfg.Notify, ms, loc, cfg.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create notifiers: %w", err)
+	}
+
 	dexcom := dexcom.New(cfg.Dexcom.Account, cfg.Dexcom.Password, cfg.Logger)
-	f := Fetcher{Source: dexcom, Store: ms, Logger: cfg.Logger}
+	f := &Fetcher{Source: dexcom, Store: ms, Notifier: nf, Logger: cfg.Logger}
+
+	im, err := insulin.New(cfg.Insulin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create insulin model: %w", err)
+	}
+
+	// Carb absorption is refined using the default therapy settings, unless
+	// configured otherwise.
+	if cfg.Carbs.ISF == 0 {
+		cfg.Carbs.ISF = cfg.Therapy.ISF
+	}
+	if cfg.Carbs.CSF == 0 && cfg.Therapy.ICR > 0 {
+		cfg.Carbs.CSF = cfg.Therapy.ISF / cfg.Therapy.ICR
+	}
+	cm, err := carbs.New(cfg.Carbs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create carb model: %w", err)
+	}
+
+	bp, err := bolus.New(cfg.Therapy, loc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create therapy profiles: %w", err)
+	}
 
 	// TODO: very hacky, will redo this some other day.
 	if cfg.Skeleton {
 		cfg.Logger.Info("starting iv2 in skeleton-mode")
 
-		go http.New(ms)
-		g := &Gourgeist{fetcher: f}
+		go http.New(ms, im, cfg.Quality.Exclude)
+		g := &Gourgeist{fetcher: f, logger: cfg.Logger}
 		g.runSkeleton()
 		return g, nil
 	}
 
-	guildID := strconv.Itoa(cfg.Discord.Guild)
-	dg, err := discgo.New(cfg.Discord.Token, guildID, cfg.Logger, loc)
+	dg, err := newDisplay(cfg, loc)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create discord link: %w", err)
+		return nil, err
 	}
 
 	conn, err := grpc.Dial(cfg.TrevenantAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -70,37 +115,120 @@ func NewGourgeist(cfg defs.Config) (*Gourgeist, error) {
 	}
 	gh := ghastly.New(conn, cfg.Logger)
 
-	ch := commander.CommandHandler{
-		Display:       dg,
-		Plotter:       gh,
-		Store:         ms,
-		Logger:        cfg.Logger,
-		Descriptor:    dcr.New(loc),
-		Location:      loc,
-		GlucoseConfig: cfg.Glucose,
+	rs, err := rules.FromConfig(cfg.Rules, cfg.Alarm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create alert rules: %w", err)
 	}
 
-	if err = dg.Setup([]string{defs.AlertsChannel, defs.ReportsChannel}, ch.CreateHandler()); err != nil {
-		return nil, fmt.Errorf("unable to setup discord link: %w", err)
+	tr, err := targets.New(cfg.Glucose, loc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create glucose targets: %w", err)
 	}
+	defs.SetPresets(tr.Presets())
 
-	pu := PlotUpdater{
-		Messager:      dg,
-		Plotter:       gh,
-		Store:         ms,
-		Logger:        cfg.Logger,
-		Descriptor:    dcr.New(loc),
-		Location:      loc,
-		GlucoseConfig: cfg.Glucose,
+	var qd *quality.Detector
+	if cfg.Quality.Enabled {
+		qd, err = quality.New(cfg.Quality, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create quality detector: %w", err)
+		}
+	}
+
+	priorities, err := rules.Priorities(cfg.Alarm.Priorities)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse alert priorities: %w", err)
+	}
+
+	var quiet *schedule.Window
+	if qc := cfg.Alarm.Quiet; qc.Start != "" {
+		window, err := schedule.ParseWindow(qc.Start, qc.End)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse quiet hours: %w", err)
+		}
+		quiet = &window
 	}
 
 	an := Analyzer{
 		Messager:      dg,
 		Store:         ms,
+		Fetcher:       f,
+		Rules:         rs,
+		Targets:       tr,
+		Insulin:       im,
+		Carbs:         cm,
+		Therapy:       bp,
+		Quality:       qd,
+		Quiet:         quiet,
+		Priorities:    priorities,
+		Notifier:      nf,
 		Logger:        cfg.Logger,
 		Location:      loc,
 		GlucoseConfig: cfg.Glucose,
 		AlarmConfig:   cfg.Alarm,
+		SensorConfig:  cfg.Sensor,
+	}
+	if err = an.RegisterChecks(); err != nil {
+		return nil, fmt.Errorf("unable to register analyzer checks: %w", err)
+	}
+
+	ch := commander.CommandHandler{
+		Display:    dg,
+		Plotter:    gh,
+		Store:      ms,
+		Logger:     cfg.Logger,
+		Descriptor: dcr.New(loc),
+		Location:   loc,
+		Targets:    tr,
+		Insulin:    im,
+		Therapy:    bp,
+		Stacking:   cfg.Alarm.Stacking,
+		Sensor:     cfg.Sensor,
+		Notifier:   nf,
+		Webhooks:   cfg.Notify.Webhooks,
+		Checks:     an.Checks,
+
+		ExcludeSuspect: cfg.Quality.Exclude,
+	}
+
+	channels := append([]string{defs.AlertsChannel, defs.ReportsChannel}, rules.Channels(rs)...)
+	if err = dg.Setup(channels, ch.CreateHandler()); err != nil {
+		return nil, fmt.Errorf("unable to setup display: %w", err)
+	}
+
+	pu := PlotUpdater{
+		Messager:   dg,
+		Plotter:    gh,
+		Store:      ms,
+		Logger:     cfg.Logger,
+		Descriptor: dcr.New(loc),
+		Location:   loc,
+		Targets:    tr,
+		Insulin:    im,
+		Carbs:      cm,
+
+		ExcludeSuspect: cfg.Quality.Exclude,
+	}
+
+	var su *Summarizer
+	if ec := cfg.Notify.Email; ec != nil && ec.Summary != nil {
+		em, err := notify.NewEmail(*ec, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create summary email: %w", err)
+		}
+		if _, err := schedule.OnDay(time.Now(), ec.Summary.At); err != nil {
+			return nil, fmt.Errorf("unable to parse summary time: %w", err)
+		}
+		su = &Summarizer{
+			Email:    em,
+			Plotter:  gh,
+			Store:    ms,
+			Targets:  tr,
+			Logger:   cfg.Logger,
+			Location: loc,
+			Config:   *ec.Summary,
+
+			ExcludeSuspect: cfg.Quality.Exclude,
+		}
 	}
 
 	g := &Gourgeist{
@@ -108,6 +236,7 @@ func NewGourgeist(cfg defs.Config) (*Gourgeist, error) {
 		fetcher:        f,
 		plotUpdater:    pu,
 		analyzer:       an,
+		summarizer:     su,
 		logger:         cfg.Logger,
 	}
 	g.run()
@@ -115,6 +244,37 @@ func NewGourgeist(cfg defs.Config) (*Gourgeist, error) {
 	return g, nil
 }
 
+func newDisplay(cfg defs.Config, loc *time.Location) (display, error) {
+	switch cfg.Display {
+	case "", defs.DiscordDisplay:
+		guildID := strconv.Itoa(cfg.Discord.Guild)
+		dg, err := discgo.New(cfg.Discord.Token, guildID, cfg.Logger, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create discord link: %w", err)
+		}
+		return dg, nil
+	case defs.SlackDisplay:
+		sl, err := slack.New(cfg.Slack, cfg.Logger, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create slack link: %w", err)
+		}
+		return sl, nil
+	case defs.TelegramDisplay:
+		tg, err := telegram.New(cfg.Telegram, cfg.Logger, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create telegram link: %w", err)
+		}
+		return tg, nil
+	case defs.MatrixDisplay:
+		mx, err := matrix.New(cfg.Matrix, cfg.Logger, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create matrix link: %w", err)
+		}
+		return mx, nil
+	}
+	return nil, fmt.Errorf("unknown display: %s", cfg.Display)
+}
+
 func (g *Gourgeist) runSkeleton() {
 	ticker := time.NewTicker(defs.DownloaderInterval)
 	defer ticker.Stop()
@@ -126,6 +286,10 @@ func (g *Gourgeist) runSkeleton() {
 }
 
 func (g *Gourgeist) run() {
+	if g.summarizer != nil {
+		go g.summarize()
+	}
+
 	ticker := time.NewTicker(defs.DownloaderInterval)
 	defer ticker.Stop()
 	for ; true; <-ticker.C {
@@ -140,3 +304,15 @@ func (g *Gourgeist) run() {
 		}
 	}
 }
+
+// summarize sends the summary on its own schedule, so that a slow SMTP
+// server never holds up the readings and the alerts.
+func (g *Gourgeist) summarize() {
+	ticker := time.NewTicker(defs.DownloaderInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		if err := g.summarizer.Summarize(); err != nil {
+			g.logger.Error("summary error", zap.Error(err))
+		}
+	}
+}
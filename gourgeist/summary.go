@@ -0,0 +1,160 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"iv2/gourgeist/pkg/ghastly"
+	"iv2/gourgeist/pkg/ghastly/proto"
+	"iv2/gourgeist/pkg/mg"
+	"iv2/gourgeist/pkg/notify"
+	"iv2/gourgeist/pkg/quality"
+	"iv2/gourgeist/pkg/schedule"
+	"iv2/gourgeist/pkg/stats"
+	"iv2/gourgeist/pkg/targets"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// summaryDayFormat is how the days of the summary are shown.
+const summaryDayFormat = "01/02"
+
+type SummaryStore interface {
+	mg.GlucoseStore
+	mg.AlertStore
+	mg.TempTargetStore
+	mg.FileStore
+}
+
+// Summarizer emails a summary of the glucose and the alerts every day.
+type Summarizer struct {
+	Email    *notify.Email
+	Plotter  ghastly.Plotter
+	Store    SummaryStore
+	Targets  *targets.Resolver
+	Logger   *zap.Logger
+	Location *time.Location
+	Config   defs.SummaryConfig
+
+	// ExcludeSuspect leaves the suspect readings out of the statistics.
+	ExcludeSuspect bool
+
+	last time.Time
+}
+
+// Summarize sends the summary once it is due for the day, until it is sent.
+// The first call only starts the schedule, so that restarting never sends
+// it twice.
+func (s *Summarizer) Summarize() error {
+	now := time.Now().In(s.Location)
+	due, err := schedule.OnDay(now, s.Config.At)
+	if err != nil {
+		return err
+	}
+
+	if s.last.IsZero() {
+		s.last = now
+		return nil
+	}
+	if now.Before(due) || !s.last.Before(due) {
+		return nil
+	}
+
+	days := s.Config.Days
+	if days <= 0 {
+		days = defs.DefaultSummaryDays
+	}
+	if err := s.Send(now.AddDate(0, 0, -days), now); err != nil {
+		return err
+	}
+	s.last = now
+	return nil
+}
+
+// Send emails the summary of the glucose and the alerts between start and
+// end, with the plot attached if enabled.
+func (s *Summarizer) Send(start, end time.Time) error {
+	ctx := context.Background()
+
+	glucose, err := s.Store.ReadGlucose(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	if s.ExcludeSuspect {
+		glucose = quality.Reliable(glucose)
+	}
+
+	alerts, err := s.Store.ReadAlerts(ctx, start, end)
+	if err != nil {
+		return err
+	}
+
+	tr, err := s.Targets.Load(ctx, s.Store, start, end)
+	if err != nil {
+		return err
+	}
+
+	page := notify.Page{Title: fmt.Sprintf(
+		"%s to %s", start.In(s.Location).Format(summaryDayFormat), end.In(s.Location).Format(summaryDayFormat),
+	)}
+	if len(glucose) == 0 {
+		page.Lines = append(page.Lines, "no readings")
+	} else {
+		ra := stats.TimeSpentInRangeFunc(glucose, tr.Range)
+		ss := stats.GlucoseSummary(glucose)
+		page.Fields = []notify.Field{
+			{Name: "Average", Value: fmt.Sprintf("%.2f", ss.Average)},
+			{Name: "Deviation", Value: fmt.Sprintf("%.2f", ss.Deviation)},
+			{Name: "Below Range", Value: percent(ra.BelowRange)},
+			{Name: "In Range", Value: percent(ra.InRange)},
+			{Name: "Above Range", Value: percent(ra.AboveRange)},
+		}
+	}
+
+	page.Lines = append(page.Lines, fmt.Sprintf("%d alerts", len(alerts)))
+	for _, a := range alerts {
+		reason := strings.SplitN(a.Reason, "\n", 2)[0]
+		page.Lines = append(page.Lines, fmt.Sprintf(
+			"%s %s: %s", a.Time.In(s.Location).Format(summaryDayFormat+" 15:04"), a.Label, reason,
+		))
+	}
+
+	var attachments []notify.Attachment
+	if s.Config.Plot && s.Plotter != nil {
+		if a, err := s.plot(ctx, start, end); err != nil {
+			s.Logger.Debug("unable to attach plot", zap.Error(err))
+		} else {
+			attachments = append(attachments, *a)
+		}
+	}
+
+	return s.Email.Send("📈 iv2 summary, "+page.Title, page, attachments...)
+}
+
+func (s *Summarizer) plot(ctx context.Context, start, end time.Time) (*notify.Attachment, error) {
+	var fr *proto.FileResponse
+	var err error
+	if end.Sub(start) > 24*time.Hour {
+		fr, err = s.Plotter.GenerateWeeklyPlot(ctx, start, end)
+	} else {
+		fr, err = s.Plotter.GenerateDailyPlot(ctx, start, end)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate plot: %w", err)
+	}
+
+	r, err := s.Store.ReadFile(ctx, fr.GetId())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file: %w", err)
+	}
+	if err := s.Store.DeleteFile(ctx, fr.GetId()); err != nil {
+		s.Logger.Debug("unable to delete file", zap.Error(err))
+	}
+	return &notify.Attachment{Name: fr.GetName(), Reader: r}, nil
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.0f%%", f*100)
+}
@@ -0,0 +1,136 @@
+package gourgeist
+
+import (
+	"context"
+	"fmt"
+	"iv2/gourgeist/defs"
+	"time"
+)
+
+// startTreatment opens a treatment for the low alert, unless one is already
+// open, and prompts to treat it.
+func (an *Analyzer) startTreatment(ctx context.Context, alert defs.Alert, glucose float64) error {
+	open, err := an.openTreatment(ctx, alert.Time)
+	if err != nil || open != nil {
+		return err
+	}
+
+	t := defs.Treatment{
+		Time:       alert.Time,
+		AlertID:    alert.ID,
+		Glucose:    glucose,
+		Nadir:      glucose,
+		Prompts:    1,
+		LastPrompt: alert.Time,
+	}
+	if _, err := an.Store.WriteTreatment(ctx, &t); err != nil {
+		return fmt.Errorf("unable to write treatment: %w", err)
+	}
+
+	return an.promptTreatment(glucose, false)
+}
+
+// AnalyzeTreatments re-checks the open treatment against the readings
+// since the last prompt, prompting again while glucose is still low, and
+// closing it with a summary once it is back in range.
+func (an *Analyzer) AnalyzeTreatments(ctx context.Context) error {
+	now := time.Now()
+
+	t, err := an.openTreatment(ctx, now)
+	if err != nil || t == nil {
+		return err
+	}
+
+	recheck := minutesOr(an.AlarmConfig.Treatment.Recheck, defs.DefaultTreatmentRecheck)
+	if now.Before(t.LastPrompt.Add(recheck)) {
+		return nil
+	}
+
+	// Only fresh readings count, the re-check waits for them otherwise.
+	glucose, err := an.Store.ReadGlucose(ctx, t.LastPrompt.Add(time.Second), now)
+	if err != nil || len(glucose) == 0 {
+		return err
+	}
+	for _, tr := range glucose {
+		if tr.Mmol < t.Nadir {
+			t.Nadir = tr.Mmol
+		}
+	}
+	latest := glucose[len(glucose)-1]
+
+	tr, err := an.Targets.Load(ctx, an.Store, latest.Time, latest.Time)
+	if err != nil {
+		return err
+	}
+
+	if latest.Mmol <= tr.Thresholds(latest.Time).Low {
+		t.Prompts++
+		t.LastPrompt = now
+		if _, err := an.Store.UpdateTreatment(ctx, t); err != nil {
+			return fmt.Errorf("unable to update treatment: %w", err)
+		}
+		return an.promptTreatment(latest.Mmol, true)
+	}
+
+	t.ClosedAt = now
+	if _, err := an.Store.UpdateTreatment(ctx, t); err != nil {
+		return fmt.Errorf("unable to close treatment: %w", err)
+	}
+
+	cs, err := an.Store.ReadCarbs(ctx, t.Time, now)
+	if err != nil {
+		return err
+	}
+	var carbs float64
+	for _, c := range cs {
+		carbs += c.Amount
+	}
+
+	_, err = an.Messager.SendMessage(defs.MessageData{
+		Content: fmt.Sprintf(
+			"✅ low treated in %s\nstart: %.2f, nadir: %.2f, current value: %.2f\ncarbs logged: %.0fg, prompts: %d",
+			now.Sub(t.Time).Round(time.Minute), t.Glucose, t.Nadir, latest.Mmol, carbs, t.Prompts,
+		),
+	}, defs.AlertsChannel)
+	return err
+}
+
+func (an *Analyzer) promptTreatment(glucose float64, again bool) error {
+	grams := an.AlarmConfig.Treatment.Carbs
+	if grams <= 0 {
+		grams = defs.DefaultTreatmentCarbs
+	}
+	recheck := minutesOr(an.AlarmConfig.Treatment.Recheck, defs.DefaultTreatmentRecheck)
+
+	content := fmt.Sprintf(
+		"🍬 treat the low with %dg of fast carbs, re-checking in %s\ncurrent value: %.2f",
+		grams, recheck, glucose,
+	)
+	if again {
+		content = fmt.Sprintf(
+			"🍬 still low, treat with another %dg of fast carbs, re-checking in %s\ncurrent value: %.2f\n@everyone",
+			grams, recheck, glucose,
+		)
+	}
+
+	_, err := an.Messager.SendMessage(defs.MessageData{
+		Content:         content,
+		Buttons:         []defs.ButtonData{carbsButton(grams, fastAbsorption)},
+		MentionEveryone: again,
+	}, defs.AlertsChannel)
+	return err
+}
+
+// openTreatment returns the treatment that is still open at t, if any.
+func (an *Analyzer) openTreatment(ctx context.Context, t time.Time) (*defs.Treatment, error) {
+	ts, err := an.Store.ReadTreatments(ctx, t.Add(defs.LookbackInterval), t)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ts) - 1; i >= 0; i-- {
+		if !ts[i].Closed() {
+			return &ts[i], nil
+		}
+	}
+	return nil, nil
+}